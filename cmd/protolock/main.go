@@ -4,9 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/nilslice/protolock"
+	"github.com/nilslice/protolock/registry"
 )
 
 const info = `Track your .proto files and prevent changes to messages and services which impact API compatibilty.
@@ -22,32 +26,95 @@ Usage:
 Commands:
 	-h, --help, help	display the usage information for protolock
 	init			initialize a proto.lock file from current tree
+			with --setup, also detect the proto roots and vendored directories, and
+			write protolock.json, .gitattributes, a pre-commit hook and CI snippets
 	status			check for breaking changes and report conflicts
 	commit			rewrite proto.lock file with current tree if no conflicts (--force to override)
+	untrack			remove the files or packages given as arguments from proto.lock (requires --reason)
+	deprecate		mark the field or enum value given as argument (e.g. pkg.Message.field) deprecated
+	remove			remove the field or enum value given as argument, reserving its number and name
+	rename			rename the field or enum value given as first argument to the second, with a rename hint
+	diagram			render a class diagram of the proto.lock definitions
+	export			regenerate .proto files from the proto.lock definitions
+	next-id			suggest the next field or enum value number of the message or enum given as argument
+	fingerprint		print the wire fingerprints of the messages given as arguments (default: all)
+	check-generated		check that the *.pb.go files in the directory given as argument match proto.lock
+	migrate-syntax-check	list the semantic changes of migrating the file given as argument to the syntax given by --to
+	check-docs		check the proto snippets of the Markdown files in the directory given as argument against proto.lock
+	serve			run a schema registry server (requires --confluent)
+	merge-locks		merge the partial lock files of each shard into proto.lock
+	merge-reports		merge the JSON reports of each shard and run the cross-file rules
+	merge-driver		merge the base, ours and theirs proto.lock files given as arguments into ours
 
 Options:
 	--strict [true]		enable strict mode and enforce all built-in rules
 	--debug	[false]		enable debug mode and output debug messages
 	--ignore 		comma-separated list of filepaths to ignore
 	--force [false]		forces commit to rewrite proto.lock file and disregards warnings
+			forces init --setup to overwrite existing files
+	--setup [false]		init scaffolds the configuration of protolock in the lockdir
 	--plugins 		comma-separated list of executable protolock plugin names
 	--lockdir [.]		directory of proto.lock file
+	--config 		path to the protolock.json settings file (default: in --lockdir)
 	--protoroot [.]		root of directory tree containing proto files
 	--uptodate [false]	enforce that proto.lock file is up-to-date with proto files
+	--scan-go 		directory of Go code to scan for references to removed fields, enum values and RPCs
+	--scan-go-fail [false]	also report referenced removals which have no other warning
+	--team 			team authoring the changes, whose field number ranges are enforced
+	--shard 		only process shard i of n of the proto files, as "i/n"
+	--format 		diagram format, one of: mermaid (default), plantuml
+			export format, one of: proto (default)
+	--outdir 		directory into which export writes the .proto files (default: a new temporary directory)
+	--reason 		reason recorded in proto.lock for the files removed by untrack
+	--sunset 		date (YYYY-MM-DD) recorded by deprecate in a sunset hint
+	--package 		only diagram types within a package
+	--root 			only diagram types reachable from a message, enum or service
+	--depth [0]		maximum number of references followed from --root (0 = unlimited)
+	--against 		path to a previous proto.lock file, highlights changes in the diagram
+	--output 		write the status report as <format>=<path> (repeatable, "-" for stdout)
+			formats: text, json, sarif, junit, markdown
+	--to 			target syntax of migrate-syntax-check, one of: proto3, 2023
+	--confluent [false]	serve the Confluent Schema Registry REST API
+	--addr [:8081]		address for the registry server to listen on
+	--registry [protolock.registry.json]
+			file storing the subjects and schemas of the registry
 `
 
 var (
-	options   = flag.NewFlagSet("options", flag.ExitOnError)
-	debug     = options.Bool("debug", false, "toggle debug mode for verbose output")
-	strict    = options.Bool("strict", true, "enable strict mode and enforce all built-in rules")
-	ignore    = options.String("ignore", "", "comma-separated list of filepaths to ignore")
-	force     = options.Bool("force", false, "force commit to rewrite proto.lock file and disregard warnings")
-	plugins   = options.String("plugins", "", "comma-separated list of executable protolock plugin names")
-	lockDir   = options.String("lockdir", ".", "directory of proto.lock file")
-	protoRoot = options.String("protoroot", ".", "root of directory tree containing proto files")
-	upToDate  = options.Bool("uptodate", false, "enforce that proto.lock file is up-to-date with proto files")
+	options    = flag.NewFlagSet("options", flag.ExitOnError)
+	debug      = options.Bool("debug", false, "toggle debug mode for verbose output")
+	strict     = options.Bool("strict", true, "enable strict mode and enforce all built-in rules")
+	ignore     = options.String("ignore", "", "comma-separated list of filepaths to ignore")
+	force      = options.Bool("force", false, "force commit to rewrite proto.lock file and disregard warnings")
+	setup      = options.Bool("setup", false, "init scaffolds the configuration of protolock in the lockdir")
+	plugins    = options.String("plugins", "", "comma-separated list of executable protolock plugin names")
+	lockDir    = options.String("lockdir", ".", "directory of proto.lock file")
+	settings   = options.String("config", "", "path to the protolock.json settings file (default: in --lockdir)")
+	protoRoot  = options.String("protoroot", ".", "root of directory tree containing proto files")
+	upToDate   = options.Bool("uptodate", false, "enforce that proto.lock file is up-to-date with proto files")
+	scanGo     = options.String("scan-go", "", "directory of Go code to scan for references to removed fields, enum values and RPCs")
+	scanGoFail = options.Bool("scan-go-fail", false, "also report referenced removals which have no other warning")
+	team       = options.String("team", "", "team authoring the changes, whose field number ranges are enforced")
+	shard      = options.String("shard", "", `only process shard i of n of the proto files, as "i/n"`)
+	format     = options.String("format", "", "diagram format (mermaid, plantuml) or export format (proto)")
+	outDir     = options.String("outdir", "", "directory into which export writes the .proto files (default: a new temporary directory)")
+	reason     = options.String("reason", "", "reason recorded in proto.lock for the files removed by untrack")
+	sunset     = options.String("sunset", "", "date (YYYY-MM-DD) recorded by deprecate in a sunset hint")
+	pkg        = options.String("package", "", "only diagram types within a package")
+	root       = options.String("root", "", "only diagram types reachable from a message, enum or service")
+	depth      = options.Int("depth", 0, "maximum number of references followed from --root (0 = unlimited)")
+	against    = options.String("against", "", "path to a previous proto.lock file, highlights changes in the diagram")
+	to         = options.String("to", "", "target syntax of migrate-syntax-check, one of: proto3, 2023")
+	confluent  = options.Bool("confluent", false, "serve the Confluent Schema Registry REST API")
+	addr       = options.String("addr", ":8081", "address for the registry server to listen on")
+	regFile    = options.String("registry", "protolock.registry.json", "file storing the subjects and schemas of the registry")
+	outputs    outputList
 )
 
+func init() {
+	options.Var(&outputs, "output", `write the status report as <format>=<path> (repeatable, "-" for stdout)`)
+}
+
 func main() {
 	// exit if no command (i.e. help, -h, --help, init, status, or commit)
 	if len(os.Args) < 2 {
@@ -57,6 +124,13 @@ func main() {
 
 	// parse and set options flags
 	options.Parse(os.Args[2:])
+	// the options of migrate-syntax-check may also follow its file, and are
+	// parsed before they configure anything
+	var migrateFile string
+	if os.Args[1] == "migrate-syntax-check" && options.NArg() > 0 {
+		migrateFile = options.Arg(0)
+		options.Parse(options.Args()[1:])
+	}
 	protolock.SetDebug(*debug)
 	protolock.SetStrict(*strict)
 
@@ -66,12 +140,42 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *shard != "" {
+		cfg.Shard, err = protolock.ParseShard(*shard)
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+	}
+
+	settingsPath := *settings
+	if settingsPath == "" {
+		settingsPath = cfg.SettingsFilePath()
+	}
+	s, err := protolock.LoadSettings(settingsPath)
+	if err != nil {
+		fmt.Println("[protolock]:", err)
+		os.Exit(1)
+	}
+	if *team != "" {
+		s.Numbers.Team = *team
+	}
+	if *ignore == "" {
+		cfg.Ignore = strings.Join(s.Ignore, ",")
+	}
+	protolock.SetSettings(s)
+
 	// switch through known commands
 	switch os.Args[1] {
 	case "-h", "--help", "help":
 		fmt.Println(usage)
 
 	case "init":
+		if *setup {
+			setupRepository(cfg)
+			return
+		}
+
 		r, err := protolock.Init(*cfg)
 		if err != nil {
 			fmt.Println(err)
@@ -97,24 +201,311 @@ func main() {
 			os.Exit(1)
 		}
 
+		// a shard writes its partial lock file, to be merged by
+		// merge-locks once all shards are committed
+		path := cfg.LockFilePath()
+		if cfg.Sharded() {
+			path = cfg.ShardLockFilePath()
+		}
+		err = saveToFile(path, r)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "untrack":
+		r, paths, err := protolock.Untrack(*cfg, *reason, options.Args()...)
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+
 		err = saveToLockFile(*cfg, r)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
+		for _, path := range paths {
+			fmt.Println("untracked:", path)
+		}
+
+	case "deprecate", "remove", "rename":
+		refactor(cfg, os.Args[1])
+
 	case "status":
+		// a shard writes its partial report as JSON, to be merged by
+		// merge-reports once all shards are done
+		if cfg.Sharded() && len(outputs) == 0 {
+			outputs.Set(protolock.FormatJSON + "=-")
+		}
 		status(cfg)
 
+	case "merge-locks":
+		r, err := protolock.MergeLockFiles(options.Args())
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+
+		err = saveToLockFile(*cfg, r)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "merge-reports":
+		report, err := protolock.MergeReportFiles(options.Args())
+		if err == nil && cfg.UpToDate && !report.Current.Equal(&report.Updated) {
+			err = protolock.ErrOutOfDate
+		}
+		handleReport(cfg, report, err)
+
+	case "merge-driver":
+		if options.NArg() != 3 {
+			fmt.Println("[protolock]: merge-driver requires the base, ours and theirs proto.lock files")
+			os.Exit(1)
+		}
+
+		r, conflicts, err := protolock.MergeLockVersionFiles(options.Arg(0), options.Arg(1), options.Arg(2))
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+
+		err = saveToFile(options.Arg(1), r)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// git leaves the file conflicted, to be resolved by a commit of the
+		// merged tree
+		for _, path := range conflicts {
+			fmt.Println("[protolock]: conflicting changes to", path, "run 'protolock commit' once merged")
+		}
+		if len(conflicts) > 0 {
+			os.Exit(1)
+		}
+
+	case "diagram":
+		r, err := protolock.Diagram(*cfg, protolock.DiagramOptions{
+			Format:  *format,
+			Package: *pkg,
+			Root:    *root,
+			Depth:   *depth,
+			Against: *against,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		_, err = io.Copy(os.Stdout, r)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "export":
+		paths, err := protolock.Export(*cfg, protolock.ExportOptions{
+			Format: *format,
+			Dir:    *outDir,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+
+	case "next-id":
+		if options.NArg() != 1 {
+			fmt.Println("[protolock]: next-id requires a message or enum, e.g. pkg.Message")
+			os.Exit(1)
+		}
+
+		id, err := protolock.NextID(*cfg, options.Arg(0))
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+		fmt.Println(id)
+
+	case "fingerprint":
+		fingerprints, err := protolock.Fingerprint(*cfg, options.Args()...)
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+
+		for _, f := range fingerprints {
+			if f.Changed() {
+				fmt.Printf("%s  %s (changed, locked as %s)\n", f.Fingerprint, f.Name, f.Locked)
+				continue
+			}
+			fmt.Printf("%s  %s\n", f.Fingerprint, f.Name)
+		}
+
+	case "check-generated":
+		if options.NArg() != 1 {
+			fmt.Println("[protolock]: check-generated requires the directory of the generated code")
+			os.Exit(1)
+		}
+
+		report, err := protolock.CheckGenerated(*cfg, options.Arg(0))
+		handleReport(cfg, report, err)
+
+	case "migrate-syntax-check":
+		if migrateFile == "" || options.NArg() != 0 {
+			fmt.Println("[protolock]: migrate-syntax-check requires a file, e.g. billing/v1/invoice.proto --to=proto3")
+			os.Exit(1)
+		}
+
+		impacts, err := protolock.MigrateSyntaxCheck(*cfg, migrateFile, *to)
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+
+		if len(impacts) == 0 {
+			fmt.Println("[protolock]: no semantic changes migrating", migrateFile, "to", *to)
+		}
+		for _, impact := range impacts {
+			fmt.Println(impact)
+		}
+
+	case "check-docs":
+		if options.NArg() != 1 {
+			fmt.Println("[protolock]: check-docs requires the directory of the Markdown files")
+			os.Exit(1)
+		}
+
+		report, err := protolock.CheckDocs(*cfg, options.Arg(0))
+		handleReport(cfg, report, err)
+
+	case "serve":
+		if !*confluent {
+			fmt.Println("[protolock]: serve requires a mode, available: --confluent")
+			os.Exit(1)
+		}
+
+		store, err := registry.OpenStore(*regFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println("[protolock]: schema registry listening on", *addr)
+		err = http.ListenAndServe(*addr, registry.NewServer(store))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
 	default:
 		os.Exit(0)
 	}
 }
 
+// setupRepository scaffolds the configuration of protolock in the lockdir,
+// and prints what it created.
+func setupRepository(cfg *protolock.Config) {
+	opts := protolock.SetupOptions{Force: *force}
+	// the proto root is detected, unless given
+	options.Visit(func(f *flag.Flag) {
+		if f.Name == "protoroot" {
+			opts.ProtoRoot = f.Value.String()
+		}
+	})
+	if opts.ProtoRoot != "" {
+		rel, err := filepath.Rel(cfg.LockDir, cfg.ProtoRoot)
+		if err != nil {
+			fmt.Println("[protolock]:", err)
+			os.Exit(1)
+		}
+		opts.ProtoRoot = filepath.ToSlash(rel)
+	}
+
+	summary, err := protolock.Setup(*cfg, opts)
+	if err != nil {
+		fmt.Println("[protolock]:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("[protolock]: proto root:", summary.ProtoRoot)
+	for _, dir := range summary.Vendored {
+		fmt.Println("[protolock]: vendored:", dir)
+	}
+	for _, file := range summary.Files {
+		if file.Note != "" {
+			fmt.Printf("%s: %s (%s)\n", file.Action, file.Path, file.Note)
+			continue
+		}
+		fmt.Printf("%s: %s\n", file.Action, file.Path)
+	}
+	fmt.Println("[protolock]: register the diff and merge drivers of proto.lock with:")
+	for _, cmd := range summary.GitConfig {
+		fmt.Println("\t" + cmd)
+	}
+}
+
 func status(cfg *protolock.Config) {
 	report, err := protolock.Status(*cfg)
+	if report != nil && *scanGo != "" {
+		if serr := protolock.ScanGo(report, *scanGo, *scanGoFail); serr != nil {
+			fmt.Fprintln(diagnostics(), "[protolock]:", serr)
+			os.Exit(1)
+		}
+		if err == nil && len(report.Warnings) > 0 {
+			err = protolock.ErrWarningsFound
+		}
+	}
+	// plugins may relate definitions of different files, so they are left to
+	// merge-reports when sharded
+	if cfg.Sharded() {
+		*plugins = ""
+	}
+	handleReport(cfg, report, err)
+}
+
+// refactor edits the proto file of a field or enum value, and then reports
+// the comparison of the result with the proto.lock file.
+func refactor(cfg *protolock.Config, command string) {
+	args := options.Args()
+	var path protolock.Protopath
+	var report *protolock.Report
+	var err error
+	switch {
+	case command == "rename" && len(args) == 2:
+		path, report, err = protolock.Rename(*cfg, args[0], args[1])
+	case command == "deprecate" && len(args) == 1:
+		path, report, err = protolock.Deprecate(*cfg, args[0], *sunset)
+	case command == "remove" && len(args) == 1:
+		path, report, err = protolock.Remove(*cfg, args[0])
+	case command == "rename":
+		err = fmt.Errorf("rename requires a target and a new name, e.g. pkg.Message.field new_name")
+	default:
+		err = fmt.Errorf("%s requires a target, e.g. pkg.Message.field", command)
+	}
+
+	if path != "" {
+		fmt.Println("[protolock]: updated", path)
+	} else if report != nil {
+		fmt.Fprintln(diagnostics(), "[protolock]: not updated, the result conflicts with proto.lock")
+	}
+	handleReport(cfg, report, err)
+	fmt.Println("[protolock]: no conflicts with proto.lock")
+}
+
+func handleReport(cfg *protolock.Config, report *protolock.Report, err error) {
 	if err == protolock.ErrOutOfDate {
-		fmt.Println("[protolock]:", err, "run 'protolock commit'")
+		fmt.Fprintln(diagnostics(), "[protolock]:", err, "run 'protolock commit'")
+		if report != nil && report.Current.WireEqual(&report.Updated) {
+			fmt.Fprintln(diagnostics(), "[protolock]: the wire format of the messages is unchanged")
+		}
 		// only exit if flag provided for backwards compatibility
 		if cfg.UpToDate {
 			os.Exit(2)
@@ -123,7 +514,7 @@ func status(cfg *protolock.Config) {
 		err = nil
 	}
 	if err != protolock.ErrWarningsFound && err != nil {
-		fmt.Println("[protolock]:", err)
+		fmt.Fprintln(diagnostics(), "[protolock]:", err)
 		os.Exit(1)
 	}
 	// if plugins are provided, attempt to execute each as a executable
@@ -132,14 +523,27 @@ func status(cfg *protolock.Config) {
 	if *plugins != "" {
 		report, err = runPlugins(*plugins, report)
 		if err != nil {
-			fmt.Println("[protolock]:", err)
+			fmt.Fprintln(diagnostics(), "[protolock]:", err)
+			os.Exit(1)
+		}
+	}
+
+	// if outputs are provided, render the same report into each of them,
+	// otherwise write the default text report to stdout
+	if len(outputs) > 0 {
+		if werr := writeOutputs(outputs, report); werr != nil {
+			fmt.Fprintln(diagnostics(), "[protolock]:", werr)
 			os.Exit(1)
 		}
+		if len(report.Warnings) > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
 	code, err := protolock.HandleReport(report, os.Stdout, err)
 	if err != protolock.ErrWarningsFound && err != nil {
-		fmt.Println("[protolock]:", err)
+		fmt.Fprintln(diagnostics(), "[protolock]:", err)
 		os.Exit(1)
 	}
 
@@ -149,7 +553,11 @@ func status(cfg *protolock.Config) {
 }
 
 func saveToLockFile(cfg protolock.Config, r io.Reader) error {
-	lockfile, err := os.Create(cfg.LockFilePath())
+	return saveToFile(cfg.LockFilePath(), r)
+}
+
+func saveToFile(path string, r io.Reader) error {
+	lockfile, err := os.Create(path)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nilslice/protolock"
+)
+
+// output is a single report format and the path it is written to, where a
+// path of "-" writes to stdout.
+type output struct {
+	format string
+	path   string
+}
+
+// outputList implements flag.Value so that the --output flag may be
+// provided more than once.
+type outputList []output
+
+func (o *outputList) String() string {
+	var list []string
+	for _, out := range *o {
+		list = append(list, out.format+"="+out.path)
+	}
+	return strings.Join(list, ",")
+}
+
+func (o *outputList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid output %q, expected <format>=<path>", value)
+	}
+
+	switch parts[0] {
+	case protolock.FormatText, protolock.FormatJSON,
+		protolock.FormatSARIF, protolock.FormatJUnit, protolock.FormatMarkdown:
+	default:
+		return protolock.ErrUnknownReportFormat
+	}
+
+	*o = append(*o, output{format: parts[0], path: parts[1]})
+	return nil
+}
+
+// diagnostics returns the writer of the messages accompanying a report:
+// stderr if the report is written to stdout, so that it is left intact for
+// the tools consuming it, e.g. merge-reports, or stdout otherwise.
+func diagnostics() io.Writer {
+	for _, out := range outputs {
+		if out.path == "-" {
+			return os.Stderr
+		}
+	}
+
+	return os.Stdout
+}
+
+// writeOutputs renders the same report into each of the requested outputs.
+func writeOutputs(outputs outputList, report *protolock.Report) error {
+	for _, out := range outputs {
+		err := writeOutput(out, report)
+		if err != nil {
+			return fmt.Errorf("%s=%s: %v", out.format, out.path, err)
+		}
+	}
+
+	return nil
+}
+
+func writeOutput(out output, report *protolock.Report) error {
+	var w io.Writer = os.Stdout
+	if out.path != "-" {
+		f, err := os.Create(out.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return protolock.WriteReport(report, out.format, w)
+}
@@ -10,6 +10,9 @@ type Config struct {
 	ProtoRoot string
 	Ignore    string
 	UpToDate  bool
+	// Shard limits the files processed to a single shard, if its Count is
+	// not zero.
+	Shard Shard
 }
 
 func NewConfig(lockDir, protoRoot, ignores string, upToDate bool) (*Config, error) {
@@ -0,0 +1,144 @@
+package protolock
+
+import (
+	"fmt"
+)
+
+// enumUsage is a singular field of a message with an enum type.
+type enumUsage struct {
+	filepath Protopath
+	message  string
+	field    string
+}
+
+// lockFirstEnumFieldMap:
+// table of filepath -> enum name -> first declared enum field
+type lockFirstEnumFieldMap map[Protopath]map[string]EnumField
+
+// NoChangingImplicitEnumDefaults compares the current vs. updated Protolock
+// definitions and will return a list of warnings if the first declared value
+// of any enum in a proto2 file has changed. In proto2, an unset enum field
+// defaults to the first declared value (rather than to 0), so the default of
+// each field using the enum changes as well.
+func NoChangingImplicitEnumDefaults(cur, upd Protolock) ([]Warning, bool) {
+	var warnings []Warning
+	firstEnumFields := getFirstEnumFields(cur)
+	usages := getEnumUsages(upd)
+
+	for _, def := range upd.Definitions {
+		if def.Def.Syntax != SyntaxProto2 {
+			continue
+		}
+		for _, enum := range def.Def.Enums {
+			if len(enum.EnumFields) == 0 {
+				continue
+			}
+			previous, ok := firstEnumFields[def.Filepath][enum.Name]
+			if !ok {
+				continue
+			}
+			first := enum.EnumFields[0]
+			if first.Integer == previous.Integer {
+				continue
+			}
+
+			name := qualify(def.Def.Package.Name, enum.Name)
+			if len(usages[name]) == 0 {
+				msg := fmt.Sprintf(
+					`"%s" first declared value is "%s" (%d), previously "%s" (%d), which changes the default of unset proto2 fields`,
+					enum.Name, first.Name, first.Integer, previous.Name, previous.Integer,
+				)
+				warnings = append(warnings, Warning{
+					Filepath: OSPath(def.Filepath),
+					Message:  msg,
+					Entity:   enum.Name,
+				})
+				continue
+			}
+			for _, usage := range usages[name] {
+				msg := fmt.Sprintf(
+					`"%s" field: "%s" defaults to "%s" (%d) when unset, previously "%s" (%d), as the first value of "%s" has changed`,
+					usage.message, usage.field, first.Name, first.Integer,
+					previous.Name, previous.Integer, enum.Name,
+				)
+				warnings = append(warnings, Warning{
+					Filepath: OSPath(usage.filepath),
+					Message:  msg,
+					Entity:   usage.message,
+				})
+			}
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+func getFirstEnumFields(lock Protolock) lockFirstEnumFieldMap {
+	first := make(lockFirstEnumFieldMap)
+	for _, def := range lock.Definitions {
+		if first[def.Filepath] == nil {
+			first[def.Filepath] = make(map[string]EnumField)
+		}
+		for _, enum := range def.Def.Enums {
+			if len(enum.EnumFields) > 0 {
+				first[def.Filepath][enum.Name] = enum.EnumFields[0]
+			}
+		}
+	}
+
+	return first
+}
+
+// getEnumUsages collects the singular fields of proto2 messages which have an
+// enum type and no explicit default, keyed by the fully-qualified enum name.
+func getEnumUsages(lock Protolock) map[string][]enumUsage {
+	index := getTypeIndex(lock)
+	usages := make(map[string][]enumUsage)
+
+	var walk func(path Protopath, scope, prefix string, msg Message)
+	walk = func(path Protopath, scope, prefix string, msg Message) {
+		scope = qualify(scope, msg.Name)
+		for _, field := range msg.Fields {
+			if field.IsRepeated || hasOption(field.Options, "default") {
+				continue
+			}
+			name, ok := index.resolve(scope, field.Type)
+			if !ok || index[name].Kind != kindEnum {
+				continue
+			}
+			usages[name] = append(usages[name], enumUsage{
+				filepath: path,
+				message:  prefix + msg.Name,
+				field:    field.Name,
+			})
+		}
+		for _, m := range msg.Messages {
+			walk(path, scope, prefix+msg.Name+nestedPrefix, m)
+		}
+	}
+
+	for _, def := range lock.Definitions {
+		if def.Def.Syntax != SyntaxProto2 {
+			continue
+		}
+		for _, msg := range def.Def.Messages {
+			walk(def.Filepath, def.Def.Package.Name, "", msg)
+		}
+	}
+
+	return usages
+}
+
+func hasOption(options []Option, name string) bool {
+	for _, o := range options {
+		if o.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const proto2EnumsProto = `syntax = "proto2";
+package shop;
+
+enum Status {
+  UNKNOWN = 0;
+  PENDING = 1;
+}
+
+message Order {
+  optional Status status = 1;
+  optional Status fallback = 2 [default = PENDING];
+  repeated Status history = 3;
+
+  message Line {
+    optional Status status = 1;
+  }
+}
+
+enum Unused {
+  A = 0;
+  B = 1;
+}
+`
+
+const proto2ReorderedEnumsProto = `syntax = "proto2";
+package shop;
+
+enum Status {
+  PENDING = 1;
+  UNKNOWN = 0;
+}
+
+message Order {
+  optional Status status = 1;
+  optional Status fallback = 2 [default = PENDING];
+  repeated Status history = 3;
+
+  message Line {
+    optional Status status = 1;
+  }
+}
+
+enum Unused {
+  B = 1;
+  A = 0;
+}
+`
+
+func TestParseSyntax(t *testing.T) {
+	def := parseTestProtoAt(t, "shop.proto", proto2EnumsProto)
+	assert.Equal(t, SyntaxProto2, def.Def.Syntax)
+
+	def = parseTestProtoAt(t, "shop.proto", `package shop; message Order {}`)
+	assert.Equal(t, SyntaxProto2, def.Def.Syntax)
+
+	def = parseTestProtoAt(t, "shop.proto", afterRenamesProto)
+	assert.Equal(t, SyntaxProto3, def.Def.Syntax)
+}
+
+func TestNoChangingImplicitEnumDefaults(t *testing.T) {
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "shop.proto", proto2EnumsProto),
+	}}
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "shop.proto", proto2ReorderedEnumsProto),
+	}}
+
+	warnings, ok := NoChangingImplicitEnumDefaults(cur, cur)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+
+	warnings, ok = NoChangingImplicitEnumDefaults(cur, upd)
+	assert.False(t, ok)
+	require.Len(t, warnings, 3)
+	assert.Equal(t, `"Order" field: "status" defaults to "PENDING" (1) when unset, previously "UNKNOWN" (0), as the first value of "Status" has changed`, warnings[0].Message)
+	assert.Equal(t, `"Order.Line" field: "status" defaults to "PENDING" (1) when unset, previously "UNKNOWN" (0), as the first value of "Status" has changed`, warnings[1].Message)
+	assert.Equal(t, `"Unused" first declared value is "B" (1), previously "A" (0), which changes the default of unset proto2 fields`, warnings[2].Message)
+
+	// proto3 enums always default to 0
+	upd.Definitions[0].Def.Syntax = SyntaxProto3
+	warnings, ok = NoChangingImplicitEnumDefaults(cur, upd)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+}
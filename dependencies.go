@@ -0,0 +1,96 @@
+package protolock
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NoForbiddenPackageDependencies verifies that the imports, and the field,
+// map and RPC type references, of the updated Protolock only relate packages
+// as allowed by the DependencySettings. Each violating reference is reported,
+// e.g. a field of a "public.*" package using a type of an "internal.*"
+// package.
+func NoForbiddenPackageDependencies(cur, upd Protolock) ([]Warning, bool) {
+	deps := settings.Dependencies
+	if len(deps.Forbidden) == 0 && len(deps.Allowed) == 0 {
+		return nil, true
+	}
+
+	var warnings []Warning
+	report := func(path Protopath, entity, subject, from, to string) {
+		if from == "" || to == "" || from == to || deps.allows(from, to) {
+			return
+		}
+		warnings = append(warnings, Warning{
+			Filepath: OSPath(path),
+			Message: fmt.Sprintf(`%s, but package "%s" must not depend on package "%s"`,
+				subject, from, to,
+			),
+			Entity: entity,
+		})
+	}
+
+	packages := make(map[Protopath]string)
+	files := make(map[string]string)
+	for _, def := range upd.Definitions {
+		packages[def.Filepath] = def.Def.Package.Name
+		files[slashPath(def.Filepath)] = def.Def.Package.Name
+	}
+
+	defs := append([]Definition{}, upd.Definitions...)
+	sort.SliceStable(defs, func(i, j int) bool { return defs[i].Filepath < defs[j].Filepath })
+	for _, def := range defs {
+		for _, imp := range def.Def.Imports {
+			to, ok := files[imp.Path]
+			if !ok {
+				// e.g. the well-known types, which are not in the lock
+				continue
+			}
+			report(def.Filepath, "",
+				fmt.Sprintf(`import "%s" references package "%s"`, imp.Path, to),
+				def.Def.Package.Name, to,
+			)
+		}
+	}
+
+	index := getTypeIndex(upd)
+	for _, ref := range getTypeReferences(upd, index) {
+		report(ref.filepath, ref.entity,
+			fmt.Sprintf(`%s references "%s"`, ref.subject, ref.target),
+			packages[ref.filepath], index[ref.target].Package,
+		)
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// allows reports whether package from may depend on package to: the
+// dependency must not match any forbidden edge, and if any allowed edges
+// start from the package, it must match one of them.
+func (s DependencySettings) allows(from, to string) bool {
+	for _, e := range s.Forbidden {
+		if e.matches(from, to) {
+			return false
+		}
+	}
+
+	restricted := false
+	for _, e := range s.Allowed {
+		if e.matches(from, to) {
+			return true
+		}
+		if matchPattern(e.From, from) {
+			restricted = true
+		}
+	}
+
+	return !restricted
+}
+
+func (e DependencyEdge) matches(from, to string) bool {
+	return matchPattern(e.From, from) && matchPattern(e.To, to)
+}
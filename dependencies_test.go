@@ -0,0 +1,103 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const publicAPIProto = `syntax = "proto3";
+package public.v1;
+
+import "internal/audit/v1/audit.proto";
+import "common/v1/money.proto";
+
+message Order {
+  common.v1.Money total = 1;
+  internal.audit.v1.Entry audit = 2;
+}
+
+service Orders {
+  rpc Audit(Order) returns (internal.audit.v1.Entry);
+}
+`
+
+const internalAuditProto = `syntax = "proto3";
+package internal.audit.v1;
+
+import "public/v1/api.proto";
+
+message Entry {
+  public.v1.Order order = 1;
+}
+`
+
+const commonMoneyProto = `syntax = "proto3";
+package common.v1;
+
+import "shop/v1/item.proto";
+import "google/protobuf/timestamp.proto";
+
+message Money {
+  int64 units = 1;
+  shop.v1.Item item = 2;
+}
+`
+
+const shopItemProto = `syntax = "proto3";
+package shop.v1;
+
+message Item {}
+`
+
+func TestNoForbiddenPackageDependencies(t *testing.T) {
+	defer SetSettings(Settings{})
+	lock := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, ProtoPath("common/v1/money.proto"), commonMoneyProto),
+		parseTestProtoAt(t, ProtoPath("internal/audit/v1/audit.proto"), internalAuditProto),
+		parseTestProtoAt(t, ProtoPath("public/v1/api.proto"), publicAPIProto),
+		parseTestProtoAt(t, ProtoPath("shop/v1/item.proto"), shopItemProto),
+	}}
+
+	// without edges, nothing is checked
+	warnings, ok := NoForbiddenPackageDependencies(lock, lock)
+	assert.True(t, ok)
+	assert.Nil(t, warnings)
+
+	SetSettings(Settings{Dependencies: DependencySettings{
+		Forbidden: []DependencyEdge{{From: "public.*", To: "internal.*"}},
+		Allowed:   []DependencyEdge{{From: "common.*", To: "google.*"}},
+	}})
+	warnings, ok = NoForbiddenPackageDependencies(lock, lock)
+	assert.False(t, ok)
+	require.Len(t, warnings, 5)
+
+	var messages []string
+	for _, w := range warnings {
+		messages = append(messages, w.Message)
+	}
+	assert.Equal(t, []string{
+		`import "shop/v1/item.proto" references package "shop.v1", but package "common.v1" must not depend on package "shop.v1"`,
+		`import "internal/audit/v1/audit.proto" references package "internal.audit.v1", but package "public.v1" must not depend on package "internal.audit.v1"`,
+		`"Money" field: "item" references "shop.v1.Item", but package "common.v1" must not depend on package "shop.v1"`,
+		`"Order" field: "audit" references "internal.audit.v1.Entry", but package "public.v1" must not depend on package "internal.audit.v1"`,
+		`"Orders" RPC: "Audit" references "internal.audit.v1.Entry", but package "public.v1" must not depend on package "internal.audit.v1"`,
+	}, messages)
+	assert.Equal(t, OSPath(ProtoPath("common/v1/money.proto")), warnings[0].Filepath)
+}
+
+func TestDependencySettingsAllows(t *testing.T) {
+	deps := DependencySettings{
+		Forbidden: []DependencyEdge{{From: "common", To: "*"}},
+		Allowed: []DependencyEdge{
+			{From: "public.*", To: "public.*"},
+			{From: "public.*", To: "common"},
+		},
+	}
+	assert.False(t, deps.allows("common", "billing.v1"))
+	assert.True(t, deps.allows("public.v1", "common"))
+	assert.True(t, deps.allows("public.v1", "public.v2"))
+	assert.False(t, deps.allows("public.v1", "internal"))
+	assert.True(t, deps.allows("billing.v1", "internal"))
+}
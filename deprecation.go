@@ -0,0 +1,166 @@
+package protolock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// typeReference is a field, map or RPC of the updated Protolock referencing a
+// message or enum.
+type typeReference struct {
+	filepath Protopath
+	// subject names the referencing element as the warnings of the rules do,
+	// e.g. `"Invoice" field: "total"` or `"Billing" RPC: "Get"`, and entity
+	// the message or service it belongs to, e.g. "Invoice".
+	subject    string
+	entity     string
+	target     string
+	deprecated bool
+}
+
+// NoUsingDeprecatedTypes verifies that the fields, maps and RPCs of the
+// updated Protolock which reference a deprecated message or enum are
+// deprecated themselves (or belong to a deprecated message), and that no new
+// references to a deprecated type are added, so that the deprecated types can
+// eventually be removed.
+func NoUsingDeprecatedTypes(cur, upd Protolock) ([]Warning, bool) {
+	if !strict {
+		return nil, true
+	}
+
+	var warnings []Warning
+	index := getTypeIndex(upd)
+	existing := make(map[string]bool)
+	for _, ref := range getTypeReferences(cur, getTypeIndex(cur)) {
+		existing[ref.subject+" "+ref.target] = true
+	}
+
+	for _, ref := range getTypeReferences(upd, index) {
+		typ := index[ref.target]
+		if !isDeprecatedType(typ) {
+			continue
+		}
+		switch {
+		case !ref.deprecated:
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(ref.filepath),
+				Message: fmt.Sprintf(`%s uses deprecated %s "%s", but is not deprecated`,
+					ref.subject, typ.Kind, ref.target,
+				),
+				Entity: ref.entity,
+			})
+		case !existing[ref.subject+" "+ref.target]:
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(ref.filepath),
+				Message: fmt.Sprintf(`%s is a new use of deprecated %s "%s"`,
+					ref.subject, typ.Kind, ref.target,
+				),
+				Entity: ref.entity,
+			})
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// getTypeReferences collects the references of the fields (including maps)
+// of every message, and of the RPCs of every service, to the messages and
+// enums of the index, in a stable order.
+func getTypeReferences(lock Protolock, index typeIndex) []typeReference {
+	var refs []typeReference
+
+	var names []string
+	for name, typ := range index {
+		if typ.Kind == kindMessage {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		msg := index[name]
+		relName := strings.TrimPrefix(name, msg.Package+nestedPrefix)
+		if msg.Package == "" {
+			relName = name
+		}
+		// the fields of a deprecated message (or of a message nested within
+		// one) go away along with it
+		deprecated := false
+		for scope, ok := name, true; ok && !deprecated; {
+			deprecated = isDeprecatedType(index[scope])
+			i := strings.LastIndex(scope, nestedPrefix)
+			if i < 0 {
+				break
+			}
+			scope = scope[:i]
+			_, ok = index[scope]
+		}
+
+		fields := append([]Field{}, msg.Message.Fields...)
+		for _, mp := range msg.Message.Maps {
+			fields = append(fields, mp.Field)
+		}
+		for _, f := range fields {
+			target, ok := index.resolve(name, f.Type)
+			if !ok {
+				continue
+			}
+			refs = append(refs, typeReference{
+				filepath:   msg.Filepath,
+				subject:    fieldSubject(relName, f.Name),
+				entity:     relName,
+				target:     target,
+				deprecated: deprecated || isDeprecated(f.Options),
+			})
+		}
+	}
+
+	for _, def := range lock.Definitions {
+		pkg := def.Def.Package.Name
+		for _, svc := range def.Def.Services {
+			for _, rpc := range svc.RPCs {
+				for i, typ := range []string{rpc.InType, rpc.OutType} {
+					target, ok := index.resolve(pkg, typ)
+					if !ok || (i == 1 && rpc.OutType == rpc.InType) {
+						continue
+					}
+					refs = append(refs, typeReference{
+						filepath:   def.Filepath,
+						subject:    fmt.Sprintf(`"%s" RPC: "%s"`, svc.Name, rpc.Name),
+						entity:     svc.Name,
+						target:     target,
+						deprecated: isDeprecated(rpc.Options),
+					})
+				}
+			}
+		}
+	}
+
+	return refs
+}
+
+// isDeprecatedType reports whether a message or enum has the deprecated
+// option set.
+func isDeprecatedType(typ lockType) bool {
+	if typ.Kind == kindEnum {
+		return isDeprecated(typ.Enum.Options)
+	}
+
+	return isDeprecated(typ.Message.Options)
+}
+
+// isDeprecated reports whether the options contain "deprecated = true".
+func isDeprecated(opts []Option) bool {
+	for _, o := range opts {
+		if o.Name == "deprecated" && o.Value == "true" {
+			return true
+		}
+	}
+
+	return false
+}
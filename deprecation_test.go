@@ -0,0 +1,95 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const deprecationProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1;
+  LegacyLine line = 2 [deprecated = true];
+  map<string, LegacyLine> lines = 3;
+}
+
+message LegacyLine {
+  option deprecated = true;
+  LegacyLine parent = 1;
+  Status status = 2;
+}
+
+enum Status {
+  option deprecated = true;
+  STATUS_UNSPECIFIED = 0;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (Invoice);
+  rpc GetLine(LegacyLine) returns (LegacyLine) {
+    option deprecated = true;
+  }
+}
+`
+
+const deprecationUpdatedProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1;
+  LegacyLine line = 2 [deprecated = true];
+  map<string, LegacyLine> lines = 3;
+  Status status = 4 [deprecated = true];
+}
+
+message LegacyLine {
+  option deprecated = true;
+  LegacyLine parent = 1;
+  Status status = 2;
+}
+
+enum Status {
+  option deprecated = true;
+  STATUS_UNSPECIFIED = 0;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (Invoice);
+  rpc GetLine(LegacyLine) returns (LegacyLine) {
+    option deprecated = true;
+  }
+  rpc ListLines(Invoice) returns (LegacyLine);
+}
+`
+
+func TestNoUsingDeprecatedTypes(t *testing.T) {
+	SetStrict(true)
+	cur := parseTestLock(t, deprecationProto)
+	upd := parseTestLock(t, deprecationUpdatedProto)
+
+	// the existing references within a deprecated message are allowed
+	warnings, ok := NoUsingDeprecatedTypes(cur, cur)
+	assert.False(t, ok)
+	assert.Len(t, warnings, 1)
+
+	warnings, ok = NoUsingDeprecatedTypes(cur, upd)
+	assert.False(t, ok)
+	var messages []string
+	for _, w := range warnings {
+		assert.Equal(t, OSPath(ProtoPath("billing/v1/invoice.proto")), w.Filepath)
+		messages = append(messages, w.Message)
+	}
+	assert.Equal(t, []string{
+		`"Invoice" field: "status" is a new use of deprecated enum "billing.v1.Status"`,
+		`"Invoice" field: "lines" uses deprecated message "billing.v1.LegacyLine", but is not deprecated`,
+		`"Billing" RPC: "ListLines" uses deprecated message "billing.v1.LegacyLine", but is not deprecated`,
+	}, messages)
+
+	SetStrict(false)
+	defer SetStrict(true)
+	warnings, ok = NoUsingDeprecatedTypes(cur, upd)
+	assert.True(t, ok)
+	assert.Nil(t, warnings)
+}
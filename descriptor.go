@@ -0,0 +1,360 @@
+package protolock
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+// the wire types of the protobuf encoding which occur in descriptors
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// labelRepeated is the label of a repeated field in a FieldDescriptorProto.
+const labelRepeated = 3
+
+// scalarTypeNames:
+// table of FieldDescriptorProto type -> name of the scalar type in .proto source
+var scalarTypeNames = map[int]string{
+	1:  "double",
+	2:  "float",
+	3:  "int64",
+	4:  "uint64",
+	5:  "int32",
+	6:  "fixed64",
+	7:  "fixed32",
+	8:  "bool",
+	9:  "string",
+	12: "bytes",
+	13: "uint32",
+	15: "sfixed32",
+	16: "sfixed64",
+	17: "sint32",
+	18: "sint64",
+}
+
+// errMalformedDescriptor indicates that a serialized descriptor could not be
+// decoded.
+var errMalformedDescriptor = errors.New("malformed file descriptor")
+
+// The descriptor types below hold the parts of the descriptor.proto messages
+// which are recorded in a proto.lock file. They are decoded by hand from the
+// wire format, so that protolock does not depend on a protobuf runtime.
+
+type fileDescriptor struct {
+	name         string
+	pkg          string
+	syntax       string
+	dependencies []string
+	messages     []messageDescriptor
+	enums        []enumDescriptor
+	services     []serviceDescriptor
+	extensions   []fieldDescriptor
+}
+
+type messageDescriptor struct {
+	name       string
+	fields     []fieldDescriptor
+	nested     []messageDescriptor
+	enums      []enumDescriptor
+	extensions []fieldDescriptor
+	mapEntry   bool
+}
+
+type fieldDescriptor struct {
+	name     string
+	number   int
+	label    int
+	typ      int
+	typeName string
+	extendee string
+}
+
+type enumDescriptor struct {
+	name   string
+	values []enumValueDescriptor
+}
+
+type enumValueDescriptor struct {
+	name   string
+	number int
+}
+
+type serviceDescriptor struct {
+	name    string
+	methods []methodDescriptor
+}
+
+type methodDescriptor struct {
+	name            string
+	inputType       string
+	outputType      string
+	clientStreaming bool
+	serverStreaming bool
+}
+
+// wireField is a single decoded field of a protobuf message. Only one of
+// varint or bytes is set, depending on the wire type.
+type wireField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeWire calls fn with each field of the serialized message b, in order.
+func decodeWire(b []byte, fn func(f wireField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformedDescriptor
+		}
+		b = b[n:]
+
+		f := wireField{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errMalformedDescriptor
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errMalformedDescriptor
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errMalformedDescriptor
+			}
+			b = b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errMalformedDescriptor
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return errMalformedDescriptor
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeFileDescriptor decodes a serialized FileDescriptorProto, which may be
+// gzipped (as embedded by older versions of protoc-gen-go).
+func decodeFileDescriptor(b []byte) (fileDescriptor, error) {
+	var fd fileDescriptor
+	if len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return fd, err
+		}
+		b, err = ioutil.ReadAll(r)
+		if err != nil {
+			return fd, err
+		}
+	}
+
+	err := decodeWire(b, func(f wireField) error {
+		switch f.num {
+		case 1:
+			fd.name = string(f.bytes)
+		case 2:
+			fd.pkg = string(f.bytes)
+		case 3:
+			fd.dependencies = append(fd.dependencies, string(f.bytes))
+		case 4:
+			msg, err := decodeMessageDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			fd.messages = append(fd.messages, msg)
+		case 5:
+			enum, err := decodeEnumDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			fd.enums = append(fd.enums, enum)
+		case 6:
+			svc, err := decodeServiceDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			fd.services = append(fd.services, svc)
+		case 7:
+			ext, err := decodeFieldDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			fd.extensions = append(fd.extensions, ext)
+		case 12:
+			fd.syntax = string(f.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return fd, err
+	}
+	if fd.name == "" {
+		return fd, errMalformedDescriptor
+	}
+
+	return fd, nil
+}
+
+func decodeMessageDescriptor(b []byte) (messageDescriptor, error) {
+	var msg messageDescriptor
+	err := decodeWire(b, func(f wireField) error {
+		switch f.num {
+		case 1:
+			msg.name = string(f.bytes)
+		case 2, 6:
+			field, err := decodeFieldDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			if f.num == 2 {
+				msg.fields = append(msg.fields, field)
+			} else {
+				msg.extensions = append(msg.extensions, field)
+			}
+		case 3:
+			nested, err := decodeMessageDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			msg.nested = append(msg.nested, nested)
+		case 4:
+			enum, err := decodeEnumDescriptor(f.bytes)
+			if err != nil {
+				return err
+			}
+			msg.enums = append(msg.enums, enum)
+		case 7:
+			// MessageOptions.map_entry
+			return decodeWire(f.bytes, func(o wireField) error {
+				if o.num == 7 {
+					msg.mapEntry = o.varint != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	return msg, err
+}
+
+func decodeFieldDescriptor(b []byte) (fieldDescriptor, error) {
+	var field fieldDescriptor
+	err := decodeWire(b, func(f wireField) error {
+		switch f.num {
+		case 1:
+			field.name = string(f.bytes)
+		case 2:
+			field.extendee = string(f.bytes)
+		case 3:
+			field.number = int(int32(f.varint))
+		case 4:
+			field.label = int(f.varint)
+		case 5:
+			field.typ = int(f.varint)
+		case 6:
+			field.typeName = string(f.bytes)
+		}
+		return nil
+	})
+
+	return field, err
+}
+
+func decodeEnumDescriptor(b []byte) (enumDescriptor, error) {
+	var enum enumDescriptor
+	err := decodeWire(b, func(f wireField) error {
+		switch f.num {
+		case 1:
+			enum.name = string(f.bytes)
+		case 2:
+			var value enumValueDescriptor
+			err := decodeWire(f.bytes, func(v wireField) error {
+				switch v.num {
+				case 1:
+					value.name = string(v.bytes)
+				case 2:
+					value.number = int(int32(v.varint))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			enum.values = append(enum.values, value)
+		}
+		return nil
+	})
+
+	return enum, err
+}
+
+func decodeServiceDescriptor(b []byte) (serviceDescriptor, error) {
+	var svc serviceDescriptor
+	err := decodeWire(b, func(f wireField) error {
+		switch f.num {
+		case 1:
+			svc.name = string(f.bytes)
+		case 2:
+			var method methodDescriptor
+			err := decodeWire(f.bytes, func(m wireField) error {
+				switch m.num {
+				case 1:
+					method.name = string(m.bytes)
+				case 2:
+					method.inputType = string(m.bytes)
+				case 3:
+					method.outputType = string(m.bytes)
+				case 5:
+					method.clientStreaming = m.varint != 0
+				case 6:
+					method.serverStreaming = m.varint != 0
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			svc.methods = append(svc.methods, method)
+		}
+		return nil
+	})
+
+	return svc, err
+}
+
+// typeString returns the type of a field as written in .proto source, with
+// message and enum types fully-qualified (without leading ".").
+func (f fieldDescriptor) typeString() string {
+	if name, ok := scalarTypeNames[f.typ]; ok {
+		return name
+	}
+	return trimDot(f.typeName)
+}
+
+func trimDot(name string) string {
+	if len(name) > 0 && name[0] == '.' {
+		return name[1:]
+	}
+	return name
+}
@@ -0,0 +1,541 @@
+package protolock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// DiagramMermaid renders a Mermaid class diagram.
+	DiagramMermaid = "mermaid"
+
+	// DiagramPlantUML renders a PlantUML class diagram.
+	DiagramPlantUML = "plantuml"
+)
+
+// ErrUnknownDiagramFormat indicates that a diagram format other than
+// DiagramMermaid or DiagramPlantUML was requested.
+var ErrUnknownDiagramFormat = errors.New("unknown diagram format, use mermaid or plantuml")
+
+// DiagramOptions controls which definitions are rendered into a diagram, and
+// in which format.
+type DiagramOptions struct {
+	// Format is one of DiagramMermaid or DiagramPlantUML.
+	Format string
+	// Package limits the diagram to types and services within a package
+	// (and its sub-packages).
+	Package string
+	// Root limits the diagram to the types reachable from a single message,
+	// enum or service.
+	Root string
+	// Depth limits how many references are followed from Root, 0 meaning
+	// no limit.
+	Depth int
+	// Against is the path to a previous proto.lock file. When set, the
+	// diagram highlights elements added, removed or changed since then.
+	Against string
+}
+
+// changeKind describes how an element differs between two Protolocks.
+type changeKind string
+
+const (
+	unchanged changeKind = ""
+	added     changeKind = "added"
+	removed   changeKind = "removed"
+	changed   changeKind = "changed"
+)
+
+type diagramNode struct {
+	name    string
+	pkg     string
+	kind    string
+	members []diagramMember
+	edges   []diagramEdge
+	change  changeKind
+}
+
+type diagramMember struct {
+	key    string
+	text   string
+	change changeKind
+}
+
+type diagramEdge struct {
+	to     string
+	label  string
+	nested bool
+	change changeKind
+}
+
+type diagramNodes map[string]*diagramNode
+
+// Diagram renders the definitions of the proto.lock file found using cfg. If
+// opts.Against is set, the lock file at that path is used as the previous
+// state and the diagram highlights the differences.
+func Diagram(cfg Config, opts DiagramOptions) (io.Reader, error) {
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		if os.IsNotExist(err) {
+			msg := `no "proto.lock" file found, first run "init"`
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Against == "" {
+		return RenderDiagram(lock, opts)
+	}
+
+	f, err := os.Open(opts.Against)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	previous, err := FromReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return RenderDiagramDiff(previous, lock, opts)
+}
+
+// RenderDiagram renders the messages, enums and services of a Protolock as a
+// class diagram.
+func RenderDiagram(lock Protolock, opts DiagramOptions) (io.Reader, error) {
+	return renderDiagram(getDiagramNodes(lock), opts)
+}
+
+// RenderDiagramDiff renders the union of two Protolocks as a class diagram,
+// highlighting the elements added, removed or changed from current to updated.
+func RenderDiagramDiff(current, updated Protolock, opts DiagramOptions) (io.Reader, error) {
+	return renderDiagram(
+		diffDiagramNodes(getDiagramNodes(current), getDiagramNodes(updated)),
+		opts,
+	)
+}
+
+func renderDiagram(nodes diagramNodes, opts DiagramOptions) (io.Reader, error) {
+	nodes = filterDiagramPackage(nodes, opts.Package)
+	if opts.Root != "" {
+		var err error
+		nodes, err = filterDiagramRoot(nodes, opts.Root, opts.Depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch opts.Format {
+	case DiagramMermaid, "":
+		return strings.NewReader(renderMermaid(nodes)), nil
+	case DiagramPlantUML:
+		return strings.NewReader(renderPlantUML(nodes)), nil
+	default:
+		return nil, ErrUnknownDiagramFormat
+	}
+}
+
+// getDiagramNodes collects a node for every message, enum and service in the
+// lock, each with an edge for every field, map or RPC type that resolves to
+// another message or enum in the lock.
+func getDiagramNodes(lock Protolock) diagramNodes {
+	index := getTypeIndex(lock)
+	nodes := make(diagramNodes)
+
+	for _, def := range lock.Definitions {
+		pkg := def.Def.Package.Name
+		for _, msg := range def.Def.Messages {
+			addMessageNode(nodes, index, pkg, qualify(pkg, msg.Name), msg)
+		}
+
+		for _, enum := range def.Def.Enums {
+			name := qualify(pkg, enum.Name)
+			node := &diagramNode{name: name, pkg: pkg, kind: "enum"}
+			for _, field := range enum.EnumFields {
+				node.members = append(node.members, diagramMember{
+					key:  field.Name,
+					text: fmt.Sprintf("%s = %d", field.Name, field.Integer),
+				})
+			}
+			nodes[name] = node
+
+			// nested enums are attached to their parent message
+			if i := strings.LastIndex(enum.Name, nestedPrefix); i > 0 {
+				parent := qualify(pkg, enum.Name[:i])
+				if p, ok := nodes[parent]; ok {
+					p.edges = append(p.edges, diagramEdge{to: name, nested: true})
+				}
+			}
+		}
+
+		for _, svc := range def.Def.Services {
+			name := qualify(pkg, svc.Name)
+			node := &diagramNode{name: name, pkg: pkg, kind: "service"}
+			for _, rpc := range svc.RPCs {
+				node.members = append(node.members, diagramMember{
+					key: rpc.Name,
+					text: fmt.Sprintf(
+						"%s(%s) %s",
+						rpc.Name,
+						streamed(rpc.InStreamed, rpc.InType),
+						streamed(rpc.OutStreamed, rpc.OutType),
+					),
+				})
+				for _, typ := range []string{rpc.InType, rpc.OutType} {
+					if to, ok := index.resolve(pkg, typ); ok {
+						node.edges = append(node.edges, diagramEdge{
+							to: to, label: rpc.Name,
+						})
+					}
+				}
+			}
+			nodes[name] = node
+		}
+	}
+
+	return nodes
+}
+
+func addMessageNode(nodes diagramNodes, index typeIndex, pkg, name string, msg Message) {
+	node := &diagramNode{name: name, pkg: pkg, kind: "message"}
+	for _, field := range msg.Fields {
+		typ := field.Type
+		if field.IsRepeated {
+			typ = "repeated " + typ
+		}
+		node.members = append(node.members, diagramMember{
+			key:  field.Name,
+			text: fmt.Sprintf("%s %s = %d", typ, field.Name, field.ID),
+		})
+		if to, ok := index.resolve(name, field.Type); ok {
+			node.edges = append(node.edges, diagramEdge{to: to, label: field.Name})
+		}
+	}
+	for _, mp := range msg.Maps {
+		node.members = append(node.members, diagramMember{
+			key: mp.Field.Name,
+			text: fmt.Sprintf(
+				"map<%s, %s> %s = %d",
+				mp.KeyType, mp.Field.Type, mp.Field.Name, mp.Field.ID,
+			),
+		})
+		if to, ok := index.resolve(name, mp.Field.Type); ok {
+			node.edges = append(node.edges, diagramEdge{to: to, label: mp.Field.Name})
+		}
+	}
+	for _, m := range msg.Messages {
+		nested := name + nestedPrefix + m.Name
+		node.edges = append(node.edges, diagramEdge{to: nested, nested: true})
+		addMessageNode(nodes, index, pkg, nested, m)
+	}
+
+	nodes[name] = node
+}
+
+func streamed(stream bool, typ string) string {
+	if stream {
+		return "stream " + typ
+	}
+	return typ
+}
+
+// diffDiagramNodes merges the nodes of two diagrams, marking each node, member
+// and edge with the change it underwent from cur to upd.
+func diffDiagramNodes(cur, upd diagramNodes) diagramNodes {
+	nodes := make(diagramNodes)
+	for name, node := range upd {
+		prev, ok := cur[name]
+		if !ok {
+			node.change = added
+			nodes[name] = node
+			continue
+		}
+
+		node.members = diffDiagramMembers(prev.members, node.members)
+		node.edges = diffDiagramEdges(prev.edges, node.edges)
+		for _, m := range node.members {
+			if m.change != unchanged {
+				node.change = changed
+			}
+		}
+		for _, e := range node.edges {
+			if e.change != unchanged {
+				node.change = changed
+			}
+		}
+		nodes[name] = node
+	}
+
+	for name, node := range cur {
+		if _, ok := upd[name]; ok {
+			continue
+		}
+		node.change = removed
+		nodes[name] = node
+	}
+
+	return nodes
+}
+
+func diffDiagramMembers(cur, upd []diagramMember) []diagramMember {
+	prev := make(map[string]diagramMember)
+	for _, m := range cur {
+		prev[m.key] = m
+	}
+
+	var members []diagramMember
+	seen := make(map[string]bool)
+	for _, m := range upd {
+		seen[m.key] = true
+		p, ok := prev[m.key]
+		switch {
+		case !ok:
+			m.change = added
+		case p.text != m.text:
+			m.change = changed
+		}
+		members = append(members, m)
+	}
+	for _, m := range cur {
+		if !seen[m.key] {
+			m.change = removed
+			members = append(members, m)
+		}
+	}
+
+	return members
+}
+
+func diffDiagramEdges(cur, upd []diagramEdge) []diagramEdge {
+	key := func(e diagramEdge) string {
+		return fmt.Sprintf("%s|%s|%t", e.to, e.label, e.nested)
+	}
+	prev := make(map[string]bool)
+	for _, e := range cur {
+		prev[key(e)] = true
+	}
+
+	var edges []diagramEdge
+	seen := make(map[string]bool)
+	for _, e := range upd {
+		seen[key(e)] = true
+		if !prev[key(e)] {
+			e.change = added
+		}
+		edges = append(edges, e)
+	}
+	for _, e := range cur {
+		if !seen[key(e)] {
+			e.change = removed
+			edges = append(edges, e)
+		}
+	}
+
+	return edges
+}
+
+func filterDiagramPackage(nodes diagramNodes, pkg string) diagramNodes {
+	if pkg == "" {
+		return nodes
+	}
+
+	filtered := make(diagramNodes)
+	for name, node := range nodes {
+		if node.pkg == pkg || strings.HasPrefix(node.pkg, pkg+nestedPrefix) {
+			filtered[name] = node
+		}
+	}
+
+	return filtered
+}
+
+// filterDiagramRoot keeps only the nodes reachable from root, following at
+// most depth edges (or all edges if depth is 0). The root may be given by
+// its fully-qualified name, or by its name without the package if only one
+// package defines it.
+func filterDiagramRoot(nodes diagramNodes, root string, depth int) (diagramNodes, error) {
+	start := root
+	if _, ok := nodes[root]; !ok {
+		var candidates []string
+		for name, node := range nodes {
+			if name == qualify(node.pkg, root) {
+				candidates = append(candidates, name)
+			}
+		}
+		sort.Strings(candidates)
+		switch len(candidates) {
+		case 0:
+			return nil, fmt.Errorf("root type %q not found", root)
+		case 1:
+			start = candidates[0]
+		default:
+			return nil, fmt.Errorf("root type %q is ambiguous, use one of: %s",
+				root, strings.Join(candidates, ", "),
+			)
+		}
+	}
+
+	filtered := diagramNodes{start: nodes[start]}
+	queue := []string{start}
+	for level := 0; len(queue) > 0 && (depth == 0 || level < depth); level++ {
+		var next []string
+		for _, name := range queue {
+			for _, edge := range nodes[name].edges {
+				if _, ok := filtered[edge.to]; ok {
+					continue
+				}
+				if node, ok := nodes[edge.to]; ok {
+					filtered[edge.to] = node
+					next = append(next, edge.to)
+				}
+			}
+		}
+		queue = next
+	}
+
+	return filtered, nil
+}
+
+func (nodes diagramNodes) sorted() []*diagramNode {
+	var list []*diagramNode
+	for _, node := range nodes {
+		list = append(list, node)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+
+	return list
+}
+
+// diagramID converts a fully-qualified name into an identifier which is safe
+// to use in both Mermaid and PlantUML.
+func diagramID(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+func diagramColor(change changeKind) string {
+	switch change {
+	case added:
+		return "#d4f7d4"
+	case removed:
+		return "#f7d4d4"
+	case changed:
+		return "#f7ecd4"
+	}
+	return ""
+}
+
+func changeSuffix(change changeKind) string {
+	if change == unchanged {
+		return ""
+	}
+	return " [" + string(change) + "]"
+}
+
+func renderMermaid(nodes diagramNodes) string {
+	var b strings.Builder
+	b.WriteString("classDiagram\n")
+
+	list := nodes.sorted()
+	for _, node := range list {
+		fmt.Fprintf(&b, "  class %s[\"%s\"] {\n", diagramID(node.name), node.name)
+		fmt.Fprintf(&b, "    <<%s>>\n", node.kind)
+		for _, m := range node.members {
+			// Mermaid uses "~" to denote generic types
+			text := strings.NewReplacer("<", "~", ">", "~").Replace(m.text)
+			if node.kind == "service" {
+				text = strings.Replace(text, ") ", ") : ", 1)
+			}
+			fmt.Fprintf(&b, "    +%s%s\n", text, changeSuffix(m.change))
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, node := range list {
+		for _, e := range node.edges {
+			if _, ok := nodes[e.to]; !ok {
+				continue
+			}
+			arrow := "-->"
+			if e.nested {
+				arrow = "*--"
+			}
+			fmt.Fprintf(&b, "  %s %s %s", diagramID(node.name), arrow, diagramID(e.to))
+			if label := e.label + changeSuffix(e.change); label != "" {
+				fmt.Fprintf(&b, " : %s", strings.TrimSpace(label))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	for _, node := range list {
+		if color := diagramColor(node.change); color != "" {
+			fmt.Fprintf(&b, "  style %s fill:%s\n", diagramID(node.name), color)
+		}
+	}
+
+	return b.String()
+}
+
+func renderPlantUML(nodes diagramNodes) string {
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+
+	list := nodes.sorted()
+	for _, node := range list {
+		keyword, stereotype := "class", " <<"+node.kind+">>"
+		if node.kind == "enum" {
+			keyword, stereotype = "enum", ""
+		}
+		fmt.Fprintf(
+			&b, "%s \"%s\" as %s%s",
+			keyword, node.name, diagramID(node.name), stereotype,
+		)
+		if color := diagramColor(node.change); color != "" {
+			fmt.Fprintf(&b, " %s", color)
+		}
+		b.WriteString(" {\n")
+		for _, m := range node.members {
+			text := m.text
+			if node.kind == "service" {
+				text = "+" + strings.Replace(text, ") ", ") : ", 1)
+			} else if node.kind == "message" {
+				text = "+" + text
+			}
+			fmt.Fprintf(&b, "  %s%s\n", text, changeSuffix(m.change))
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, node := range list {
+		for _, e := range node.edges {
+			if _, ok := nodes[e.to]; !ok {
+				continue
+			}
+			arrow := "-->"
+			if e.nested {
+				arrow = "*--"
+			}
+			fmt.Fprintf(&b, "%s %s %s", diagramID(node.name), arrow, diagramID(e.to))
+			if label := e.label + changeSuffix(e.change); label != "" {
+				fmt.Fprintf(&b, " : %s", strings.TrimSpace(label))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("@enduml\n")
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const diagramProto = `syntax = "proto3";
+package test;
+
+message Channel {
+  int64 id = 1;
+  repeated Item items = 2;
+  map<string, Item> by_name = 3;
+
+  message Item {
+    string name = 1;
+    Kind kind = 2;
+  }
+
+  enum Kind {
+    UNKNOWN = 0;
+    AUDIO = 1;
+  }
+}
+
+message NextRequest {}
+
+service ChannelChanger {
+  rpc Next(stream NextRequest) returns (Channel);
+}
+`
+
+const diagramUpdatedProto = `syntax = "proto3";
+package test;
+
+message Channel {
+  int64 id = 1;
+  repeated Item items = 2;
+  string description = 4;
+
+  message Item {
+    string name = 1;
+    Kind kind = 2;
+  }
+
+  enum Kind {
+    UNKNOWN = 0;
+    AUDIO = 1;
+  }
+}
+
+service ChannelChanger {
+  rpc Next(stream Channel) returns (Channel);
+}
+`
+
+func renderTestDiagram(t *testing.T, lock Protolock, opts DiagramOptions) string {
+	r, err := RenderDiagram(lock, opts)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestDiagramMermaid(t *testing.T) {
+	lock := parseTestProto(t, diagramProto)
+	out := renderTestDiagram(t, lock, DiagramOptions{Format: DiagramMermaid})
+
+	assert.Contains(t, out, "classDiagram\n")
+	assert.Contains(t, out, `class test_Channel["test.Channel"] {`)
+	assert.Contains(t, out, "+repeated Item items = 2\n")
+	assert.Contains(t, out, "+map~string, Item~ by_name = 3\n")
+	assert.Contains(t, out, "test_Channel --> test_Channel_Item : items\n")
+	assert.Contains(t, out, "test_Channel *-- test_Channel_Item\n")
+	assert.Contains(t, out, "test_Channel *-- test_Channel_Kind\n")
+	assert.Contains(t, out, "test_Channel_Item --> test_Channel_Kind : kind\n")
+	assert.Contains(t, out, "+Next(stream NextRequest) : Channel\n")
+	assert.Contains(t, out, "test_ChannelChanger --> test_NextRequest : Next\n")
+}
+
+func TestDiagramPlantUML(t *testing.T) {
+	lock := parseTestProto(t, diagramProto)
+	out := renderTestDiagram(t, lock, DiagramOptions{Format: DiagramPlantUML})
+
+	assert.Contains(t, out, "@startuml\n")
+	assert.Contains(t, out, `class "test.Channel" as test_Channel <<message>> {`)
+	assert.Contains(t, out, `enum "test.Channel.Kind" as test_Channel_Kind {`)
+	assert.Contains(t, out, "+map<string, Item> by_name = 3\n")
+	assert.Contains(t, out, "@enduml\n")
+
+	_, err := RenderDiagram(lock, DiagramOptions{Format: "svg"})
+	assert.Equal(t, ErrUnknownDiagramFormat, err)
+}
+
+func TestDiagramFilters(t *testing.T) {
+	lock := parseTestProto(t, diagramProto)
+
+	out := renderTestDiagram(t, lock, DiagramOptions{Root: "Channel.Item"})
+	assert.Contains(t, out, "test_Channel_Item")
+	assert.Contains(t, out, "test_Channel_Kind")
+	assert.NotContains(t, out, `class test_Channel["test.Channel"]`)
+
+	out = renderTestDiagram(t, lock, DiagramOptions{Root: "ChannelChanger", Depth: 1})
+	assert.Contains(t, out, `class test_Channel["test.Channel"]`)
+	assert.Contains(t, out, `class test_NextRequest["test.NextRequest"]`)
+	assert.NotContains(t, out, `class test_Channel_Item["test.Channel.Item"]`)
+
+	out = renderTestDiagram(t, lock, DiagramOptions{Package: "other"})
+	assert.Equal(t, "classDiagram\n", out)
+
+	_, err := RenderDiagram(lock, DiagramOptions{Root: "Missing"})
+	assert.Error(t, err)
+
+	other := parseTestProtoAt(t, "other.proto", strings.Replace(diagramProto, "package test;", "package other;", 1))
+	lock.Definitions = append(lock.Definitions, other)
+	_, err = RenderDiagram(lock, DiagramOptions{Root: "Channel"})
+	assert.EqualError(t, err, `root type "Channel" is ambiguous, use one of: other.Channel, test.Channel`)
+	out = renderTestDiagram(t, lock, DiagramOptions{Root: "other.Channel"})
+	assert.Contains(t, out, `class other_Channel["other.Channel"]`)
+	assert.NotContains(t, out, `class test_Channel["test.Channel"]`)
+}
+
+func TestDiagramDiff(t *testing.T) {
+	cur := parseTestProto(t, diagramProto)
+	upd := parseTestProto(t, diagramUpdatedProto)
+
+	r, err := RenderDiagramDiff(cur, upd, DiagramOptions{})
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	out := string(b)
+
+	assert.Contains(t, out, "+string description = 4 [added]\n")
+	assert.Contains(t, out, "+map~string, Item~ by_name = 3 [removed]\n")
+	assert.Contains(t, out, "+Next(stream Channel) : Channel [changed]\n")
+	assert.Contains(t, out, "style test_NextRequest fill:#f7d4d4\n")
+	assert.Contains(t, out, "style test_Channel fill:#f7ecd4\n")
+	assert.Contains(t, out, "test_ChannelChanger --> test_NextRequest : Next [removed]\n")
+	assert.NotContains(t, out, "style test_Channel_Item ")
+}
@@ -0,0 +1,479 @@
+package protolock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// protoFenceLanguages are the info strings of the Markdown code fences whose
+// snippets CheckDocs compares against the proto.lock file.
+var protoFenceLanguages = map[string]bool{
+	"proto":    true,
+	"protobuf": true,
+	"proto2":   true,
+	"proto3":   true,
+}
+
+// markdownFence matches the opening or closing line of a fenced code block.
+var markdownFence = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+
+// ellipsisLine matches a line eliding part of a snippet, e.g. "  ...".
+var ellipsisLine = regexp.MustCompile(`^\s*(\.\.\.|…)\s*$`)
+
+// docSnippet is the content of a proto code fence of a Markdown file.
+type docSnippet struct {
+	path string
+	// line is the line of the Markdown file on which the snippet starts.
+	line int
+	src  string
+}
+
+// CheckDocs compares the proto code fences of the Markdown files found in dir
+// against the proto.lock file, and returns a Report with a warning for each
+// field, enum value or RPC of a snippet which is outdated: its number, type or
+// name differs from the locked definition of the same name, or it is not
+// locked at all. A snippet is compared with the locked messages, enums and
+// services of the same names, qualified by its package if it declares one.
+// Partial snippets are tolerated: elided lines ("...") are skipped, unclosed
+// blocks are closed, and what still cannot be parsed, or is not locked, is
+// left unchecked.
+func CheckDocs(cfg Config, dir string) (*Report, error) {
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Current: lock}
+	checker := newDocChecker(lock)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".md" && ext != ".markdown" {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, snippet := range extractProtoSnippets(path, b) {
+			report.Warnings = append(report.Warnings, checker.check(snippet)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Warnings) != 0 {
+		return report, ErrWarningsFound
+	}
+
+	return report, nil
+}
+
+// extractProtoSnippets returns the content of the proto code fences of a
+// Markdown file. A fence left open runs to the end of the file.
+func extractProtoSnippets(path string, src []byte) []docSnippet {
+	var snippets []docSnippet
+	var fence string
+	var snippet *docSnippet
+	var lines []string
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		m := markdownFence.FindStringSubmatch(line)
+		if fence == "" {
+			if m == nil {
+				continue
+			}
+			fence = m[1]
+			if protoFenceLanguages[strings.ToLower(m[2])] {
+				snippet = &docSnippet{path: path, line: i + 2}
+				lines = nil
+			}
+			continue
+		}
+
+		// a closing fence has no info string, and is at least as long as
+		// the opening one
+		if m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence) &&
+			strings.TrimSpace(strings.TrimLeft(line, " "+m[1][:1])) == "" {
+			if snippet != nil {
+				snippet.src = strings.Join(lines, "\n")
+				snippets = append(snippets, *snippet)
+			}
+			fence, snippet = "", nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if snippet != nil {
+		snippet.src = strings.Join(lines, "\n")
+		snippets = append(snippets, *snippet)
+	}
+
+	return snippets
+}
+
+// parseSnippet parses a snippet with Parse, after blanking its elided lines
+// and closing its unclosed blocks, keeping the lines of everything else.
+func parseSnippet(s docSnippet) (Entry, []editionToken, bool) {
+	lines := strings.Split(s.src, "\n")
+	for i, line := range lines {
+		if ellipsisLine.MatchString(line) {
+			lines[i] = ""
+		}
+	}
+	src := strings.Join(lines, "\n")
+
+	tokens := scanEditionTokens([]byte(src))
+	open := 0
+	for _, t := range tokens {
+		switch t.text {
+		case "{":
+			open++
+		case "}":
+			open--
+		}
+	}
+	if open > 0 {
+		src += "\n" + strings.Repeat("}", open)
+	}
+
+	entry, err := Parse(s.path, strings.NewReader(src))
+	if err != nil {
+		return Entry{}, nil, false
+	}
+
+	return entry, tokens, true
+}
+
+// docChecker compares snippets against the locked definitions.
+type docChecker struct {
+	index    typeIndex
+	services map[string]Service
+	// names maps the names of the messages, enums and services relative to
+	// their package, e.g. "Invoice.Line", to their fully-qualified names.
+	names map[string][]string
+}
+
+func newDocChecker(lock Protolock) docChecker {
+	c := docChecker{
+		index:    getTypeIndex(lock),
+		services: make(map[string]Service),
+		names:    make(map[string][]string),
+	}
+	for name, t := range c.index {
+		rel := strings.TrimPrefix(name, t.Package+nestedPrefix)
+		if t.Package == "" {
+			rel = name
+		}
+		c.names[rel] = append(c.names[rel], name)
+	}
+	for _, def := range lock.Definitions {
+		pkg := def.Def.Package.Name
+		for _, svc := range def.Def.Services {
+			name := qualify(pkg, svc.Name)
+			c.services[name] = svc
+			c.names[svc.Name] = append(c.names[svc.Name], name)
+		}
+	}
+
+	return c
+}
+
+// lookup returns the fully-qualified name of the locked definition which the
+// definition name (relative to the package pkg of the snippet) documents:
+// that in package pkg if the snippet declares one, or else the only one of
+// that name.
+func (c docChecker) lookup(pkg, name string) (string, bool) {
+	if pkg != "" {
+		fqn := qualify(pkg, name)
+		_, isType := c.index[fqn]
+		_, isService := c.services[fqn]
+		return fqn, isType || isService
+	}
+	if fqns := c.names[name]; len(fqns) == 1 {
+		return fqns[0], true
+	}
+
+	return "", false
+}
+
+// check compares a snippet against the locked definitions.
+func (c docChecker) check(s docSnippet) []Warning {
+	entry, tokens, ok := parseSnippet(s)
+	if !ok {
+		return nil
+	}
+	pkg := entry.Package.Name
+	loc := snippetLocator{snippet: s, tokens: tokens}
+
+	var warnings []Warning
+	var lines []int
+	warn := func(line int, format string, args ...interface{}) {
+		lines = append(lines, line)
+		warnings = append(warnings, Warning{
+			Filepath: Protopath(fmt.Sprintf("%s:%d", s.path, line)),
+			Message:  fmt.Sprintf(format, args...),
+			RuleName: "CheckDocs",
+		})
+	}
+
+	var walk func(prefix string, msg Message)
+	walk = func(prefix string, msg Message) {
+		name := prefix + msg.Name
+		fqn, ok := c.lookup(pkg, name)
+		if t := c.index[fqn]; ok && t.Kind == kindMessage {
+			c.checkMessage(name, fqn, msg, t.Message, loc, warn)
+		}
+		for _, m := range msg.Messages {
+			walk(name+nestedPrefix, m)
+		}
+	}
+	for _, msg := range entry.Messages {
+		walk("", msg)
+	}
+
+	for _, enum := range entry.Enums {
+		fqn, ok := c.lookup(pkg, enum.Name)
+		t := c.index[fqn]
+		if !ok || t.Kind != kindEnum {
+			continue
+		}
+		decl := loc.declaration(enum.Name)
+		for _, field := range enum.EnumFields {
+			subject := fieldSubject(enum.Name, field.Name)
+			line := loc.member(decl, field.Name)
+			locked, byName := findEnumField(t.Enum.EnumFields, func(f EnumField) bool { return f.Name == field.Name })
+			switch {
+			case byName && locked.Integer != field.Integer:
+				warn(line, `%s is documented as %d, but locked as %d`, subject, field.Integer, locked.Integer)
+			case byName:
+			default:
+				if locked, ok := findEnumField(t.Enum.EnumFields, func(f EnumField) bool { return f.Integer == field.Integer }); ok {
+					warn(line, `%s is documented as %d, which is locked as "%s"`, subject, field.Integer, locked.Name)
+				} else {
+					warn(line, `%s is documented, but not in proto.lock`, subject)
+				}
+			}
+		}
+	}
+
+	for _, svc := range entry.Services {
+		fqn, ok := c.lookup(pkg, svc.Name)
+		locked, isService := c.services[fqn]
+		if !ok || !isService {
+			continue
+		}
+		scope := strings.TrimSuffix(fqn, nestedPrefix+svc.Name)
+		if scope == fqn {
+			scope = ""
+		}
+		decl := loc.declaration(svc.Name)
+		for _, rpc := range svc.RPCs {
+			subject := fmt.Sprintf(`"%s" RPC: "%s"`, svc.Name, rpc.Name)
+			line := loc.member(decl, rpc.Name)
+			var lockedRPC *RPC
+			for i := range locked.RPCs {
+				if locked.RPCs[i].Name == rpc.Name {
+					lockedRPC = &locked.RPCs[i]
+				}
+			}
+			if lockedRPC == nil {
+				warn(line, `%s is documented, but not in proto.lock`, subject)
+				continue
+			}
+			documented := rpcShape(c.resolve(scope, rpc.InType), c.resolve(scope, rpc.OutType), rpc.InStreamed, rpc.OutStreamed)
+			expected := rpcShape(c.resolve(scope, lockedRPC.InType), c.resolve(scope, lockedRPC.OutType), lockedRPC.InStreamed, lockedRPC.OutStreamed)
+			if documented != expected {
+				warn(line, `%s is documented as "%s", but locked as "%s"`, subject, documented, expected)
+			}
+		}
+	}
+
+	// the warnings are in the order of the snippet
+	sort.Stable(byLine{lines, warnings})
+
+	return warnings
+}
+
+type byLine struct {
+	lines    []int
+	warnings []Warning
+}
+
+func (b byLine) Len() int           { return len(b.lines) }
+func (b byLine) Less(i, j int) bool { return b.lines[i] < b.lines[j] }
+func (b byLine) Swap(i, j int) {
+	b.lines[i], b.lines[j] = b.lines[j], b.lines[i]
+	b.warnings[i], b.warnings[j] = b.warnings[j], b.warnings[i]
+}
+
+// checkMessage compares the fields and maps of a documented message against
+// those of the locked message fqn.
+func (c docChecker) checkMessage(name, fqn string, msg, locked Message, loc snippetLocator, warn func(int, string, ...interface{})) {
+	type member struct {
+		id   int
+		name string
+		// shape is the field type, as in CheckGenerated, with its named type
+		// resolved against the locked definitions
+		shape string
+	}
+	members := func(m Message) []member {
+		var members []member
+		for _, f := range m.Fields {
+			shape := c.resolve(fqn, f.Type)
+			if f.IsRepeated {
+				shape = "repeated " + shape
+			}
+			members = append(members, member{f.ID, f.Name, shape})
+		}
+		for _, mp := range m.Maps {
+			members = append(members, member{mp.Field.ID, mp.Field.Name,
+				fmt.Sprintf("map<%s, %s>", mp.KeyType, c.resolve(fqn, mp.Field.Type)),
+			})
+		}
+		return members
+	}
+	lockedMembers := members(locked)
+	find := func(match func(member) bool) (member, bool) {
+		for _, m := range lockedMembers {
+			if match(m) {
+				return m, true
+			}
+		}
+		return member{}, false
+	}
+
+	decl := loc.declaration(name)
+	for _, documented := range members(msg) {
+		subject := fieldSubject(name, documented.name)
+		line := loc.member(decl, documented.name)
+		if l, ok := find(func(m member) bool { return m.name == documented.name }); ok {
+			if l.id != documented.id {
+				warn(line, `%s is documented as number %d, but locked as %d`, subject, documented.id, l.id)
+			}
+			if l.shape != documented.shape {
+				warn(line, `%s is documented as type "%s", but locked as "%s"`, subject, documented.shape, l.shape)
+			}
+			continue
+		}
+		if l, ok := find(func(m member) bool { return m.id == documented.id }); ok {
+			warn(line, `%s is documented as number %d, which is locked as field "%s"`, subject, documented.id, l.name)
+			continue
+		}
+		if containsInt(locked.ReservedIDs, documented.id) {
+			warn(line, `%s is documented as number %d, which is reserved in proto.lock`, subject, documented.id)
+			continue
+		}
+		warn(line, `%s is documented, but not in proto.lock`, subject)
+	}
+}
+
+// resolve returns the fully-qualified name of a message or enum type, as
+// referenced from within scope, or the type itself if it is a scalar or is
+// not locked.
+func (c docChecker) resolve(scope, typ string) string {
+	if isScalarType(typ) {
+		return typ
+	}
+	if name, ok := c.index.resolve(scope, typ); ok {
+		return name
+	}
+
+	return trimDot(typ)
+}
+
+func findEnumField(fields []EnumField, match func(EnumField) bool) (EnumField, bool) {
+	for _, f := range fields {
+		if match(f) {
+			return f, true
+		}
+	}
+
+	return EnumField{}, false
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, v := range ints {
+		if v == i {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snippetLocator finds the lines of the definitions of a snippet in its
+// Markdown file.
+type snippetLocator struct {
+	snippet docSnippet
+	tokens  []editionToken
+}
+
+// declaration returns the index of the token naming the message, enum or
+// service name, e.g. "Invoice.Line" (nested within "Invoice"), or -1.
+func (l snippetLocator) declaration(name string) int {
+	from := 0
+	found := -1
+	for _, part := range strings.Split(name, nestedPrefix) {
+		found = -1
+		for i := from; i+1 < len(l.tokens); i++ {
+			switch l.tokens[i].text {
+			case "message", "enum", "service":
+				if l.tokens[i+1].text == part {
+					found = i + 1
+				}
+			}
+			if found >= 0 {
+				break
+			}
+		}
+		if found < 0 {
+			return -1
+		}
+		from = found + 1
+	}
+
+	return found
+}
+
+// member returns the line of the field, enum value or RPC name declared after
+// the token decl, or else that of the declaration, or else that of the
+// snippet.
+func (l snippetLocator) member(decl int, name string) int {
+	if decl < 0 {
+		return l.snippet.line
+	}
+	for i := decl + 1; i+1 < len(l.tokens); i++ {
+		t := l.tokens[i]
+		if t.text != name {
+			continue
+		}
+		if l.tokens[i+1].text == "=" || l.tokens[i-1].text == "rpc" {
+			return l.snippet.line + t.line - 1
+		}
+	}
+
+	return l.snippet.line + l.tokens[decl].line - 1
+}
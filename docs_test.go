@@ -0,0 +1,112 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const docsGuide = "# Billing\n" +
+	"\n" +
+	"An invoice lists its lines:\n" +
+	"\n" +
+	"```proto\n" +
+	"message Invoice {\n" +
+	"  message Line {\n" +
+	"    string sku = 1;\n" +
+	"  }\n" +
+	"  string id = 1;\n" +
+	"  ...\n" +
+	"  repeated Line items = 2;\n" +
+	"  map<string, int32> totals = 3;\n" +
+	"  string note = 9;\n" +
+	"```\n" +
+	"\n" +
+	"~~~protobuf\n" +
+	"package billing.v1;\n" +
+	"\n" +
+	"service Billing {\n" +
+	"  rpc Get(Invoice) returns (Invoice.Line);\n" +
+	"  rpc List(Invoice) returns (Invoice);\n" +
+	"}\n" +
+	"~~~\n" +
+	"\n" +
+	"```proto\n" +
+	"// not locked, the locked one being nested\n" +
+	"enum Status {\n" +
+	"  STATUS_UNSPECIFIED = 0;\n" +
+	"}\n" +
+	"```\n" +
+	"\n" +
+	"````protobuf\n" +
+	"message Invoice {\n" +
+	"  message Status {}\n" +
+	"  Status status = 4;\n" +
+	"}\n" +
+	"````\n" +
+	"\n" +
+	"```sh\n" +
+	"message Invoice { int64 id = 7; }\n" +
+	"```\n" +
+	"\n" +
+	"```proto\n" +
+	"  string id = 1;\n" +
+	"```\n"
+
+func TestCheckDocs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	entry, err := Parse("invoice.proto", strings.NewReader(generatedProto))
+	require.NoError(t, err)
+	lock := Protolock{Definitions: []Definition{{
+		Filepath: ProtoPath("billing/v1/invoice.proto"),
+		Def:      entry,
+	}}}
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := readerFromProtolock(&lock)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	docs := filepath.Join(dir, "docs")
+	guide := filepath.Join(docs, "guide.md")
+	writeTestFile(t, guide, docsGuide)
+	writeTestFile(t, filepath.Join(docs, "README.txt"), "```proto\nmessage Invoice { int64 id = 1; }\n```\n")
+
+	report, err := CheckDocs(*cfg, docs)
+	assert.Equal(t, ErrWarningsFound, err)
+	var found []string
+	for _, w := range report.Warnings {
+		found = append(found, string(w.Filepath)+" "+w.Message)
+	}
+	assert.Equal(t, []string{
+		guide + `:12 "Invoice" field: "items" is documented as number 2, which is locked as field "lines"`,
+		guide + `:13 "Invoice" field: "totals" is documented as type "map<string, int32>", but locked as "map<string, int64>"`,
+		guide + `:14 "Invoice" field: "note" is documented, but not in proto.lock`,
+		guide + `:21 "Billing" RPC: "Get" is documented as "(billing.v1.Invoice) returns (billing.v1.Invoice.Line)", but locked as "(billing.v1.Invoice) returns (stream billing.v1.Invoice.Line)"`,
+		guide + `:22 "Billing" RPC: "List" is documented, but not in proto.lock`,
+		guide + `:36 "Invoice" field: "status" is documented as number 4, but locked as 5`,
+	}, found)
+}
+
+func TestExtractProtoSnippets(t *testing.T) {
+	snippets := extractProtoSnippets("guide.md", []byte(docsGuide))
+	require.Len(t, snippets, 5)
+	assert.Equal(t, 6, snippets[0].line)
+	assert.True(t, strings.HasPrefix(snippets[0].src, "message Invoice {\n"))
+	assert.True(t, strings.HasSuffix(snippets[0].src, "string note = 9;"))
+	assert.Equal(t, 18, snippets[1].line)
+	assert.Equal(t, "  string id = 1;", snippets[4].src)
+
+	_, _, ok := parseSnippet(snippets[0])
+	assert.True(t, ok)
+	_, _, ok = parseSnippet(snippets[4])
+	assert.False(t, ok)
+}
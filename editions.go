@@ -0,0 +1,317 @@
+package protolock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+)
+
+const (
+	// VisibilityExport is the visibility of a message or enum which other
+	// files may reference.
+	VisibilityExport = "export"
+
+	// VisibilityLocal is the visibility of a message or enum which only its
+	// own file may reference.
+	VisibilityLocal = "local"
+)
+
+// visibilityEdition is the first edition with symbol visibility.
+const visibilityEdition = "2024"
+
+// symbolVisibilityFeature is the file option setting the default visibility
+// of the messages and enums of a file.
+const symbolVisibilityFeature = "features.default_symbol_visibility"
+
+// editionSource is a .proto source prepared for the parser, which does not
+// support editions: the edition declaration, the visibility keywords and the
+// reserved identifiers are recorded, then blanked out, keeping the positions
+// of everything else.
+type editionSource struct {
+	src     []byte
+	edition string
+	// keywords maps the position of each "message" or "enum" keyword to the
+	// visibility keyword preceding it.
+	keywords map[[2]int]string
+	// reserved maps the position of each "reserved" keyword to the names it
+	// reserves as identifiers, which editions use rather than strings.
+	reserved map[[2]int][]string
+	// defaults is the value of the symbolVisibilityFeature option, if any.
+	defaults string
+}
+
+// editionToken is a token of a .proto source, located by its byte offsets
+// and by its position as reported by the parser.
+type editionToken struct {
+	text       string
+	start, end int
+	line, col  int
+}
+
+// prepareEditions records and blanks out the edition declaration, the
+// visibility keywords and the reserved identifiers of src.
+func prepareEditions(src []byte) editionSource {
+	s := editionSource{
+		src:      append([]byte{}, src...),
+		keywords: make(map[[2]int]string),
+		reserved: make(map[[2]int][]string),
+	}
+	blank := func(start, end int) {
+		for i := start; i < end; i++ {
+			if s.src[i] != '\n' {
+				s.src[i] = ' '
+			}
+		}
+	}
+
+	tokens := scanEditionTokens(src)
+	at := func(i int) string {
+		if i < len(tokens) {
+			return tokens[i].text
+		}
+		return ""
+	}
+	for i, t := range tokens {
+		if i > 0 && at(i-1) != ";" && at(i-1) != "{" && at(i-1) != "}" {
+			continue
+		}
+		switch t.text {
+		case "edition":
+			if at(i+1) != "=" || at(i+3) != ";" {
+				continue
+			}
+			edition, err := strconv.Unquote(at(i + 2))
+			if err != nil {
+				continue
+			}
+			s.edition = edition
+			blank(t.start, tokens[i+3].end)
+		case VisibilityExport, VisibilityLocal:
+			if (at(i+1) != "message" && at(i+1) != "enum") || at(i+3) != "{" {
+				continue
+			}
+			kw := tokens[i+1]
+			s.keywords[[2]int{kw.line, kw.col}] = t.text
+			blank(t.start, t.end)
+		case "reserved":
+			if s.edition == "" {
+				continue
+			}
+			names, end := reservedIdentifiers(tokens[i+1:])
+			if len(names) == 0 {
+				continue
+			}
+			s.reserved[[2]int{t.line, t.col}] = names
+			blank(t.end, tokens[i+end].start)
+		}
+	}
+
+	return s
+}
+
+// reservedIdentifiers returns the names of a reserved statement which reserves
+// identifiers, i.e. of the tokens following its keyword, and the index of the
+// closing semicolon among them.
+func reservedIdentifiers(tokens []editionToken) ([]string, int) {
+	var names []string
+	for i, t := range tokens {
+		switch {
+		case i%2 == 1 && t.text == ";":
+			return names, i
+		case i%2 == 1 && t.text == ",":
+		case i%2 == 0 && isIdentifier(t.text):
+			names = append(names, t.text)
+		default:
+			return nil, 0
+		}
+	}
+
+	return nil, 0
+}
+
+// isIdentifier reports whether a token is an identifier, rather than a
+// number, a string, a qualified name or punctuation.
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// reservedNames returns the names reserved by r, either as strings or, in
+// editions, as identifiers.
+func (s editionSource) reservedNames(r *proto.Reserved) []string {
+	return append(r.FieldNames, s.reserved[[2]int{r.Position.Line, r.Position.Column}]...)
+}
+
+// scanEditionTokens splits src into identifiers, quoted strings and single
+// punctuation characters, skipping whitespace and comments.
+func scanEditionTokens(src []byte) []editionToken {
+	var tokens []editionToken
+	line, col := 1, 1
+	i := 0
+	advance := func() {
+		if src[i] == '\n' {
+			line, col = line+1, 1
+		} else if src[i]&0xC0 != 0x80 {
+			// columns count characters, not the continuation bytes
+			col++
+		}
+		i++
+	}
+	isIdent := func(b byte) bool {
+		return b == '_' || b == '.' || (b >= 'a' && b <= 'z') ||
+			(b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+	}
+
+	for i < len(src) {
+		b := src[i]
+		switch {
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
+			advance()
+		case b == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				advance()
+			}
+		case b == '/' && i+1 < len(src) && src[i+1] == '*':
+			advance()
+			advance()
+			for i < len(src) && !(src[i] == '*' && i+1 < len(src) && src[i+1] == '/') {
+				advance()
+			}
+			for j := 0; j < 2 && i < len(src); j++ {
+				advance()
+			}
+		default:
+			t := editionToken{start: i, line: line, col: col}
+			switch {
+			case b == '"' || b == '\'':
+				advance()
+				for i < len(src) && src[i] != b && src[i] != '\n' {
+					if src[i] == '\\' && i+1 < len(src) {
+						advance()
+					}
+					advance()
+				}
+				if i < len(src) && src[i] == b {
+					advance()
+				}
+			case isIdent(b):
+				for i < len(src) && isIdent(src[i]) {
+					advance()
+				}
+			default:
+				advance()
+			}
+			t.end = i
+			t.text = string(src[t.start:t.end])
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens
+}
+
+// symbolVisibilityDefault returns the value of the symbolVisibilityFeature
+// option of a parsed file, if any.
+func symbolVisibilityDefault(def *proto.Proto) string {
+	for _, e := range def.Elements {
+		if o, ok := e.(*proto.Option); ok && o.Name == symbolVisibilityFeature {
+			return o.Constant.Source
+		}
+	}
+
+	return ""
+}
+
+// visibility returns the effective visibility of the message or enum
+// declared at pos: that of its keyword, or else the default of the file,
+// which for edition 2024 exports the top-level types only. Files of earlier
+// editions or syntaxes have no visibility, so every type is exported.
+func (s editionSource) visibility(pos scanner.Position, nested bool) string {
+	if kw, ok := s.keywords[[2]int{pos.Line, pos.Column}]; ok {
+		return kw
+	}
+	if s.edition == "" || s.edition < visibilityEdition {
+		return ""
+	}
+
+	switch s.defaults {
+	case "EXPORT_ALL":
+		return VisibilityExport
+	case "LOCAL_ALL", "STRICT":
+		return VisibilityLocal
+	}
+	if nested {
+		return VisibilityLocal
+	}
+	return VisibilityExport
+}
+
+// NoLocalizingReferencedTypes compares the current vs. updated Protolock
+// definitions and will return a list of warnings for each message or enum
+// which has become local to its file, while a field, map or RPC of another
+// file references it, either in the updated definitions or in the current
+// ones (i.e. the importers recorded in proto.lock).
+func NoLocalizingReferencedTypes(cur, upd Protolock) ([]Warning, bool) {
+	var warnings []Warning
+	curIndex := getTypeIndex(cur)
+	updIndex := getTypeIndex(upd)
+
+	localized := make(map[string]lockType)
+	for name, t := range updIndex {
+		if typeVisibility(t) != VisibilityLocal {
+			continue
+		}
+		if prev, ok := curIndex[name]; ok && typeVisibility(prev) != VisibilityLocal {
+			localized[name] = t
+		}
+	}
+	if len(localized) == 0 {
+		return nil, true
+	}
+
+	seen := make(map[string]bool)
+	refs := append(getTypeReferences(upd, updIndex), getTypeReferences(cur, curIndex)...)
+	for _, ref := range refs {
+		t, ok := localized[ref.target]
+		if !ok || ref.filepath == t.Filepath {
+			continue
+		}
+		key := string(ref.filepath) + " " + ref.subject + " " + ref.target
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		name := strings.TrimPrefix(t.Name, t.Package+nestedPrefix)
+		warnings = append(warnings, Warning{
+			Filepath: OSPath(t.Filepath),
+			Message: fmt.Sprintf(`"%s" has become local, but is referenced by %s in %s`,
+				name, ref.subject, OSPath(ref.filepath),
+			),
+			Entity:   name,
+			Category: CategorySource,
+		})
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// typeVisibility returns the recorded visibility of a message or enum.
+func typeVisibility(t lockType) string {
+	if t.Kind == kindEnum {
+		return t.Enum.Visibility
+	}
+	return t.Message.Visibility
+}
@@ -0,0 +1,125 @@
+package protolock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const editionsProto = `// export message Commented {}
+edition = "2024";
+
+package shared.v1;
+
+message Shared {
+  string note = 1 [default = "local message X {"];
+  reserved total, due;
+
+  message Detail {}
+  export enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+
+local message Private {
+  export message Open {}
+}
+
+local enum Level {
+  LEVEL_UNSPECIFIED = 0;
+}
+`
+
+func TestParseEditions(t *testing.T) {
+	entry, err := Parse("shared.proto", strings.NewReader(editionsProto))
+	require.NoError(t, err)
+	assert.Equal(t, SyntaxEditions, entry.Syntax)
+	assert.Equal(t, "2024", entry.Edition)
+
+	require.Len(t, entry.Messages, 2)
+	shared, private := entry.Messages[0], entry.Messages[1]
+	assert.Equal(t, VisibilityExport, shared.Visibility)
+	assert.Equal(t, VisibilityLocal, shared.Messages[0].Visibility)
+	assert.Equal(t, VisibilityLocal, private.Visibility)
+	assert.Equal(t, VisibilityExport, private.Messages[0].Visibility)
+	assert.Equal(t, "local message X {", shared.Fields[0].Options[0].Value)
+	assert.Equal(t, []string{"total", "due"}, shared.ReservedNames)
+
+	enums := make(map[string]string)
+	for _, e := range entry.Enums {
+		enums[e.Name] = e.Visibility
+	}
+	assert.Equal(t, map[string]string{
+		"Shared.Kind": VisibilityExport,
+		"Level":       VisibilityLocal,
+	}, enums)
+
+	// the default of the file applies to the types without a keyword
+	src := strings.Replace(editionsProto, "package shared.v1;",
+		"package shared.v1;\noption features.default_symbol_visibility = LOCAL_ALL;", 1)
+	entry, err = Parse("shared.proto", strings.NewReader(src))
+	require.NoError(t, err)
+	assert.Equal(t, VisibilityLocal, entry.Messages[0].Visibility)
+
+	// earlier editions and syntaxes have no visibility
+	entry, err = Parse("shared.proto", strings.NewReader(
+		strings.Replace(editionsProto, `"2024"`, `"2023"`, 1)))
+	require.NoError(t, err)
+	assert.Equal(t, "", entry.Messages[0].Visibility)
+	assert.Equal(t, VisibilityLocal, entry.Messages[1].Visibility)
+
+	// the export keeps the edition and the visibility of each type
+	rendered := RenderProto(entry)
+	assert.Contains(t, rendered, `edition = "2023";`)
+	assert.Contains(t, rendered, "local message Private {")
+	assert.Contains(t, rendered, "reserved total, due;")
+	again, err := Parse("shared.proto", strings.NewReader(rendered))
+	require.NoError(t, err)
+	assert.True(t, equalEntries(entry, again))
+}
+
+const editionsImporterProto = `edition = "2024";
+
+package orders.v1;
+
+import "shared/v1/shared.proto";
+
+message Order {
+  shared.v1.Shared shared = 1;
+}
+`
+
+func TestNoLocalizingReferencedTypes(t *testing.T) {
+	sharedPath := ProtoPath("shared/v1/shared.proto")
+	ordersPath := ProtoPath("orders/v1/orders.proto")
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, sharedPath, editionsProto),
+		parseTestProtoAt(t, ordersPath, editionsImporterProto),
+	}}
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, sharedPath, strings.Replace(editionsProto, "\nmessage Shared", "\nlocal message Shared", 1)),
+		parseTestProtoAt(t, ordersPath, editionsImporterProto),
+	}}
+
+	warnings, ok := NoLocalizingReferencedTypes(cur, upd)
+	assert.False(t, ok)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, OSPath(sharedPath), warnings[0].Filepath)
+	assert.Equal(t,
+		`"Shared" has become local, but is referenced by "Order" field: "shared" in `+string(OSPath(ordersPath)),
+		warnings[0].Message,
+	)
+
+	// the references recorded in proto.lock count as well
+	upd.Definitions[1] = parseTestProtoAt(t, ordersPath,
+		strings.Replace(editionsImporterProto, "shared.v1.Shared shared = 1;", "", 1))
+	warnings, ok = NoLocalizingReferencedTypes(cur, upd)
+	assert.False(t, ok)
+	assert.Len(t, warnings, 1)
+
+	warnings, ok = NoLocalizingReferencedTypes(upd, upd)
+	assert.True(t, ok)
+	assert.Nil(t, warnings)
+}
@@ -0,0 +1,489 @@
+package protolock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportProto exports a Protolock as .proto source files.
+const ExportProto = "proto"
+
+// ErrUnknownExportFormat indicates that an export format other than those
+// supported by Export was requested.
+var ErrUnknownExportFormat = errors.New("unknown export format, use proto")
+
+const (
+	// maxFieldID and maxEnumValue are the values of "max" in the reserved
+	// ranges of messages and enums.
+	maxFieldID   = 536870911
+	maxEnumValue = 2147483647
+
+	exportHeader = "// Reconstructed from proto.lock by protolock export."
+)
+
+// stringOptions are the options whose values are always strings.
+var stringOptions = map[string]bool{
+	"go_package":             true,
+	"java_package":           true,
+	"java_outer_classname":   true,
+	"csharp_namespace":       true,
+	"objc_class_prefix":      true,
+	"php_namespace":          true,
+	"php_class_prefix":       true,
+	"php_metadata_namespace": true,
+	"ruby_package":           true,
+	"swift_prefix":           true,
+	"json_name":              true,
+}
+
+// constantValue matches values which are written without quotes: numbers,
+// booleans and enum values (by convention in upper case).
+var constantValue = regexp.MustCompile(
+	`^([-+]?([0-9][0-9a-fA-FxX.eE+-]*|inf|nan)|true|false|[A-Z][A-Z0-9_]*)$`,
+)
+
+// ExportOptions configures the output of Export.
+type ExportOptions struct {
+	// Format is ExportProto, which is also used if Format is empty.
+	Format string
+	// Dir is the directory in which the files are written, at the paths
+	// recorded in the proto.lock file. If Dir is empty, a new temporary
+	// directory is used, so that the exported files are not found within the
+	// proto root by the next "status" or "commit".
+	Dir string
+}
+
+// Export regenerates the files recorded in the proto.lock file into the
+// directory of the ExportOptions, returning the paths of the written files.
+func Export(cfg Config, opts ExportOptions) ([]string, error) {
+	if opts.Format != ExportProto && opts.Format != "" {
+		return nil, ErrUnknownExportFormat
+	}
+
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Dir == "" {
+		opts.Dir, err = ioutil.TempDir("", "protolock-export")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	for _, def := range lock.Definitions {
+		path := filepath.Join(opts.Dir, string(OSPath(def.Filepath)))
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(path, []byte(RenderProto(def.Def)), 0644)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// RenderProto regenerates the source of a .proto file from its Entry. Only
+// what is recorded in the Entry can be reproduced, so comments (other than
+// hints), formatting and the order of declarations are not preserved.
+func RenderProto(entry Entry) string {
+	w := &protoWriter{syntax: entry.Syntax}
+	if w.syntax == "" {
+		w.syntax = inferSyntax(entry)
+	}
+
+	w.line(exportHeader)
+	w.line("")
+	if w.syntax == SyntaxEditions {
+		w.line(`edition = "%s";`, entry.Edition)
+	} else {
+		w.line(`syntax = "%s";`, w.syntax)
+	}
+	if entry.Package.Name != "" {
+		w.line("")
+		w.line("package %s;", entry.Package.Name)
+	}
+	if len(entry.Imports) > 0 {
+		w.line("")
+		for _, imp := range entry.Imports {
+			w.line("import %s;", strconv.Quote(imp.Path))
+		}
+	}
+	if len(entry.Options) > 0 {
+		w.line("")
+		for _, o := range entry.Options {
+			w.line("option %s = %s;", o.Name, formatOptionValue(o, ""))
+		}
+	}
+
+	// nested enums are recorded at the top level, named after their
+	// immediate parent message
+	nested := make(map[string][]Enum)
+	messages := make(map[string]bool)
+	for _, msg := range entry.Messages {
+		collectMessageNames(messages, msg)
+	}
+	for _, enum := range entry.Enums {
+		i := strings.LastIndex(enum.Name, nestedPrefix)
+		if i < 0 {
+			continue
+		}
+		parent := enum.Name[:i]
+		if messages[parent] {
+			nested[parent] = append(nested[parent], enum)
+		}
+	}
+
+	for _, enum := range entry.Enums {
+		i := strings.LastIndex(enum.Name, nestedPrefix)
+		if i >= 0 && messages[enum.Name[:i]] {
+			continue
+		}
+		w.line("")
+		w.enum(enum)
+	}
+	for _, msg := range entry.Messages {
+		w.line("")
+		w.message(msg, nested)
+	}
+	for _, svc := range entry.Services {
+		w.line("")
+		w.service(svc)
+	}
+
+	return w.b.String()
+}
+
+// protoWriter accumulates indented lines of .proto source.
+type protoWriter struct {
+	b      strings.Builder
+	depth  int
+	syntax string
+}
+
+func (w *protoWriter) line(format string, args ...interface{}) {
+	if format == "" {
+		w.b.WriteString("\n")
+		return
+	}
+	w.b.WriteString(strings.Repeat("  ", w.depth))
+	fmt.Fprintf(&w.b, format, args...)
+	w.b.WriteString("\n")
+}
+
+func (w *protoWriter) hints(renamedFrom string, movedFrom Protopath) {
+	if renamedFrom != "" {
+		w.line("// %s %s", CommentRenamedFrom, renamedFrom)
+	}
+	if movedFrom != "" {
+		w.line("// %s %s", CommentMovedFrom, slashPath(movedFrom))
+	}
+}
+
+func (w *protoWriter) message(msg Message, nested map[string][]Enum) {
+	w.hints(msg.RenamedFrom, msg.MovedFrom)
+	// an extension of another package's message is recorded as a message
+	// with a fully-qualified name, which is not valid as a message name, by
+	// proto.lock files written before extend blocks were recorded as such
+	if msg.IsExtend || strings.Contains(msg.Name, nestedPrefix) {
+		w.line("extend %s {", msg.Name)
+	} else {
+		w.line("%smessage %s {", visibilityKeyword(msg.Visibility), msg.Name)
+	}
+	w.depth++
+
+	for _, o := range msg.Options {
+		w.line("option %s = %s;", o.Name, formatOptionValue(o, ""))
+	}
+
+	oneof := ""
+	for _, field := range msg.Fields {
+		if field.OneOf != oneof {
+			if oneof != "" {
+				w.depth--
+				w.line("}")
+			}
+			if field.OneOf != "" {
+				w.line("oneof %s {", field.OneOf)
+				w.depth++
+			}
+			oneof = field.OneOf
+		}
+
+		label := ""
+		switch {
+		case field.IsRepeated:
+			label = "repeated "
+		case field.IsRequired:
+			label = "required "
+		case field.IsOptional, w.syntax == SyntaxProto2 && field.OneOf == "":
+			label = "optional "
+		}
+		w.hints(field.RenamedFrom, "")
+		w.line("%s%s %s = %d%s;", label, field.Type, field.Name, field.ID,
+			formatFieldOptions(field.Options, field.Type),
+		)
+	}
+	if oneof != "" {
+		w.depth--
+		w.line("}")
+	}
+
+	for _, mp := range msg.Maps {
+		w.hints(mp.Field.RenamedFrom, "")
+		w.line("map<%s, %s> %s = %d%s;", mp.KeyType, mp.Field.Type,
+			mp.Field.Name, mp.Field.ID, formatFieldOptions(mp.Field.Options, ""),
+		)
+	}
+
+	w.reserved(msg.ReservedIDs, msg.ReservedNames, maxFieldID)
+	if len(msg.Extensions) > 0 {
+		w.line("extensions %s;", formatExtensionRanges(msg.Extensions))
+	}
+
+	// each nested enum is written once, into the first message named after
+	// its parent
+	for _, enum := range nested[msg.Name] {
+		w.enum(enum)
+	}
+	delete(nested, msg.Name)
+	for _, m := range msg.Messages {
+		w.message(m, nested)
+	}
+
+	w.depth--
+	w.line("}")
+}
+
+func (w *protoWriter) enum(enum Enum) {
+	name := enum.Name[strings.LastIndex(enum.Name, nestedPrefix)+1:]
+	w.hints("", enum.MovedFrom)
+	w.line("%senum %s {", visibilityKeyword(enum.Visibility), name)
+	w.depth++
+
+	if (enum.AllowAlias || hasAliases(enum)) && !hasOption(enum.Options, "allow_alias") {
+		w.line("option allow_alias = true;")
+	}
+	for _, o := range enum.Options {
+		w.line("option %s = %s;", o.Name, formatOptionValue(o, ""))
+	}
+	for _, field := range enum.EnumFields {
+		w.hints(field.RenamedFrom, "")
+		w.line("%s = %d%s;", field.Name, field.Integer,
+			formatFieldOptions(field.Options, ""),
+		)
+	}
+	w.reserved(enum.ReservedIDs, enum.ReservedNames, maxEnumValue)
+
+	w.depth--
+	w.line("}")
+}
+
+func (w *protoWriter) service(svc Service) {
+	w.hints(svc.RenamedFrom, "")
+	w.line("service %s {", svc.Name)
+	w.depth++
+
+	for _, rpc := range svc.RPCs {
+		in, out := rpc.InType, rpc.OutType
+		if rpc.InStreamed {
+			in = "stream " + in
+		}
+		if rpc.OutStreamed {
+			out = "stream " + out
+		}
+		w.hints(rpc.RenamedFrom, "")
+		if len(rpc.Options) == 0 {
+			w.line("rpc %s(%s) returns (%s);", rpc.Name, in, out)
+			continue
+		}
+		w.line("rpc %s(%s) returns (%s) {", rpc.Name, in, out)
+		w.depth++
+		for _, o := range rpc.Options {
+			w.line("option %s = %s;", o.Name, formatOptionValue(o, ""))
+		}
+		w.depth--
+		w.line("}")
+	}
+
+	w.depth--
+	w.line("}")
+}
+
+// reserved writes the reserved IDs (recorded one by one) as ranges, and the
+// reserved names.
+func (w *protoWriter) reserved(ids []int, names []string, max int) {
+	if len(ids) > 0 {
+		sorted := append([]int{}, ids...)
+		sort.Ints(sorted)
+
+		var ranges []string
+		for i := 0; i < len(sorted); {
+			j := i
+			for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+				j++
+			}
+			switch {
+			case sorted[j] == max && j > i:
+				ranges = append(ranges, fmt.Sprintf("%d to max", sorted[i]))
+			case j > i:
+				ranges = append(ranges, fmt.Sprintf("%d to %d", sorted[i], sorted[j]))
+			default:
+				ranges = append(ranges, strconv.Itoa(sorted[i]))
+			}
+			i = j + 1
+		}
+		w.line("reserved %s;", strings.Join(ranges, ", "))
+	}
+	if len(names) > 0 {
+		w.line("reserved %s;", strings.Join(formatReservedNames(names, w.syntax), ", "))
+	}
+}
+
+// formatReservedNames returns the names of a reserved statement as declared
+// in the syntax: as identifiers in editions, and as strings otherwise.
+func formatReservedNames(names []string, syntax string) []string {
+	if syntax == SyntaxEditions {
+		return names
+	}
+	var quoted []string
+	for _, name := range names {
+		quoted = append(quoted, strconv.Quote(name))
+	}
+	return quoted
+}
+
+func formatExtensionRanges(extensions []ExtensionRange) string {
+	var ranges []string
+	for _, rng := range extensions {
+		switch {
+		case rng.To == maxFieldID:
+			ranges = append(ranges, fmt.Sprintf("%d to max", rng.From))
+		case rng.To > rng.From:
+			ranges = append(ranges, fmt.Sprintf("%d to %d", rng.From, rng.To))
+		default:
+			ranges = append(ranges, strconv.Itoa(rng.From))
+		}
+	}
+
+	return strings.Join(ranges, ", ")
+}
+
+func formatFieldOptions(options []Option, fieldType string) string {
+	if len(options) == 0 {
+		return ""
+	}
+	var formatted []string
+	for _, o := range options {
+		formatted = append(formatted, fmt.Sprintf(
+			"%s = %s", o.Name, formatOptionValue(o, fieldType),
+		))
+	}
+
+	return " [" + strings.Join(formatted, ", ") + "]"
+}
+
+// formatOptionValue formats the value of an option. As the proto.lock file
+// does not record whether a value was a string, strings are recognized by the
+// option name, or by the field type for a default value, and otherwise by
+// anything which does not look like a number, boolean or enum value.
+func formatOptionValue(o Option, fieldType string) string {
+	if len(o.Aggregated) > 0 {
+		var values []string
+		for _, a := range o.Aggregated {
+			values = append(values, fmt.Sprintf(
+				"%s: %s", a.Name, formatOptionValue(a, ""),
+			))
+		}
+		return "{ " + strings.Join(values, " ") + " }"
+	}
+
+	isString := stringOptions[o.Name]
+	if o.Name == "default" && (fieldType == "string" || fieldType == "bytes") {
+		isString = true
+	}
+	if !isString && constantValue.MatchString(o.Value) {
+		return o.Value
+	}
+
+	return strconv.Quote(o.Value)
+}
+
+// inferSyntax guesses the syntax of an Entry from a proto.lock file written
+// before the syntax was recorded: explicit default values and enums which do
+// not start at 0 are only valid in proto2.
+func inferSyntax(entry Entry) string {
+	for _, enum := range entry.Enums {
+		if len(enum.EnumFields) > 0 && enum.EnumFields[0].Integer != 0 {
+			return SyntaxProto2
+		}
+	}
+	var hasDefaults func(msg Message) bool
+	hasDefaults = func(msg Message) bool {
+		for _, field := range msg.Fields {
+			if hasOption(field.Options, "default") {
+				return true
+			}
+		}
+		for _, m := range msg.Messages {
+			if hasDefaults(m) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, msg := range entry.Messages {
+		if hasDefaults(msg) {
+			return SyntaxProto2
+		}
+	}
+
+	return SyntaxProto3
+}
+
+func collectMessageNames(names map[string]bool, msg Message) {
+	names[msg.Name] = true
+	for _, m := range msg.Messages {
+		collectMessageNames(names, m)
+	}
+}
+
+func hasAliases(enum Enum) bool {
+	seen := make(map[int]bool)
+	for _, field := range enum.EnumFields {
+		if seen[field.Integer] {
+			return true
+		}
+		seen[field.Integer] = true
+	}
+
+	return false
+}
+
+// visibilityKeyword returns the keyword declaring a recorded visibility,
+// which is written explicitly, whatever the default of the file.
+func visibilityKeyword(visibility string) string {
+	if visibility == "" {
+		return ""
+	}
+	return visibility + " "
+}
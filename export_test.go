@@ -0,0 +1,147 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exportProto = `syntax = "proto2";
+package shop.v1;
+
+import "google/protobuf/descriptor.proto";
+
+option go_package = "example.com/shop/v1;shop";
+option optimize_for = SPEED;
+
+message Order {
+  option deprecated = true;
+  optional string id = 1 [default = "none"];
+  repeated Line lines = 2;
+  oneof payment {
+    string card = 3;
+    string voucher = 4 [deprecated = true];
+  }
+  map<string, int64> totals = 5;
+  reserved 6, 8 to 10;
+  reserved "legacy";
+
+  message Line {
+    optional Status status = 1 [default = PENDING];
+
+    enum Status {
+      PENDING = 1;
+      DONE = 2;
+    }
+  }
+}
+
+enum Currency {
+  option allow_alias = true;
+  EUR = 0;
+  EURO = 0;
+  USD = 1 [(code) = "usd"];
+  reserved 3 to 5;
+}
+
+extend google.protobuf.FieldOptions {
+  optional string code = 50000;
+}
+
+service Orders {
+  rpc Get(Order) returns (Order);
+  rpc Watch(stream Order) returns (stream Order) {
+    option (google.api.http) = { get: "/v1/orders" body: "*" };
+  }
+}
+`
+
+func assertRoundTrip(t *testing.T, path Protopath, source string) string {
+	def := parseTestProtoAt(t, path, source)
+	exported := RenderProto(def.Def)
+
+	again := parseTestProtoAt(t, path, exported)
+	assert.True(t, equalEntries(def.Def, again.Def), exported)
+	return exported
+}
+
+func TestRenderProto(t *testing.T) {
+	exported := assertRoundTrip(t, "shop.proto", exportProto)
+
+	assert.True(t, strings.HasPrefix(exported, exportHeader))
+	for _, line := range []string{
+		`option go_package = "example.com/shop/v1;shop";`,
+		`option optimize_for = SPEED;`,
+		`  optional string id = 1 [default = "none"];`,
+		`  oneof payment {`,
+		`    string voucher = 4 [deprecated = true];`,
+		`  map<string, int64> totals = 5;`,
+		`  reserved 6, 8 to 10;`,
+		`  reserved "legacy";`,
+		`    optional Status status = 1 [default = PENDING];`,
+		`    enum Status {`,
+		`  option allow_alias = true;`,
+		`  USD = 1 [(code) = "usd"];`,
+		`extend google.protobuf.FieldOptions {`,
+		`  rpc Watch(stream Order) returns (stream Order) {`,
+		`    option (google.api.http) = { get: "/v1/orders" body: "*" };`,
+	} {
+		assert.Contains(t, exported, line+"\n")
+	}
+}
+
+func TestRenderProtoRoundTrip(t *testing.T) {
+	for _, path := range []string{"testdata/test.proto", "testdata/imports_options.proto"} {
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assertRoundTrip(t, ProtoPath(Protopath(path)), string(b))
+	}
+	assertRoundTrip(t, "test.proto", afterRenamesProto)
+	assertRoundTrip(t, "moving.proto", movedEnumProto)
+
+	exported := assertRoundTrip(t, "billing.proto", migrateProto2)
+	assert.Contains(t, exported, "  required string id = 1;\n")
+	assert.Contains(t, exported, "  extensions 100 to 199, 1000 to max;\n")
+	assert.Contains(t, exported, "extend Invoice {\n")
+	exported = assertRoundTrip(t, "billing.proto", migrateProto3)
+	assert.Contains(t, exported, "  optional int64 total = 2;\n")
+}
+
+func TestInferSyntax(t *testing.T) {
+	def := parseTestProtoAt(t, "shop.proto", exportProto)
+	def.Def.Syntax = ""
+	assert.Equal(t, SyntaxProto2, inferSyntax(def.Def))
+
+	def = parseTestProtoAt(t, "test.proto", afterRenamesProto)
+	def.Def.Syntax = ""
+	assert.Equal(t, SyntaxProto3, inferSyntax(def.Def))
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg, err := NewConfig(".", ".", "", false)
+	require.NoError(t, err)
+	paths, err := Export(*cfg, ExportOptions{Format: ExportProto, Dir: dir})
+	require.NoError(t, err)
+	assert.Contains(t, paths, filepath.Join(dir, "testdata", "test.proto"))
+
+	// by default, the files are exported outside the proto root
+	paths, err = Export(*cfg, ExportOptions{})
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+	rel, err := filepath.Rel(os.TempDir(), paths[0])
+	require.NoError(t, err)
+	require.False(t, strings.HasPrefix(rel, ".."), paths[0])
+	defer os.RemoveAll(filepath.Join(os.TempDir(), strings.Split(rel, string(filepath.Separator))[0]))
+
+	_, err = Export(*cfg, ExportOptions{Format: "json", Dir: dir})
+	assert.Equal(t, ErrUnknownExportFormat, err)
+}
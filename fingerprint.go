@@ -0,0 +1,262 @@
+package protolock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fingerprintLength is the number of hex digits of a fingerprint, i.e. the
+// first 64 bits of the SHA-256 of the wire shape.
+const fingerprintLength = 16
+
+// wireEncodings maps the scalar types to how they are encoded on the wire.
+// Types sharing an encoding can be read as one another, e.g. an int32 as an
+// enum, or a string as bytes.
+var wireEncodings = map[string]string{
+	"int32":    "varint",
+	"int64":    "varint",
+	"uint32":   "varint",
+	"uint64":   "varint",
+	"bool":     "varint",
+	"sint32":   "zigzag",
+	"sint64":   "zigzag",
+	"fixed32":  "i32",
+	"sfixed32": "i32",
+	"float":    "i32",
+	"fixed64":  "i64",
+	"sfixed64": "i64",
+	"double":   "i64",
+	"string":   "len",
+	"bytes":    "len",
+}
+
+// MessageFingerprint is the wire fingerprint of a message in the tree, along
+// with its fingerprint in the proto.lock file, if any.
+type MessageFingerprint struct {
+	Name        string `json:"name,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Locked      string `json:"locked,omitempty"`
+}
+
+// Changed reports whether the wire format of the message differs from the
+// locked one.
+func (f MessageFingerprint) Changed() bool {
+	return f.Locked != "" && f.Locked != f.Fingerprint
+}
+
+// Fingerprint returns the wire fingerprints of the messages of the proto
+// files in the tree, given by their fully-qualified names, or of every
+// message if no names are given. The fingerprints recorded in the proto.lock
+// file are included if it exists.
+func Fingerprint(cfg Config, names ...string) ([]MessageFingerprint, error) {
+	updated, err := getUpdatedLock(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := Fingerprints(*updated)
+
+	locked := make(map[string]string)
+	lockFile, err := openLockFile(cfg)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer lockFile.Close()
+		current, err := FromReader(lockFile)
+		if err != nil {
+			return nil, err
+		}
+		locked = Fingerprints(current)
+	}
+
+	if len(names) == 0 {
+		for name := range fingerprints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var results []MessageFingerprint
+	for _, name := range names {
+		name = strings.TrimPrefix(name, nestedPrefix)
+		fingerprint, ok := fingerprints[name]
+		if !ok {
+			return nil, fmt.Errorf("no message %q found in the proto files", name)
+		}
+		results = append(results, MessageFingerprint{
+			Name:        name,
+			Fingerprint: fingerprint,
+			Locked:      locked[name],
+		})
+	}
+
+	return results, nil
+}
+
+// Fingerprints computes the wire fingerprint of every message (including
+// nested messages) in the lock, keyed by its fully-qualified name.
+//
+// A fingerprint only covers what determines the bytes on the wire: the
+// number, label and wire encoding of each field, and the shape of the
+// messages of message fields, recursively. Names, options (other than
+// packed), comments and oneofs are ignored, so that two messages with equal
+// fingerprints can read each other's encoding. A message type which is not
+// defined in the lock is represented by its name.
+func Fingerprints(lock Protolock) map[string]string {
+	s := &wireShaper{
+		index:  getTypeIndex(lock),
+		syntax: make(map[Protopath]string),
+		cache:  make(map[string]string),
+	}
+	for _, def := range lock.Definitions {
+		s.syntax[def.Filepath] = def.Def.Syntax
+	}
+
+	fingerprints := make(map[string]string)
+	for name, typ := range s.index {
+		if typ.Kind != kindMessage {
+			continue
+		}
+		shape, _ := s.shape(name)
+		sum := sha256.Sum256([]byte("{" + shape + "}"))
+		fingerprints[name] = hex.EncodeToString(sum[:])[:fingerprintLength]
+	}
+
+	return fingerprints
+}
+
+// setFingerprints records the wire fingerprint of every message in the lock.
+func setFingerprints(lock *Protolock) {
+	fingerprints := Fingerprints(*lock)
+
+	var set func(prefix string, msgs []Message)
+	set = func(prefix string, msgs []Message) {
+		for i := range msgs {
+			name := qualify(prefix, msgs[i].Name)
+			msgs[i].Fingerprint = fingerprints[name]
+			set(name, msgs[i].Messages)
+		}
+	}
+	for _, def := range lock.Definitions {
+		set(def.Def.Package.Name, def.Def.Messages)
+	}
+}
+
+// wireShaper describes the wire shapes of the messages of a typeIndex.
+type wireShaper struct {
+	index  typeIndex
+	syntax map[Protopath]string
+	// cache holds the shapes of messages which do not refer back to the
+	// messages enclosing them, so that they are the same wherever they are
+	// used.
+	cache map[string]string
+	// stack is the chain of messages being described, from the outermost.
+	stack []string
+}
+
+// shape describes the fields of the message, e.g.
+// "1:optional:varint;2:repeated:len{1:optional:len}", along with the lowest
+// position in the stack which is referred back to from within the message. A
+// field of a message type which is being described (i.e. a recursive type) is
+// written as "^n", referring to the message n levels up.
+func (s *wireShaper) shape(name string) (string, int) {
+	none := len(s.stack) + 1
+	if shape, ok := s.cache[name]; ok {
+		return shape, none
+	}
+	for i, n := range s.stack {
+		if n == name {
+			return "^" + strconv.Itoa(len(s.stack)-i), i
+		}
+	}
+
+	depth := len(s.stack)
+	s.stack = append(s.stack, name)
+	defer func() { s.stack = s.stack[:depth] }()
+
+	msg := s.index[name]
+	// repeated scalars are packed by default since proto3, editions included
+	packed := s.syntax[msg.Filepath] == SyntaxProto3 || s.syntax[msg.Filepath] == SyntaxEditions
+	low := none
+
+	type wireField struct {
+		id    int
+		shape string
+	}
+	var fields []wireField
+	for _, f := range msg.Message.Fields {
+		enc, ref := s.encoding(name, f.Type)
+		if ref < low {
+			low = ref
+		}
+		label := "optional"
+		if f.IsRepeated {
+			label = "repeated"
+			if !strings.HasPrefix(enc, "len") && isPacked(f.Options, packed) {
+				label = "packed"
+			}
+		}
+		fields = append(fields, wireField{f.ID, fmt.Sprintf("%d:%s:%s", f.ID, label, enc)})
+	}
+	for _, mp := range msg.Message.Maps {
+		// a map is encoded as a repeated message of its key and value
+		key, _ := s.encoding(name, mp.KeyType)
+		value, ref := s.encoding(name, mp.Field.Type)
+		if ref < low {
+			low = ref
+		}
+		fields = append(fields, wireField{mp.Field.ID, fmt.Sprintf(
+			"%d:repeated:len{1:optional:%s;2:optional:%s}", mp.Field.ID, key, value,
+		)})
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].id < fields[j].id })
+
+	shapes := make([]string, len(fields))
+	for i, f := range fields {
+		shapes[i] = f.shape
+	}
+	shape := strings.Join(shapes, ";")
+
+	if low >= depth {
+		s.cache[name] = shape
+	}
+	return shape, low
+}
+
+// encoding describes the wire encoding of a field of type typ, within the
+// message scope, along with the lowest position in the stack referred back
+// to by its shape.
+func (s *wireShaper) encoding(scope, typ string) (string, int) {
+	none := len(s.stack) + 1
+	if enc, ok := wireEncodings[typ]; ok {
+		return enc, none
+	}
+
+	name, ok := s.index.resolve(scope, typ)
+	if !ok {
+		return "len<" + strings.TrimPrefix(typ, nestedPrefix) + ">", none
+	}
+	if s.index[name].Kind == kindEnum {
+		return "varint", none
+	}
+
+	shape, ref := s.shape(name)
+	return "len{" + shape + "}", ref
+}
+
+// isPacked reports whether a repeated scalar field uses the packed encoding,
+// which is the default in proto3.
+func isPacked(opts []Option, proto3 bool) bool {
+	for _, o := range opts {
+		if o.Name == "packed" {
+			return o.Value == "true"
+		}
+	}
+
+	return proto3
+}
@@ -0,0 +1,148 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fingerprintProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+  }
+  string id = 1;
+  int64 total = 2;
+  Status status = 3;
+  repeated int32 codes = 4;
+  Line line = 5;
+  map<string, Line> lines = 6;
+  Invoice previous = 7;
+}
+
+message Line {
+  int64 amount = 1;
+  Line parent = 2;
+}
+`
+
+// fingerprintWireEqualProto changes the names, options and oneofs, and
+// types with the same encoding, and replaces the map with its entry message.
+const fingerprintWireEqualProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+  }
+  message LinesEntry {
+    string key = 1;
+    Line value = 2;
+  }
+  oneof key {
+    bytes invoice_id = 1 [deprecated = true];
+  }
+  uint64 total_amount = 2;
+  int32 status = 3;
+  repeated Status codes = 4 [packed = true];
+  Line line = 5;
+  repeated LinesEntry lines = 6;
+  Invoice previous = 7;
+}
+
+message Line {
+  int64 amount = 1;
+  Line parent = 2;
+}
+`
+
+func TestFingerprints(t *testing.T) {
+	base := Fingerprints(parseTestLock(t, fingerprintProto))
+	require.Len(t, base, 2)
+	assert.Len(t, base["billing.v1.Invoice"], fingerprintLength)
+
+	same := Fingerprints(parseTestLock(t, fingerprintWireEqualProto))
+	assert.Equal(t, base["billing.v1.Invoice"], same["billing.v1.Invoice"])
+	assert.Equal(t, base["billing.v1.Line"], same["billing.v1.Line"])
+
+	changes := map[string][2]string{
+		"field number":  {"int64 total = 2;", "int64 total = 8;"},
+		"zigzag":        {"int64 total = 2;", "sint64 total = 2;"},
+		"fixed":         {"int64 total = 2;", "fixed64 total = 2;"},
+		"unpacked":      {"repeated int32 codes = 4;", "repeated int32 codes = 4 [packed = false];"},
+		"label":         {"Line line = 5;", "repeated Line line = 5;"},
+		"nested shape":  {"int64 amount = 1;", "string amount = 1;"},
+		"added field":   {"Invoice previous = 7;", "Invoice previous = 7;\n  bool paid = 8;"},
+		"removed field": {"string id = 1;", "reserved 1;"},
+	}
+	for name, change := range changes {
+		source := replaceOnce(t, fingerprintProto, change[0], change[1])
+		changed := Fingerprints(parseTestLock(t, source))
+		assert.NotEqual(t, base["billing.v1.Invoice"], changed["billing.v1.Invoice"], name)
+	}
+
+	// the shapes of recursive messages refer back to the enclosing messages
+	s := &wireShaper{
+		index:  getTypeIndex(parseTestLock(t, fingerprintProto)),
+		syntax: map[Protopath]string{ProtoPath("billing/v1/invoice.proto"): SyntaxProto3},
+		cache:  make(map[string]string),
+	}
+	shape, _ := s.shape("billing.v1.Line")
+	assert.Equal(t, "1:optional:varint;2:optional:len{^1}", shape)
+}
+
+func TestWireEqual(t *testing.T) {
+	a := parseTestLock(t, fingerprintProto)
+	b := parseTestLock(t, replaceOnce(t, fingerprintProto, "string id = 1;", "bytes invoice_id = 1 [deprecated = true];"))
+	assert.False(t, a.Equal(&b))
+	assert.True(t, a.WireEqual(&b))
+
+	c := parseTestLock(t, replaceOnce(t, fingerprintProto, "int64 total = 2;", "sint64 total = 2;"))
+	assert.False(t, a.WireEqual(&c))
+}
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fingerprint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "billing", "v1", "invoice.proto")
+	writeTestFile(t, path, fingerprintProto)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := Init(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	writeTestFile(t, path, replaceOnce(t, fingerprintProto, "int64 amount = 1;", "double amount = 1;"))
+	fingerprints, err := Fingerprint(*cfg, "billing.v1.Line", ".billing.v1.Invoice.LinesEntry")
+	assert.EqualError(t, err, `no message "billing.v1.Invoice.LinesEntry" found in the proto files`)
+
+	fingerprints, err = Fingerprint(*cfg)
+	require.NoError(t, err)
+	require.Len(t, fingerprints, 2)
+	for _, f := range fingerprints {
+		assert.True(t, f.Changed(), f.Name)
+	}
+
+	// the lock records the fingerprints
+	f, err := openLockFile(*cfg)
+	require.NoError(t, err)
+	defer f.Close()
+	lock, err := FromReader(f)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprints[0].Locked, lock.Definitions[0].Def.Messages[0].Fingerprint)
+	assert.Equal(t, "billing.v1.Invoice", fingerprints[0].Name)
+}
+
+func replaceOnce(t *testing.T, s, old, new string) string {
+	require.Contains(t, s, old)
+	return strings.Replace(s, old, new, 1)
+}
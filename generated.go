@@ -0,0 +1,562 @@
+package protolock
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CheckGenerated compares the Go code generated from the proto files (the
+// *.pb.go files found in dir) against the proto.lock file, and returns a
+// Report with a warning for each difference between a generated file and the
+// locked definitions of the proto file it was generated from. The definitions
+// are read from the file descriptor embedded in the generated code, so only
+// what the proto.lock file records is compared: the package, imports,
+// messages, fields, maps, enums, services and RPCs (but not options, reserved
+// fields or oneofs).
+func CheckGenerated(cfg Config, dir string) (*Report, error) {
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Current: lock}
+	index := getTypeIndex(lock)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".pb.go") {
+			return nil
+		}
+
+		warnings, err := checkGeneratedFile(lock, index, path)
+		if err != nil {
+			return err
+		}
+		report.Warnings = append(report.Warnings, warnings...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Warnings) != 0 {
+		return report, ErrWarningsFound
+	}
+
+	return report, nil
+}
+
+// checkGeneratedFile compares a single generated file with the locked
+// definitions of its proto file. Generated files without an embedded file
+// descriptor (such as *_grpc.pb.go files) are skipped.
+func checkGeneratedFile(lock Protolock, index typeIndex, path string) ([]Warning, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok, err := rawDescriptor(file)
+	if err != nil || !ok {
+		return nil, err
+	}
+	fd, err := decodeFileDescriptor(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	def, ok := findGeneratedDefinition(lock, fd.name)
+	if !ok {
+		return []Warning{{
+			Filepath: Protopath(path),
+			Message: fmt.Sprintf(
+				`code generated from "%s" has no definitions in proto.lock`,
+				fd.name,
+			),
+			RuleName: "CheckGenerated",
+		}}, nil
+	}
+
+	generated := descriptorShape(fd)
+	locked := entryShape(def.Def, index, generated)
+
+	// the syntax is only compared if the proto.lock file records it
+	if _, ok := locked.items["syntax"]; !ok {
+		delete(generated.items, "syntax")
+	}
+
+	// an extension of a message declared in another file is recorded as a
+	// message of the extended type
+	for subject := range locked.items {
+		if generated.extendees[subject] {
+			if _, ok := generated.items[subject]; !ok {
+				delete(locked.items, subject)
+			}
+		}
+	}
+
+	var warnings []Warning
+	for _, subject := range locked.subjects(generated) {
+		l, inLock := locked.items[subject]
+		g, inGenerated := generated.items[subject]
+
+		var msg string
+		switch {
+		case inLock && !inGenerated:
+			msg = fmt.Sprintf(
+				`%s%s is in proto.lock, but not in the code generated from "%s"`,
+				subject, describeShape(l), fd.name,
+			)
+		case inGenerated && !inLock:
+			msg = fmt.Sprintf(
+				`%s%s is in the code generated from "%s", but not in proto.lock`,
+				subject, describeShape(g), fd.name,
+			)
+		case l != g:
+			msg = fmt.Sprintf(
+				`%s is locked as "%s", but generated from "%s" as "%s"`,
+				subject, l, fd.name, g,
+			)
+		default:
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Filepath: Protopath(path),
+			Message:  msg,
+			RuleName: "CheckGenerated",
+		})
+	}
+
+	return warnings, nil
+}
+
+// rawDescriptor finds the serialized file descriptor embedded in generated Go
+// code: the "file_<path>_rawDesc" byte slice or string constant of current
+// versions of protoc-gen-go, or the gzipped "fileDescriptor_<hash>" byte slice
+// of older versions.
+func rawDescriptor(file *ast.File) ([]byte, bool, error) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || (gen.Tok != token.VAR && gen.Tok != token.CONST) {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for i, name := range value.Names {
+				if !isDescriptorName(name.Name) || i >= len(value.Values) {
+					continue
+				}
+				b, ok, err := literalBytes(value.Values[i])
+				if err != nil {
+					return nil, false, fmt.Errorf("%s: %v", name.Name, err)
+				}
+				if ok {
+					return b, true, nil
+				}
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+func isDescriptorName(name string) bool {
+	return strings.HasSuffix(name, "_rawDesc") ||
+		strings.HasPrefix(name, "fileDescriptor")
+}
+
+// literalBytes evaluates a []byte composite literal, or a (concatenated)
+// string literal. Any other expression is not a literal, and is reported as
+// such rather than as an error.
+func literalBytes(expr ast.Expr) ([]byte, bool, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return literalBytes(e.X)
+
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return nil, false, nil
+		}
+		s, err := strconv.Unquote(e.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		return []byte(s), true, nil
+
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return nil, false, nil
+		}
+		x, ok, err := literalBytes(e.X)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		y, ok, err := literalBytes(e.Y)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		return append(x, y...), true, nil
+
+	case *ast.CompositeLit:
+		array, ok := e.Type.(*ast.ArrayType)
+		if !ok {
+			return nil, false, nil
+		}
+		if elt, ok := array.Elt.(*ast.Ident); !ok || elt.Name != "byte" {
+			return nil, false, nil
+		}
+		b := make([]byte, 0, len(e.Elts))
+		for _, elt := range e.Elts {
+			lit, ok := elt.(*ast.BasicLit)
+			if !ok || lit.Kind != token.INT {
+				return nil, false, nil
+			}
+			v, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil {
+				return nil, false, err
+			}
+			b = append(b, byte(v))
+		}
+		return b, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// findGeneratedDefinition finds the locked Definition of the proto file named
+// in a file descriptor. The name is relative to the include path of protoc,
+// which need not be the proto root, so a unique match of the trailing path
+// components is accepted as well.
+func findGeneratedDefinition(lock Protolock, name string) (Definition, bool) {
+	var matches []Definition
+	for _, def := range lock.Definitions {
+		path := slashPath(def.Filepath)
+		if path == name {
+			return def, true
+		}
+		if strings.HasSuffix(path, "/"+name) || strings.HasSuffix(name, "/"+path) {
+			matches = append(matches, def)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], true
+	}
+
+	return Definition{}, false
+}
+
+// generatedShape is a flat description of the definitions of a proto file:
+// items maps each subject (e.g. `"Invoice" field: "id"`) to its shape (e.g.
+// "1 string"), which is empty for subjects that only need to exist.
+type generatedShape struct {
+	items map[string]string
+	// types holds the fully-qualified types referenced by each subject of a
+	// file descriptor, in the order they appear in its shape.
+	types map[string][]string
+	// extendees holds the messages extended by a file descriptor, named as
+	// in entryShape.
+	extendees map[string]bool
+}
+
+func newGeneratedShape() generatedShape {
+	return generatedShape{
+		items:     make(map[string]string),
+		types:     make(map[string][]string),
+		extendees: make(map[string]bool),
+	}
+}
+
+// subjects returns the subjects of both shapes, sorted.
+func (s generatedShape) subjects(other generatedShape) []string {
+	var subjects []string
+	for subject := range s.items {
+		subjects = append(subjects, subject)
+	}
+	for subject := range other.items {
+		if _, ok := s.items[subject]; !ok {
+			subjects = append(subjects, subject)
+		}
+	}
+	sort.Strings(subjects)
+
+	return subjects
+}
+
+func describeShape(shape string) string {
+	if shape == "" {
+		return ""
+	}
+	return fmt.Sprintf(` (%s)`, shape)
+}
+
+func fieldSubject(parent, name string) string {
+	return fmt.Sprintf(`"%s" field: "%s"`, parent, name)
+}
+
+func fieldShape(id int, repeated bool, typ string) string {
+	if repeated {
+		return fmt.Sprintf("%d repeated %s", id, typ)
+	}
+	return fmt.Sprintf("%d %s", id, typ)
+}
+
+func mapShape(id int, key, value string) string {
+	return fmt.Sprintf("%d map<%s, %s>", id, key, value)
+}
+
+func rpcShape(in, out string, inStream, outStream bool) string {
+	if inStream {
+		in = "stream " + in
+	}
+	if outStream {
+		out = "stream " + out
+	}
+	return fmt.Sprintf("(%s) returns (%s)", in, out)
+}
+
+// descriptorShape describes the definitions of a file descriptor, naming them
+// as they are named in a proto.lock file.
+func descriptorShape(fd fileDescriptor) generatedShape {
+	s := newGeneratedShape()
+	if fd.pkg != "" {
+		s.items["package"] = fd.pkg
+	}
+	syntax := fd.syntax
+	if syntax == "" {
+		syntax = SyntaxProto2
+	}
+	s.items["syntax"] = syntax
+	for _, dep := range fd.dependencies {
+		s.items[fmt.Sprintf(`import "%s"`, dep)] = ""
+	}
+
+	mapEntries := make(map[string]messageDescriptor)
+	var collect func(scope string, msg messageDescriptor)
+	collect = func(scope string, msg messageDescriptor) {
+		scope = qualify(scope, msg.name)
+		if msg.mapEntry {
+			mapEntries[scope] = msg
+		}
+		for _, m := range msg.nested {
+			collect(scope, m)
+		}
+	}
+	for _, msg := range fd.messages {
+		collect(fd.pkg, msg)
+	}
+
+	var walk func(prefix string, msg messageDescriptor)
+	walk = func(prefix string, msg messageDescriptor) {
+		name := prefix + msg.name
+		s.items[fmt.Sprintf(`"%s"`, name)] = ""
+		for _, field := range msg.fields {
+			subject := fieldSubject(name, field.name)
+			if entry, ok := mapEntries[trimDot(field.typeName)]; ok && len(entry.fields) == 2 {
+				key, value := entry.fields[0].typeString(), entry.fields[1].typeString()
+				s.items[subject] = mapShape(field.number, key, value)
+				s.types[subject] = []string{key, value}
+				continue
+			}
+			typ := field.typeString()
+			s.items[subject] = fieldShape(field.number, field.label == labelRepeated, typ)
+			s.types[subject] = []string{typ}
+		}
+		for _, enum := range msg.enums {
+			s.addEnum(msg.name+nestedPrefix+enum.name, enum)
+		}
+		s.addExtensions(fd.pkg, msg.extensions)
+		for _, m := range msg.nested {
+			if !m.mapEntry {
+				walk(name+nestedPrefix, m)
+			}
+		}
+	}
+	for _, msg := range fd.messages {
+		walk("", msg)
+	}
+
+	for _, enum := range fd.enums {
+		s.addEnum(enum.name, enum)
+	}
+	s.addExtensions(fd.pkg, fd.extensions)
+
+	for _, svc := range fd.services {
+		s.items[fmt.Sprintf(`"%s"`, svc.name)] = ""
+		for _, method := range svc.methods {
+			subject := fmt.Sprintf(`"%s" RPC: "%s"`, svc.name, method.name)
+			in, out := trimDot(method.inputType), trimDot(method.outputType)
+			s.items[subject] = rpcShape(in, out,
+				method.clientStreaming, method.serverStreaming,
+			)
+			s.types[subject] = []string{in, out}
+		}
+	}
+
+	return s
+}
+
+// addEnum adds an enum, named as in a proto.lock file: nested enums are named
+// after their immediate parent message.
+func (s generatedShape) addEnum(name string, enum enumDescriptor) {
+	s.items[fmt.Sprintf(`"%s"`, name)] = ""
+	for _, value := range enum.values {
+		s.items[fieldSubject(name, value.name)] = strconv.Itoa(value.number)
+	}
+}
+
+// addExtensions adds extension fields as the fields of the message they
+// extend, named as written in an extend block: relative to the package of the
+// file if they are declared in it, and fully-qualified otherwise.
+func (s generatedShape) addExtensions(pkg string, extensions []fieldDescriptor) {
+	for _, ext := range extensions {
+		extendee := trimDot(ext.extendee)
+		if pkg != "" {
+			extendee = strings.TrimPrefix(extendee, pkg+nestedPrefix)
+		}
+		s.extendees[fmt.Sprintf(`"%s"`, extendee)] = true
+
+		subject := fieldSubject(extendee, ext.name)
+		typ := ext.typeString()
+		s.items[subject] = fieldShape(ext.number, ext.label == labelRepeated, typ)
+		s.types[subject] = []string{typ}
+	}
+}
+
+// entryShape describes the locked definitions of a proto file, for comparison
+// with the descriptorShape of its generated code. Message and enum types are
+// fully-qualified as they would be by protoc, using the types referenced by
+// the generated code to disambiguate types which are not in the proto.lock
+// file (i.e. those of imported files which are not tracked).
+func entryShape(entry Entry, index typeIndex, generated generatedShape) generatedShape {
+	s := newGeneratedShape()
+	pkg := entry.Package.Name
+	if pkg != "" {
+		s.items["package"] = pkg
+	}
+	if entry.Syntax != "" {
+		s.items["syntax"] = entry.Syntax
+	}
+	for _, imp := range entry.Imports {
+		s.items[fmt.Sprintf(`import "%s"`, imp.Path)] = ""
+	}
+
+	resolve := func(scope, typ, subject string, i int) string {
+		if isScalarType(typ) {
+			return typ
+		}
+		if types := generated.types[subject]; i < len(types) && referencesType(scope, typ, types[i]) {
+			return types[i]
+		}
+		if name, ok := index.resolve(scope, typ); ok {
+			return name
+		}
+		return trimDot(typ)
+	}
+
+	var walk func(scope, prefix string, msg Message)
+	walk = func(scope, prefix string, msg Message) {
+		// an extend block is recorded as a message named after the type it
+		// extends, which may be qualified
+		name := prefix + msg.Name
+		extend := strings.Contains(msg.Name, nestedPrefix)
+		if extend {
+			name = msg.Name
+		} else {
+			s.items[fmt.Sprintf(`"%s"`, name)] = ""
+		}
+		fieldScope := scope
+		if !extend {
+			fieldScope = qualify(scope, msg.Name)
+		}
+
+		for _, field := range msg.Fields {
+			subject := fieldSubject(name, field.Name)
+			s.items[subject] = fieldShape(field.ID, field.IsRepeated,
+				resolve(fieldScope, field.Type, subject, 0),
+			)
+		}
+		for _, mp := range msg.Maps {
+			subject := fieldSubject(name, mp.Field.Name)
+			s.items[subject] = mapShape(mp.Field.ID, mp.KeyType,
+				resolve(fieldScope, mp.Field.Type, subject, 1),
+			)
+		}
+		for _, m := range msg.Messages {
+			walk(fieldScope, name+nestedPrefix, m)
+		}
+	}
+	for _, msg := range entry.Messages {
+		walk(pkg, "", msg)
+	}
+
+	for _, enum := range entry.Enums {
+		s.items[fmt.Sprintf(`"%s"`, enum.Name)] = ""
+		for _, field := range enum.EnumFields {
+			s.items[fieldSubject(enum.Name, field.Name)] = strconv.Itoa(field.Integer)
+		}
+	}
+
+	for _, svc := range entry.Services {
+		s.items[fmt.Sprintf(`"%s"`, svc.Name)] = ""
+		for _, rpc := range svc.RPCs {
+			subject := fmt.Sprintf(`"%s" RPC: "%s"`, svc.Name, rpc.Name)
+			s.items[subject] = rpcShape(
+				resolve(pkg, rpc.InType, subject, 0),
+				resolve(pkg, rpc.OutType, subject, 1),
+				rpc.InStreamed, rpc.OutStreamed,
+			)
+		}
+	}
+
+	return s
+}
+
+// isScalarType reports whether a field type is neither a message nor an enum.
+func isScalarType(typ string) bool {
+	for _, name := range scalarTypeNames {
+		if name == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// referencesType reports whether typ, as referenced from within scope, may
+// refer to the fully-qualified type name, following the protobuf scoping
+// rules.
+func referencesType(scope, typ, name string) bool {
+	if strings.HasPrefix(typ, nestedPrefix) {
+		return typ[1:] == name
+	}
+	for {
+		if qualify(scope, typ) == name {
+			return true
+		}
+		if scope == "" {
+			return false
+		}
+		i := strings.LastIndex(scope, nestedPrefix)
+		if i < 0 {
+			scope = ""
+			continue
+		}
+		scope = scope[:i]
+	}
+}
@@ -0,0 +1,208 @@
+package protolock
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const generatedProto = `syntax = "proto3";
+package billing.v1;
+
+import "google/protobuf/timestamp.proto";
+
+message Invoice {
+  message Line {
+    string sku = 1;
+  }
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    PAID = 1;
+  }
+  string id = 1;
+  repeated Line lines = 2;
+  map<string, int64> totals = 3;
+  google.protobuf.Timestamp created = 4;
+  Status status = 5;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (stream Invoice.Line);
+}
+`
+
+// the test descriptors are encoded with these helpers, as there is no
+// protobuf runtime to marshal them
+
+func encodeVarint(num int, v uint64) []byte {
+	b := binary.AppendUvarint(nil, uint64(num<<3|wireVarint))
+	return binary.AppendUvarint(b, v)
+}
+
+func encodeBytes(num int, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.AppendUvarint(nil, uint64(num<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(body)))
+	return append(b, body...)
+}
+
+func encodeString(num int, s string) []byte {
+	return encodeBytes(num, []byte(s))
+}
+
+func encodeField(name string, number, label, typ int, typeName string) []byte {
+	return encodeBytes(2,
+		encodeString(1, name),
+		encodeVarint(3, uint64(number)),
+		encodeVarint(4, uint64(label)),
+		encodeVarint(5, uint64(typ)),
+		encodeString(6, typeName),
+	)
+}
+
+// invoiceDescriptor encodes the FileDescriptorProto of generatedProto, as
+// embedded by protoc-gen-go, with the type of the "id" field.
+func invoiceDescriptor(idType int) []byte {
+	const optional, repeated = 1, 3
+	return bytes.Join([][]byte{
+		encodeString(1, "billing/v1/invoice.proto"),
+		encodeString(2, "billing.v1"),
+		encodeString(3, "google/protobuf/timestamp.proto"),
+		encodeBytes(4,
+			encodeString(1, "Invoice"),
+			encodeField("id", 1, optional, idType, ""),
+			encodeField("lines", 2, repeated, 11, ".billing.v1.Invoice.Line"),
+			encodeField("totals", 3, repeated, 11, ".billing.v1.Invoice.TotalsEntry"),
+			encodeField("created", 4, optional, 11, ".google.protobuf.Timestamp"),
+			encodeField("status", 5, optional, 14, ".billing.v1.Invoice.Status"),
+			encodeBytes(3,
+				encodeString(1, "Line"),
+				encodeField("sku", 1, optional, 9, ""),
+			),
+			encodeBytes(3,
+				encodeString(1, "TotalsEntry"),
+				encodeField("key", 1, optional, 9, ""),
+				encodeField("value", 2, optional, 3, ""),
+				encodeBytes(7, encodeVarint(7, 1)),
+			),
+			encodeBytes(4,
+				encodeString(1, "Status"),
+				encodeBytes(2, encodeString(1, "STATUS_UNSPECIFIED"), encodeVarint(2, 0)),
+				encodeBytes(2, encodeString(1, "PAID"), encodeVarint(2, 1)),
+			),
+		),
+		encodeBytes(6,
+			encodeString(1, "Billing"),
+			encodeBytes(2,
+				encodeString(1, "Get"),
+				encodeString(2, ".billing.v1.Invoice"),
+				encodeString(3, ".billing.v1.Invoice.Line"),
+				encodeVarint(6, 1),
+			),
+		),
+		encodeString(12, "proto3"),
+	}, nil)
+}
+
+func byteSliceLiteral(b []byte) string {
+	var elts []string
+	for _, c := range b {
+		elts = append(elts, fmt.Sprintf("0x%02x", c))
+	}
+	return "[]byte{\n\t" + strings.Join(elts, ", ") + ",\n}"
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCheckGenerated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generated")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	entry, err := Parse("invoice.proto", strings.NewReader(generatedProto))
+	require.NoError(t, err)
+	lock := Protolock{Definitions: []Definition{{
+		Filepath: ProtoPath("billing/v1/invoice.proto"),
+		Def:      entry,
+	}}}
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := readerFromProtolock(&lock)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	gen := filepath.Join(dir, "gen")
+	const stringType, int64Type = 9, 3
+
+	// current protoc-gen-go embeds the descriptor as a byte slice, or as a
+	// string constant
+	writeTestFile(t, filepath.Join(gen, "a", "invoice.pb.go"), fmt.Sprintf(
+		"package billingv1\n\nvar file_billing_v1_invoice_proto_rawDesc = %s\n",
+		byteSliceLiteral(invoiceDescriptor(stringType)),
+	))
+	raw := string(invoiceDescriptor(stringType))
+	writeTestFile(t, filepath.Join(gen, "b", "invoice.pb.go"), fmt.Sprintf(
+		"package billingv1\n\nconst file_billing_v1_invoice_proto_rawDesc = \"\" +\n\t%s +\n\t%s\n",
+		strconv.Quote(raw[:20]), strconv.Quote(raw[20:]),
+	))
+	writeTestFile(t, filepath.Join(gen, "b", "invoice_grpc.pb.go"),
+		"package billingv1\n\nconst Billing_Get_FullMethodName = \"/billing.v1.Billing/Get\"\n",
+	)
+
+	report, err := CheckGenerated(*cfg, gen)
+	require.NoError(t, err)
+	assert.Len(t, report.Warnings, 0)
+
+	// older versions embed a gzipped descriptor
+	stale := filepath.Join(gen, "c", "invoice.pb.go")
+	writeTestFile(t, stale, fmt.Sprintf(
+		"package billingv1\n\nvar fileDescriptor_0123456789abcdef = %s\n",
+		byteSliceLiteral(gzipped(t, invoiceDescriptor(int64Type))),
+	))
+
+	report, err = CheckGenerated(*cfg, gen)
+	assert.Equal(t, ErrWarningsFound, err)
+	require.Len(t, report.Warnings, 1)
+	assert.Equal(t, Protopath(stale), report.Warnings[0].Filepath)
+	assert.Equal(t,
+		`"Invoice" field: "id" is locked as "1 string", but generated from "billing/v1/invoice.proto" as "1 int64"`,
+		report.Warnings[0].Message,
+	)
+}
+
+func TestCheckGeneratedShape(t *testing.T) {
+	fd, err := decodeFileDescriptor(invoiceDescriptor(9))
+	require.NoError(t, err)
+	shape := descriptorShape(fd)
+
+	assert.Equal(t, "2 repeated billing.v1.Invoice.Line", shape.items[`"Invoice" field: "lines"`])
+	assert.Equal(t, "3 map<string, int64>", shape.items[`"Invoice" field: "totals"`])
+	assert.Equal(t, "1", shape.items[`"Invoice.Status" field: "PAID"`])
+	assert.Equal(t, "(billing.v1.Invoice) returns (stream billing.v1.Invoice.Line)",
+		shape.items[`"Billing" RPC: "Get"`],
+	)
+	_, ok := shape.items[`"Invoice.TotalsEntry"`]
+	assert.False(t, ok)
+
+	assert.True(t, referencesType("billing.v1.Invoice", "Line", "billing.v1.Invoice.Line"))
+	assert.True(t, referencesType("billing.v1", "Invoice.Line", "billing.v1.Invoice.Line"))
+	assert.True(t, referencesType("billing.v1", ".other.Line", "other.Line"))
+	assert.False(t, referencesType("billing.v1", "Line", "billing.v1.Invoice.Line"))
+}
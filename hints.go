@@ -12,6 +12,18 @@ const (
 	// CommentSkip tells the parse step to skip the comparable entity.
 	CommentSkip = "@protolock:skip"
 
+	// CommentRenamedFrom declares the previous name of a message, field, enum
+	// value, service or RPC, e.g. "@protolock:renamed-from OldName".
+	CommentRenamedFrom = "@protolock:renamed-from"
+
+	// CommentMovedFrom declares the previous file of a message or enum,
+	// relative to the proto root, e.g. "@protolock:moved-from path/old.proto".
+	CommentMovedFrom = "@protolock:moved-from"
+
+	// CommentSunset declares the date after which a deprecated field or enum
+	// value is to be removed, e.g. "@protolock:sunset 2027-01-31".
+	CommentSunset = "@protolock:sunset"
+
 	// commentInternal is used for tests
 	commentInternal = "@protolock:internal"
 )
@@ -64,6 +76,31 @@ func hints(c *proto.Comment) []error {
 	return errs
 }
 
+// hintValue returns the argument following a hint within any of the provided
+// comments, e.g. "OldName" for "@protolock:renamed-from OldName". An empty
+// string is returned if the hint is not found.
+func hintValue(hint string, comments ...*proto.Comment) string {
+	for _, c := range comments {
+		if c == nil {
+			continue
+		}
+		for _, line := range c.Lines {
+			i := strings.Index(line, hint)
+			if i < 0 {
+				continue
+			}
+			fields := strings.Fields(line[i+len(hint):])
+			if len(fields) == 0 {
+				continue
+			}
+			debugHint(c, hint)
+			return fields[0]
+		}
+	}
+
+	return ""
+}
+
 func debugHint(c *proto.Comment, hint string) {
 	if debug {
 		fmt.Println(
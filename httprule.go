@@ -0,0 +1,415 @@
+package protolock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// httpRuleOption is the name of the option binding an RPC to HTTP routes.
+const httpRuleOption = "(google.api.http)"
+
+// httpBinding is an HTTP route of an RPC, from its HTTP rule or one of the
+// additional bindings of the rule.
+type httpBinding struct {
+	filepath Protopath
+	service  string
+	rpc      RPC
+	pkg      string
+
+	method       string
+	path         string
+	body         string
+	responseBody string
+}
+
+// subject names the RPC of the binding as the warnings of the rules do.
+func (b httpBinding) subject() string {
+	return fmt.Sprintf(`"%s" RPC: "%s"`, b.service, b.rpc.Name)
+}
+
+// route describes the binding, e.g. `GET "/v1/{name=shelves/*}"`.
+func (b httpBinding) route() string {
+	return fmt.Sprintf("%s %q", b.method, b.path)
+}
+
+// httpTemplate is a parsed path template of an HTTP rule, e.g.
+// "/v1/{name=shelves/*}/books:publish".
+type httpTemplate struct {
+	// segments are the literal segments, and the wildcards "*" and "**",
+	// with the variables replaced by their segments.
+	segments []string
+	verb     string
+	// variables are the field paths of the variables, e.g. "name".
+	variables []string
+}
+
+// String returns the normalised template, e.g. "/v1/shelves/*/books:publish",
+// without the names of the variables.
+func (t httpTemplate) String() string {
+	s := "/" + strings.Join(t.segments, "/")
+	if t.verb != "" {
+		s += ":" + t.verb
+	}
+	return s
+}
+
+// NoConflictingHTTPRoutes verifies that no two HTTP bindings (including
+// additional bindings) of the RPCs in the updated Protolock, across all
+// services, use the same HTTP method with path templates which are the same,
+// or which both match some path, as such routes are resolved unpredictably
+// by the HTTP gateway. Invalid path templates are reported as well.
+func NoConflictingHTTPRoutes(cur, upd Protolock) ([]Warning, bool) {
+	var warnings []Warning
+
+	type route struct {
+		binding  httpBinding
+		template httpTemplate
+	}
+	var routes []route
+	for _, b := range getHTTPBindings(upd) {
+		t, err := parseHTTPTemplate(b.path)
+		if err != nil {
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(b.filepath),
+				Message:  fmt.Sprintf(`%s is bound to an invalid path %s: %v`, b.subject(), b.route(), err),
+				Entity:   b.service,
+			})
+			continue
+		}
+		routes = append(routes, route{b, t})
+	}
+
+	for i, a := range routes {
+		for _, b := range routes[:i] {
+			if a.binding.method != b.binding.method || !a.template.overlaps(b.template) {
+				continue
+			}
+			conflict := "overlaps"
+			if a.template.String() == b.template.String() {
+				conflict = "is the same route as"
+			}
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(a.binding.filepath),
+				Message: fmt.Sprintf(`%s is bound to %s, which %s %s of %s`,
+					a.binding.subject(), a.binding.route(), conflict,
+					b.binding.route(), b.binding.subject(),
+				),
+				Entity: a.binding.service,
+			})
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// getHTTPBindings collects the HTTP bindings of the RPCs of every service,
+// ordered by file, service and RPC.
+func getHTTPBindings(lock Protolock) []httpBinding {
+	defs := append([]Definition{}, lock.Definitions...)
+	sort.SliceStable(defs, func(i, j int) bool { return defs[i].Filepath < defs[j].Filepath })
+
+	var bindings []httpBinding
+	for _, def := range defs {
+		for _, svc := range def.Def.Services {
+			for _, rpc := range svc.RPCs {
+				for _, o := range rpc.Options {
+					if o.Name != httpRuleOption {
+						continue
+					}
+					binding := httpBinding{
+						filepath: def.Filepath,
+						service:  svc.Name,
+						rpc:      rpc,
+						pkg:      def.Def.Package.Name,
+					}
+					bindings = append(bindings, parseHTTPRule(binding, o.Aggregated)...)
+				}
+			}
+		}
+	}
+
+	return bindings
+}
+
+// parseHTTPRule reads the binding of an HTTP rule and of its additional
+// bindings.
+func parseHTTPRule(binding httpBinding, fields []Option) []httpBinding {
+	// the additional bindings do not inherit the body of the rule
+	base := binding
+	var additional []httpBinding
+	bound := false
+	for _, f := range fields {
+		switch f.Name {
+		case "get", "put", "post", "delete", "patch":
+			binding.method = strings.ToUpper(f.Name)
+			binding.path = f.Value
+			bound = true
+		case "custom":
+			for _, c := range f.Aggregated {
+				switch c.Name {
+				case "kind":
+					binding.method = c.Value
+				case "path":
+					binding.path = c.Value
+				}
+			}
+			bound = true
+		case "body":
+			binding.body = f.Value
+		case "response_body":
+			binding.responseBody = f.Value
+		case "additional_bindings":
+			additional = append(additional, parseHTTPRule(base, f.Aggregated)...)
+		}
+	}
+
+	var bindings []httpBinding
+	if bound {
+		bindings = append(bindings, binding)
+	}
+	return append(bindings, additional...)
+}
+
+// parseHTTPTemplate parses a path template, following the grammar of the
+// google.api.HttpRule:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	FieldPath = IDENT { "." IDENT } ;
+//	Verb     = ":" LITERAL ;
+func parseHTTPTemplate(path string) (httpTemplate, error) {
+	var t httpTemplate
+	if !strings.HasPrefix(path, "/") {
+		return t, errors.New(`a path must start with "/"`)
+	}
+	rest := path[1:]
+
+	// the verb follows the last segment, outside of any variable
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i:], "}") {
+		t.verb = rest[i+1:]
+		rest = rest[:i]
+		if t.verb == "" || strings.Contains(t.verb, "/") {
+			return t, fmt.Errorf("invalid verb %q", t.verb)
+		}
+	}
+
+	for rest != "" {
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return t, errors.New(`unclosed variable, missing "}"`)
+			}
+			variable := rest[1:end]
+			rest = rest[end+1:]
+			if strings.Contains(variable, "{") {
+				return t, errors.New("nested variables are not allowed")
+			}
+
+			field, segments := variable, "*"
+			if i := strings.Index(variable, "="); i >= 0 {
+				field, segments = variable[:i], variable[i+1:]
+			}
+			if !isFieldPath(field) {
+				return t, fmt.Errorf("invalid field path %q", field)
+			}
+			t.variables = append(t.variables, field)
+			for _, s := range strings.Split(segments, "/") {
+				if err := t.addSegment(s); err != nil {
+					return t, err
+				}
+			}
+		} else {
+			segment := rest
+			if i := strings.Index(rest, "/"); i >= 0 {
+				segment = rest[:i]
+			}
+			rest = rest[len(segment):]
+			if err := t.addSegment(segment); err != nil {
+				return t, err
+			}
+		}
+
+		if rest == "" {
+			break
+		}
+		if !strings.HasPrefix(rest, "/") {
+			return t, fmt.Errorf(`expected "/" before %q`, rest)
+		}
+		rest = rest[1:]
+		if rest == "" {
+			return t, errors.New("empty segment")
+		}
+	}
+
+	if len(t.segments) == 0 {
+		return t, errors.New("no segments")
+	}
+	for i, s := range t.segments {
+		if s == "**" && i != len(t.segments)-1 {
+			return t, errors.New(`"**" must be the last segment`)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *httpTemplate) addSegment(s string) error {
+	if s == "" {
+		return errors.New("empty segment")
+	}
+	if strings.ContainsAny(s, "{}=") {
+		return fmt.Errorf("invalid segment %q", s)
+	}
+	t.segments = append(t.segments, s)
+	return nil
+}
+
+// overlaps reports whether some path is matched by both templates.
+func (t httpTemplate) overlaps(u httpTemplate) bool {
+	if t.verb != u.verb {
+		return false
+	}
+
+	a, b := t.segments, u.segments
+	for i := 0; ; i++ {
+		// "**" matches any (or no) remaining segments
+		if (i < len(a) && a[i] == "**") || (i < len(b) && b[i] == "**") {
+			return true
+		}
+		if i == len(a) || i == len(b) {
+			return len(a) == len(b)
+		}
+		if a[i] != b[i] && a[i] != "*" && b[i] != "*" {
+			return false
+		}
+	}
+}
+
+// isFieldPath reports whether s is a dot-separated path of identifiers.
+func isFieldPath(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ident := range strings.Split(s, ".") {
+		if !identifier.MatchString(ident) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NoDanglingHTTPFieldPaths verifies that the field paths of the HTTP bindings
+// of the RPCs in the updated Protolock refer to fields of the locked messages:
+// those of the path variables and of the body to the request message, and
+// that of the response body to the response message. Nested paths, e.g.
+// "book.name", must go through singular message fields. Messages which are
+// not defined in the lock, e.g. well-known types, are not checked.
+func NoDanglingHTTPFieldPaths(cur, upd Protolock) ([]Warning, bool) {
+	var warnings []Warning
+	index := getTypeIndex(upd)
+
+	for _, b := range getHTTPBindings(upd) {
+		t, err := parseHTTPTemplate(b.path)
+		if err != nil {
+			// reported by NoConflictingHTTPRoutes
+			continue
+		}
+
+		type fieldPath struct {
+			kind, path, typ string
+		}
+		var paths []fieldPath
+		for _, v := range t.variables {
+			paths = append(paths, fieldPath{"path variable", v, b.rpc.InType})
+		}
+		if b.body != "" && b.body != "*" {
+			paths = append(paths, fieldPath{"body", b.body, b.rpc.InType})
+		}
+		if b.responseBody != "" && b.responseBody != "*" {
+			paths = append(paths, fieldPath{"response_body", b.responseBody, b.rpc.OutType})
+		}
+
+		for _, p := range paths {
+			msg, ok := index.resolve(b.pkg, p.typ)
+			if !ok {
+				continue
+			}
+			if err := resolveFieldPath(index, msg, p.path); err != nil {
+				warnings = append(warnings, Warning{
+					Filepath: OSPath(b.filepath),
+					Message: fmt.Sprintf(`%s is bound to %s with %s "%s", but %v`,
+						b.subject(), b.route(), p.kind, p.path, err,
+					),
+					Entity: b.service,
+				})
+			}
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// resolveFieldPath follows a dot-separated path of fields, starting from the
+// message with the fully-qualified name msg.
+func resolveFieldPath(index typeIndex, msg, path string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		typ, ok := index[msg]
+		if !ok || typ.Kind != kindMessage {
+			// e.g. a well-known type
+			return nil
+		}
+
+		var field *Field
+		for j := range typ.Message.Fields {
+			if typ.Message.Fields[j].Name == name {
+				field = &typ.Message.Fields[j]
+			}
+		}
+		if field == nil {
+			for _, mp := range typ.Message.Maps {
+				if mp.Field.Name != name {
+					continue
+				}
+				if i == len(names)-1 {
+					return nil
+				}
+				return fmt.Errorf(`field "%s" of "%s" is a map`, name, msg)
+			}
+			return fmt.Errorf(`"%s" has no field "%s"`, msg, name)
+		}
+		if i == len(names)-1 {
+			return nil
+		}
+
+		if field.IsRepeated {
+			return fmt.Errorf(`field "%s" of "%s" is repeated`, name, msg)
+		}
+		if _, ok := wireEncodings[field.Type]; ok {
+			return fmt.Errorf(`field "%s" of "%s" is not a message`, name, msg)
+		}
+		next, ok := index.resolve(msg, field.Type)
+		if !ok {
+			return nil
+		}
+		if index[next].Kind != kindMessage {
+			return fmt.Errorf(`field "%s" of "%s" is not a message`, name, msg)
+		}
+		msg = next
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const httpRoutesProto = `syntax = "proto3";
+package library.v1;
+
+import "google/api/annotations.proto";
+
+message Shelf {
+  string name = 1;
+}
+
+service Library {
+  rpc GetShelf(Shelf) returns (Shelf) {
+    option (google.api.http) = { get: "/v1/{name=shelves/*}" };
+  }
+  rpc GetFeatured(Shelf) returns (Shelf) {
+    option (google.api.http) = { get: "/v1/shelves/featured" };
+  }
+  rpc ListBooks(Shelf) returns (Shelf) {
+    option (google.api.http) = {
+      get: "/v1/{name=shelves/*}/books"
+      additional_bindings { get: "/v1/books" }
+    };
+  }
+  rpc Publish(Shelf) returns (Shelf) {
+    option (google.api.http) = {
+      post: "/v1/{name=shelves/*}:publish"
+      body: "*"
+    };
+  }
+}
+
+service Archive {
+  rpc GetShelf(Shelf) returns (Shelf) {
+    option (google.api.http) = { get: "/v1/{name=shelves/*}" };
+  }
+  rpc Search(Shelf) returns (Shelf) {
+    option (google.api.http) = {
+      custom: { kind: "SEARCH" path: "/v1/**" }
+      additional_bindings: [{ get: "/v2/{name=**}" }, { get: "v2/shelves" }]
+    };
+  }
+}
+`
+
+func TestNoConflictingHTTPRoutes(t *testing.T) {
+	lock := parseTestLock(t, httpRoutesProto)
+	bindings := getHTTPBindings(lock)
+	require.Len(t, bindings, 9)
+	assert.Equal(t, `POST "/v1/{name=shelves/*}:publish"`, bindings[4].route())
+	assert.Equal(t, "*", bindings[4].body)
+	assert.Equal(t, "", bindings[8].body)
+
+	warnings, ok := NoConflictingHTTPRoutes(lock, lock)
+	assert.False(t, ok)
+	var messages []string
+	for _, w := range warnings {
+		messages = append(messages, w.Message)
+	}
+	assert.Equal(t, []string{
+		`"Archive" RPC: "Search" is bound to an invalid path GET "v2/shelves": a path must start with "/"`,
+		`"Library" RPC: "GetFeatured" is bound to GET "/v1/shelves/featured", which overlaps GET "/v1/{name=shelves/*}" of "Library" RPC: "GetShelf"`,
+		`"Archive" RPC: "GetShelf" is bound to GET "/v1/{name=shelves/*}", which is the same route as GET "/v1/{name=shelves/*}" of "Library" RPC: "GetShelf"`,
+		`"Archive" RPC: "GetShelf" is bound to GET "/v1/{name=shelves/*}", which overlaps GET "/v1/shelves/featured" of "Library" RPC: "GetFeatured"`,
+	}, messages)
+}
+
+func TestParseHTTPTemplate(t *testing.T) {
+	valid := map[string]string{
+		"/v1/shelves":                             "/v1/shelves",
+		"/v1/{name=shelves/*/books/*}":            "/v1/shelves/*/books/*",
+		"/v1/{shelf.name}/books:publish":          "/v1/*/books:publish",
+		"/v1/{name=shelves/*}:publish":            "/v1/shelves/*:publish",
+		"/v1/{parent=projects/*}/files/{path=**}": "/v1/projects/*/files/**",
+	}
+	for path, normalised := range valid {
+		tmpl, err := parseHTTPTemplate(path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, normalised, tmpl.String())
+		}
+	}
+
+	tmpl, err := parseHTTPTemplate("/v1/{parent=projects/*}/files/{file.path=**}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"parent", "file.path"}, tmpl.variables)
+
+	for _, path := range []string{
+		"", "v1", "/", "/v1/", "/v1//shelves", "/v1/{name", "/v1/{na-me}",
+		"/v1/{name={id}}", "/v1/**/books", "/v1/shelves:", "/v1/{name}x",
+	} {
+		_, err := parseHTTPTemplate(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestHTTPTemplateOverlaps(t *testing.T) {
+	overlaps := func(a, b string) bool {
+		ta, err := parseHTTPTemplate(a)
+		require.NoError(t, err)
+		tb, err := parseHTTPTemplate(b)
+		require.NoError(t, err)
+		assert.Equal(t, ta.overlaps(tb), tb.overlaps(ta))
+		return ta.overlaps(tb)
+	}
+	assert.True(t, overlaps("/v1/{name}", "/v1/shelves"))
+	assert.True(t, overlaps("/v1/**", "/v1"))
+	assert.True(t, overlaps("/v1/{name=**}", "/v1/a/b/c"))
+	assert.False(t, overlaps("/v1/{name}", "/v1/a/b"))
+	assert.False(t, overlaps("/v1/{name}", "/v1/{name}:publish"))
+	assert.False(t, overlaps("/v1/shelves", "/v2/shelves"))
+}
+
+const httpFieldPathsProto = `syntax = "proto3";
+package library.v1;
+
+import "google/api/annotations.proto";
+import "google/protobuf/timestamp.proto";
+
+message GetBookRequest {
+  string name = 1;
+  Book book = 2;
+  repeated Book books = 3;
+  map<string, string> labels = 4;
+}
+
+message Book {
+  string name = 1;
+  Author author = 2;
+}
+
+message Author {
+  string id = 1;
+}
+
+service Books {
+  rpc GetBook(GetBookRequest) returns (Book) {
+    option (google.api.http) = {
+      get: "/v1/{name=books/*}"
+      additional_bindings { get: "/v1/{book.author.id}/books/{nme}" }
+    };
+  }
+  rpc UpdateBook(GetBookRequest) returns (Book) {
+    option (google.api.http) = {
+      patch: "/v1/{book.name=books/*}"
+      body: "labels"
+      response_body: "author"
+    };
+  }
+  rpc Bad(GetBookRequest) returns (Book) {
+    option (google.api.http) = {
+      post: "/v1/{books.name}/{name.value}"
+      body: "labels.key"
+      response_body: "title"
+    };
+  }
+  rpc Now(GetBookRequest) returns (google.protobuf.Timestamp) {
+    option (google.api.http) = {
+      get: "/v1/now"
+      response_body: "seconds"
+    };
+  }
+}
+`
+
+func TestNoDanglingHTTPFieldPaths(t *testing.T) {
+	lock := parseTestLock(t, httpFieldPathsProto)
+	warnings, ok := NoDanglingHTTPFieldPaths(lock, lock)
+	assert.False(t, ok)
+	var messages []string
+	for _, w := range warnings {
+		messages = append(messages, w.Message)
+	}
+	assert.Equal(t, []string{
+		`"Books" RPC: "GetBook" is bound to GET "/v1/{book.author.id}/books/{nme}" with path variable "nme", but "library.v1.GetBookRequest" has no field "nme"`,
+		`"Books" RPC: "Bad" is bound to POST "/v1/{books.name}/{name.value}" with path variable "books.name", but field "books" of "library.v1.GetBookRequest" is repeated`,
+		`"Books" RPC: "Bad" is bound to POST "/v1/{books.name}/{name.value}" with path variable "name.value", but field "name" of "library.v1.GetBookRequest" is not a message`,
+		`"Books" RPC: "Bad" is bound to POST "/v1/{books.name}/{name.value}" with body "labels.key", but field "labels" of "library.v1.GetBookRequest" is a map`,
+		`"Books" RPC: "Bad" is bound to POST "/v1/{books.name}/{name.value}" with response_body "title", but "library.v1.Book" has no field "title"`,
+	}, messages)
+}
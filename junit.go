@@ -0,0 +1,77 @@
+package protolock
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport encodes the report as a single JUnit test suite, with a
+// test case per rule. A rule's test case fails if it produced any warnings,
+// and the failure lists every warning.
+func writeJUnitReport(report *Report, w io.Writer) error {
+	warningsByRule := make(map[string][]Warning)
+	for _, warning := range report.Warnings {
+		name := warningRuleName(warning)
+		warningsByRule[name] = append(warningsByRule[name], warning)
+	}
+
+	suite := junitTestSuite{Name: "protolock"}
+	for _, name := range reportRuleNames(report) {
+		tc := junitTestCase{Name: name, ClassName: "protolock"}
+		if warnings := warningsByRule[name]; len(warnings) > 0 {
+			var lines []string
+			for _, warning := range warnings {
+				lines = append(lines, fmt.Sprintf(
+					"%s [%s]", warning.Message, OSPath(warning.Filepath),
+				))
+			}
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d warning(s) found", len(warnings)),
+				Type:    name,
+				Text:    strings.Join(lines, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
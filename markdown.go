@@ -0,0 +1,52 @@
+package protolock
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeMarkdownReport renders the report as a heading with its overall risk,
+// followed by a table of its warnings, the riskiest first.
+func writeMarkdownReport(report *Report, w io.Writer) error {
+	if len(report.Warnings) == 0 {
+		_, err := fmt.Fprintln(w, "### protolock: no warnings")
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### protolock: %s risk (%d)\n\n", report.Risk.Level, report.Risk.Score)
+	fmt.Fprintf(&b, "%d warning(s) found.\n\n", len(report.Warnings))
+	b.WriteString("| Risk | Rule | File | Warning |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, warning := range report.Warnings {
+		risk := ""
+		if warning.Risk != nil {
+			risk = fmt.Sprintf("%s (%d)", warning.Risk.Level, warning.Risk.Score)
+		}
+		message := warning.Message
+		if warning.Mode != "" {
+			message += " [" + warning.Mode + "]"
+		}
+		fmt.Fprintf(&b, "| %s | %s | `%s` | %s |\n",
+			risk, warningRuleName(warning), OSPath(warning.Filepath),
+			markdownCell(message),
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes the characters of a table cell which Markdown would
+// otherwise interpret.
+func markdownCell(s string) string {
+	return strings.NewReplacer(
+		`|`, `\|`,
+		"`", "\\`",
+		"*", `\*`,
+		"_", `\_`,
+		"<", "&lt;",
+		"\n", " ",
+	).Replace(s)
+}
@@ -0,0 +1,103 @@
+package protolock
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// MergeLockVersions merges the changes of two branches to the proto.lock file
+// (ours and theirs) since their common ancestor (base), file by file: the
+// definitions of a file changed on a single branch are taken from it, and the
+// files changed differently on both branches are returned as conflicts, with
+// the definitions of ours kept. The audit trail of the untracked files is the
+// union of both branches.
+func MergeLockVersions(base, ours, theirs Protolock) (Protolock, []Protopath) {
+	index := func(lock Protolock) map[Protopath]Definition {
+		defs := make(map[Protopath]Definition)
+		for _, def := range lock.Definitions {
+			defs[def.Filepath] = def
+		}
+		return defs
+	}
+	baseDefs, ourDefs, theirDefs := index(base), index(ours), index(theirs)
+	same := func(a, b map[Protopath]Definition, path Protopath) bool {
+		da, inA := a[path]
+		db, inB := b[path]
+		return inA == inB && (!inA || equalDefinitions(da, db))
+	}
+
+	paths := make(map[Protopath]bool)
+	for _, defs := range []map[Protopath]Definition{baseDefs, ourDefs, theirDefs} {
+		for path := range defs {
+			paths[path] = true
+		}
+	}
+
+	var merged Protolock
+	var conflicts []Protopath
+	for path := range paths {
+		from := ourDefs
+		switch {
+		case same(ourDefs, theirDefs, path), same(baseDefs, theirDefs, path):
+		case same(baseDefs, ourDefs, path):
+			from = theirDefs
+		default:
+			conflicts = append(conflicts, path)
+		}
+		if def, ok := from[path]; ok {
+			merged.Definitions = append(merged.Definitions, def)
+		}
+	}
+	orderDefinitions(merged.Definitions)
+	setFingerprints(&merged)
+
+	merged.Untracked = append(merged.Untracked, ours.Untracked...)
+	for _, u := range theirs.Untracked {
+		found := false
+		for _, v := range ours.Untracked {
+			found = found || u == v
+		}
+		if !found {
+			merged.Untracked = append(merged.Untracked, u)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i] < conflicts[j] })
+
+	return merged, conflicts
+}
+
+// MergeLockVersionFiles reads the base, ours and theirs versions of the
+// proto.lock file, as given to a git merge driver, and returns an io.Reader
+// with the lock representation data merged by MergeLockVersions for caller to
+// use as needed. An empty version, e.g. the base of a proto.lock file added
+// on both branches, has no definitions.
+func MergeLockVersionFiles(base, ours, theirs string) (io.Reader, []Protopath, error) {
+	var locks []Protolock
+	for _, path := range []string{base, ours, theirs} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(strings.TrimSpace(string(b))) == 0 {
+			locks = append(locks, Protolock{})
+			continue
+		}
+		lock, err := FromReader(strings.NewReader(string(b)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		locks = append(locks, lock)
+	}
+
+	merged, conflicts := MergeLockVersions(locks[0], locks[1], locks[2])
+	r, err := readerFromProtolock(&merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, conflicts, nil
+}
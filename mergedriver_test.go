@@ -0,0 +1,38 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeLockVersions(t *testing.T) {
+	v1 := parseTestProtoAt(t, "billing:/:v1:/:invoice.proto", untrackLegacyProto)
+	v1Changed := parseTestProtoAt(t, "billing:/:v1:/:invoice.proto", untrackReappearedProto)
+	v2 := parseTestProtoAt(t, "billing:/:v2:/:invoice.proto", untrackKeptProto)
+	v2Changed := parseTestProtoAt(t, "billing:/:v2:/:invoice.proto", untrackLegacyProto)
+	v3 := parseTestProtoAt(t, "billing:/:v3:/:invoice.proto", untrackKeptProto)
+	untracked := Untracked{Filepath: "billing:/:v0:/:invoice.proto", Package: "billing.v0"}
+
+	base := Protolock{Definitions: []Definition{v1, v2}}
+	ours := Protolock{Definitions: []Definition{v1Changed, v2}, Untracked: []Untracked{untracked}}
+	theirs := Protolock{Definitions: []Definition{v1, v2Changed, v3}, Untracked: []Untracked{untracked}}
+
+	merged, conflicts := MergeLockVersions(base, ours, theirs)
+	assert.Empty(t, conflicts)
+	require.Len(t, merged.Definitions, 3)
+	assert.True(t, equalDefinitions(v1Changed, merged.Definitions[0]))
+	assert.True(t, equalDefinitions(v2Changed, merged.Definitions[1]))
+	assert.True(t, equalDefinitions(v3, merged.Definitions[2]))
+	assert.Equal(t, []Untracked{untracked}, merged.Untracked)
+
+	// a file removed on one branch and changed on the other conflicts
+	theirs.Definitions = []Definition{v2}
+	ours.Definitions = []Definition{v1Changed, v2Changed}
+	merged, conflicts = MergeLockVersions(base, ours, theirs)
+	assert.Equal(t, []Protopath{"billing:/:v1:/:invoice.proto"}, conflicts)
+	require.Len(t, merged.Definitions, 2)
+	assert.True(t, equalDefinitions(v1Changed, merged.Definitions[0]))
+	assert.True(t, equalDefinitions(v2Changed, merged.Definitions[1]))
+}
@@ -0,0 +1,325 @@
+package protolock
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The target syntaxes of MigrateSyntaxCheck.
+const (
+	MigrateToProto3 = "proto3"
+	MigrateTo2023   = "2023"
+)
+
+// The kinds of SyntaxImpact.
+const (
+	ImpactPacked    = "packed"
+	ImpactPresence  = "presence"
+	ImpactEnum      = "enum"
+	ImpactDefault   = "default"
+	ImpactRequired  = "required"
+	ImpactExtension = "extension"
+)
+
+// ErrUnknownSyntaxTarget indicates that MigrateSyntaxCheck was asked for a
+// target syntax it does not support.
+var ErrUnknownSyntaxTarget = errors.New("unknown target syntax, use proto3 or 2023")
+
+// The features of editions which carry the semantics differing between the
+// syntaxes, and their values.
+const (
+	featureFieldPresence   = "features.field_presence"
+	featureFieldEncoding   = "features.repeated_field_encoding"
+	featureEnumType        = "features.enum_type"
+	presenceExplicit       = "EXPLICIT"
+	presenceImplicit       = "IMPLICIT"
+	presenceLegacyRequired = "LEGACY_REQUIRED"
+)
+
+// packableTypes are the scalar types of the repeated fields which may be
+// encoded packed, besides enums.
+var packableTypes = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true,
+	"uint32": true, "uint64": true, "sint32": true, "sint64": true,
+	"fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true,
+}
+
+// SyntaxImpact is an element of a file whose semantics change by migrating
+// the file to another syntax, or which the other syntax does not support.
+type SyntaxImpact struct {
+	// Subject names the element, e.g. `"Invoice" field: "total"`.
+	Subject string
+	// Kind is one of the Impact constants.
+	Kind string
+	// Consequence describes the change, and how to avoid it if possible.
+	Consequence string
+}
+
+func (i SyntaxImpact) String() string {
+	return fmt.Sprintf("%s: %s %s", i.Kind, i.Subject, i.Consequence)
+}
+
+// MigrateSyntaxCheck analyses the locked definitions of a file (its path
+// relative to the proto root) for a migration to the target syntax, proto3 or
+// edition 2023, and returns each element affected by it: the repeated scalar
+// fields which become packed, the singular fields which lose or gain explicit
+// presence, the closed enums which become open, and the default values,
+// required fields and extensions which the target does not support.
+func MigrateSyntaxCheck(cfg Config, file, target string) ([]SyntaxImpact, error) {
+	if target != MigrateToProto3 && target != MigrateTo2023 {
+		return nil, ErrUnknownSyntaxTarget
+	}
+
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.ToSlash(filepath.Clean(file))
+	for _, def := range lock.Definitions {
+		if slashPath(def.Filepath) != path {
+			continue
+		}
+		switch {
+		case def.Def.Syntax == "":
+			return nil, fmt.Errorf("the syntax of %q is not recorded in proto.lock, run 'protolock commit'", file)
+		case def.Def.Syntax == target,
+			def.Def.Syntax == SyntaxEditions && target == MigrateTo2023:
+			return nil, fmt.Errorf("%q already uses %s", file, describeSyntax(def.Def))
+		}
+		return migrationImpacts(def, getTypeIndex(lock), target), nil
+	}
+
+	return nil, fmt.Errorf("no locked file matches %q", file)
+}
+
+func describeSyntax(e Entry) string {
+	if e.Syntax == SyntaxEditions {
+		return "edition " + e.Edition
+	}
+	return e.Syntax
+}
+
+// syntaxSemantics are the semantics of a file which differ between the
+// syntaxes, either as locked or as migrated.
+type syntaxSemantics struct {
+	syntax string
+	file   []Option
+}
+
+// packed reports whether a repeated packable field is encoded packed, within
+// the enclosing messages (innermost first).
+func (s syntaxSemantics) packed(f Field, enclosing [][]Option) bool {
+	switch s.syntax {
+	case SyntaxProto2:
+		return optionValue(f.Options, "packed") == "true"
+	case SyntaxProto3:
+		return optionValue(f.Options, "packed") != "false"
+	}
+	return s.feature(featureFieldEncoding, f.Options, enclosing) != "EXPANDED"
+}
+
+// presence returns the presence of a singular field which is neither a
+// message nor in a oneof: explicit, implicit or legacy required.
+func (s syntaxSemantics) presence(f Field, enclosing [][]Option) string {
+	switch {
+	case s.syntax == SyntaxProto2 && f.IsRequired:
+		return presenceLegacyRequired
+	case s.syntax == SyntaxProto2:
+		return presenceExplicit
+	case s.syntax == SyntaxProto3 && f.IsOptional:
+		return presenceExplicit
+	case s.syntax == SyntaxProto3:
+		return presenceImplicit
+	}
+	if p := s.feature(featureFieldPresence, f.Options, enclosing); p != "" {
+		return p
+	}
+	return presenceExplicit
+}
+
+// closed reports whether an enum is closed, i.e. whether unknown values are
+// kept in the unknown fields rather than in the field, within the enclosing
+// messages (innermost first).
+func (s syntaxSemantics) closed(e Enum, enclosing [][]Option) bool {
+	switch s.syntax {
+	case SyntaxProto2:
+		return true
+	case SyntaxProto3:
+		return false
+	}
+	return s.feature(featureEnumType, e.Options, enclosing) == "CLOSED"
+}
+
+// feature returns the value of a feature of editions, as set on the element
+// options, or else on the enclosing messages, or else on the file.
+func (s syntaxSemantics) feature(name string, options []Option, enclosing [][]Option) string {
+	scopes := append([][]Option{options}, enclosing...)
+	for _, scope := range append(scopes, s.file) {
+		if v := optionValue(scope, name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func optionValue(options []Option, name string) string {
+	for _, o := range options {
+		if o.Name == name {
+			return o.Value
+		}
+	}
+
+	return ""
+}
+
+// migrationImpacts compares the semantics of the locked definitions of a file
+// with those of the same definitions in the target syntax, with the defaults
+// of the target, as they would be if only the declarations of the file which
+// the syntax changes were migrated.
+func migrationImpacts(def Definition, index typeIndex, target string) []SyntaxImpact {
+	entry := def.Def
+	pkg := entry.Package.Name
+	from := syntaxSemantics{syntax: entry.Syntax, file: entry.Options}
+	to := syntaxSemantics{syntax: SyntaxEditions}
+	// the features keeping the semantics of the locked definitions, in the
+	// target syntax
+	keepExpanded := "features.repeated_field_encoding = EXPANDED"
+	keepImplicit := "features.field_presence = IMPLICIT"
+	if target == MigrateToProto3 {
+		to.syntax = SyntaxProto3
+		keepExpanded = "declared [packed = false]"
+	}
+
+	var impacts []SyntaxImpact
+	impact := func(subject, kind, format string, args ...interface{}) {
+		impacts = append(impacts, SyntaxImpact{
+			Subject:     subject,
+			Kind:        kind,
+			Consequence: fmt.Sprintf(format, args...),
+		})
+	}
+
+	var walk func(scope, name string, msg Message, enclosing [][]Option)
+	walk = func(scope, name string, msg Message, enclosing [][]Option) {
+		subject := fmt.Sprintf(`"%s"`, name)
+		if msg.IsExtend || strings.Contains(msg.Name, nestedPrefix) {
+			extended := trimDot(msg.Name)
+			if target == MigrateToProto3 && !(strings.HasPrefix(extended, "google.protobuf.") && strings.HasSuffix(extended, "Options")) {
+				impact(subject, ImpactExtension,
+					"is extended, but proto3 only allows extending the google.protobuf options",
+				)
+			}
+			return
+		}
+		if len(msg.Extensions) > 0 && target == MigrateToProto3 {
+			impact(subject, ImpactExtension,
+				"declares extensions %s, which proto3 does not support", formatExtensionRanges(msg.Extensions),
+			)
+		}
+
+		fieldScope := qualify(scope, msg.Name)
+		enclosing = append([][]Option{msg.Options}, enclosing...)
+		for _, f := range msg.Fields {
+			subject := fieldSubject(name, f.Name)
+			typ, isEnum := f.Type, false
+			if !isScalarType(typ) {
+				resolved, ok := index.resolve(fieldScope, typ)
+				if !ok {
+					// neither a scalar nor a locked type: its kind is unknown
+					continue
+				}
+				isEnum = index[resolved].Kind == kindEnum
+			}
+
+			if f.IsRepeated {
+				if (packableTypes[typ] || isEnum) && !from.packed(f, enclosing) && to.packed(f, nil) {
+					impact(subject, ImpactPacked,
+						"is encoded expanded, and becomes packed unless %s: parsers accept both encodings, but the encoded bytes differ",
+						keepExpanded,
+					)
+				}
+				continue
+			}
+
+			if def := optionValue(f.Options, "default"); def != "" && target == MigrateToProto3 {
+				impact(subject, ImpactDefault,
+					"has default %s, which proto3 does not support: the field defaults to the zero value of its type",
+					def,
+				)
+			}
+
+			// messages and the fields of oneofs always have explicit presence
+			if (!isScalarType(typ) && !isEnum) || f.OneOf != "" {
+				continue
+			}
+			before, after := from.presence(f, enclosing), to.presence(f, nil)
+			switch {
+			case before == presenceLegacyRequired && target == MigrateToProto3:
+				impact(subject, ImpactRequired,
+					"is required, which proto3 does not support: messages missing the field are no longer rejected by parsers",
+				)
+			case before == presenceLegacyRequired:
+				impact(subject, ImpactRequired,
+					"is required, which editions declare with features.field_presence = LEGACY_REQUIRED rather than a label",
+				)
+			case before == presenceExplicit && after == presenceImplicit:
+				impact(subject, ImpactPresence,
+					"has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated",
+				)
+			case before == presenceImplicit && after == presenceExplicit:
+				impact(subject, ImpactPresence,
+					"has implicit presence, and gains explicit presence unless %s: its zero value is serialized once set, and has-methods are generated",
+					keepImplicit,
+				)
+			}
+		}
+
+		for _, m := range msg.Messages {
+			walk(fieldScope, name+nestedPrefix+m.Name, m, enclosing)
+		}
+	}
+	for _, msg := range entry.Messages {
+		walk(pkg, msg.Name, msg, nil)
+	}
+
+	for _, enum := range entry.Enums {
+		subject := fmt.Sprintf(`"%s"`, enum.Name)
+		// a nested enum is named after its enclosing messages
+		var enclosing [][]Option
+		name := qualify(pkg, enum.Name)
+		for i := strings.LastIndex(name, nestedPrefix); i > len(pkg); i = strings.LastIndex(name, nestedPrefix) {
+			name = name[:i]
+			enclosing = append(enclosing, index[name].Message.Options)
+		}
+		if from.closed(enum, enclosing) && !to.closed(enum, nil) {
+			keep := ""
+			if target == MigrateTo2023 {
+				keep = " unless features.enum_type = CLOSED"
+			}
+			impact(subject, ImpactEnum,
+				"is closed, and becomes open%s: unknown values are kept in the field, rather than in the unknown fields",
+				keep,
+			)
+		}
+		if target == MigrateToProto3 && len(enum.EnumFields) > 0 && enum.EnumFields[0].Integer != 0 {
+			first := enum.EnumFields[0]
+			impact(subject, ImpactEnum,
+				"starts with %s = %d, but the first value of a proto3 enum must be zero",
+				first.Name, first.Integer,
+			)
+		}
+	}
+
+	return impacts
+}
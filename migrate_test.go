@@ -0,0 +1,185 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const migrateProto2 = `syntax = "proto2";
+package billing.v1;
+
+import "google/protobuf/descriptor.proto";
+
+message Invoice {
+  enum Status {
+    PAID = 1;
+    DUE = 2;
+  }
+  required string id = 1;
+  optional int64 total = 2 [default = 100];
+  repeated int32 amounts = 3;
+  repeated int32 packed_amounts = 4 [packed = true];
+  repeated string notes = 5;
+  optional Status status = 6;
+  optional Line line = 7;
+  oneof payer {
+    string customer = 8;
+  }
+  repeated Status history = 9;
+
+  extensions 100 to 199, 1000 to max;
+}
+
+message Line {
+  optional string sku = 1;
+}
+
+extend Invoice {
+  optional string memo = 100;
+}
+
+extend google.protobuf.FieldOptions {
+  optional bool sensitive = 50000;
+}
+`
+
+const migrateProto3 = `syntax = "proto3";
+package billing.v2;
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+
+message Invoice {
+  string id = 1;
+  optional int64 total = 2;
+  repeated int32 amounts = 3;
+  Kind kind = 4;
+  Invoice parent = 5;
+}
+`
+
+const migrateEditions = `edition = "2023";
+package billing.v3;
+
+option features.field_presence = IMPLICIT;
+
+enum Status {
+  option features.enum_type = CLOSED;
+  STATUS_PAID = 1;
+}
+
+message Invoice {
+  string id = 1;
+  int64 total = 2 [features.field_presence = EXPLICIT];
+  int64 due = 3 [default = 7, features.field_presence = EXPLICIT];
+  string payer = 4 [features.field_presence = LEGACY_REQUIRED];
+  repeated int32 amounts = 5 [features.repeated_field_encoding = EXPANDED];
+  repeated int32 packed_amounts = 6;
+}
+`
+
+func migrateTestConfig(t *testing.T) (*Config, func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	require.NoError(t, err)
+	writeTestFile(t, filepath.Join(dir, "billing", "v1", "invoice.proto"), migrateProto2)
+	writeTestFile(t, filepath.Join(dir, "billing", "v2", "invoice.proto"), migrateProto3)
+	writeTestFile(t, filepath.Join(dir, "billing", "v3", "invoice.proto"), migrateEditions)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := Init(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	return cfg, func() { os.RemoveAll(dir) }
+}
+
+func impactStrings(impacts []SyntaxImpact) []string {
+	var s []string
+	for _, i := range impacts {
+		s = append(s, i.String())
+	}
+	return s
+}
+
+func TestMigrateSyntaxCheckProto2(t *testing.T) {
+	cfg, cleanup := migrateTestConfig(t)
+	defer cleanup()
+
+	lock := readTestLock(t, *cfg)
+	invoice := lock.Definitions[0].Def.Messages[0]
+	assert.True(t, invoice.Fields[0].IsRequired)
+	assert.False(t, invoice.Fields[1].IsOptional)
+	assert.Equal(t, []ExtensionRange{{100, 199}, {1000, maxFieldID}}, invoice.Extensions)
+	assert.True(t, lock.Definitions[0].Def.Messages[2].IsExtend)
+
+	impacts, err := MigrateSyntaxCheck(*cfg, "billing/v1/invoice.proto", MigrateToProto3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`extension: "Invoice" declares extensions 100 to 199, 1000 to max, which proto3 does not support`,
+		`required: "Invoice" field: "id" is required, which proto3 does not support: messages missing the field are no longer rejected by parsers`,
+		`default: "Invoice" field: "total" has default 100, which proto3 does not support: the field defaults to the zero value of its type`,
+		`presence: "Invoice" field: "total" has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated`,
+		`packed: "Invoice" field: "amounts" is encoded expanded, and becomes packed unless declared [packed = false]: parsers accept both encodings, but the encoded bytes differ`,
+		`presence: "Invoice" field: "status" has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated`,
+		`packed: "Invoice" field: "history" is encoded expanded, and becomes packed unless declared [packed = false]: parsers accept both encodings, but the encoded bytes differ`,
+		`presence: "Line" field: "sku" has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated`,
+		`extension: "Invoice" is extended, but proto3 only allows extending the google.protobuf options`,
+		`enum: "Invoice.Status" is closed, and becomes open: unknown values are kept in the field, rather than in the unknown fields`,
+		`enum: "Invoice.Status" starts with PAID = 1, but the first value of a proto3 enum must be zero`,
+	}, impactStrings(impacts))
+
+	impacts, err = MigrateSyntaxCheck(*cfg, "billing/v1/invoice.proto", MigrateTo2023)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`required: "Invoice" field: "id" is required, which editions declare with features.field_presence = LEGACY_REQUIRED rather than a label`,
+		`packed: "Invoice" field: "amounts" is encoded expanded, and becomes packed unless features.repeated_field_encoding = EXPANDED: parsers accept both encodings, but the encoded bytes differ`,
+		`packed: "Invoice" field: "history" is encoded expanded, and becomes packed unless features.repeated_field_encoding = EXPANDED: parsers accept both encodings, but the encoded bytes differ`,
+		`enum: "Invoice.Status" is closed, and becomes open unless features.enum_type = CLOSED: unknown values are kept in the field, rather than in the unknown fields`,
+	}, impactStrings(impacts))
+}
+
+func TestMigrateSyntaxCheckProto3(t *testing.T) {
+	cfg, cleanup := migrateTestConfig(t)
+	defer cleanup()
+
+	impacts, err := MigrateSyntaxCheck(*cfg, "billing/v2/invoice.proto", MigrateTo2023)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`presence: "Invoice" field: "id" has implicit presence, and gains explicit presence unless features.field_presence = IMPLICIT: its zero value is serialized once set, and has-methods are generated`,
+		`presence: "Invoice" field: "kind" has implicit presence, and gains explicit presence unless features.field_presence = IMPLICIT: its zero value is serialized once set, and has-methods are generated`,
+	}, impactStrings(impacts))
+
+	_, err = MigrateSyntaxCheck(*cfg, "billing/v2/invoice.proto", MigrateToProto3)
+	assert.EqualError(t, err, `"billing/v2/invoice.proto" already uses proto3`)
+	_, err = MigrateSyntaxCheck(*cfg, "billing/v2/invoice.proto", "2024")
+	assert.Equal(t, ErrUnknownSyntaxTarget, err)
+	_, err = MigrateSyntaxCheck(*cfg, "billing/v4/invoice.proto", MigrateToProto3)
+	assert.EqualError(t, err, `no locked file matches "billing/v4/invoice.proto"`)
+}
+
+func TestMigrateSyntaxCheckEditions(t *testing.T) {
+	cfg, cleanup := migrateTestConfig(t)
+	defer cleanup()
+
+	impacts, err := MigrateSyntaxCheck(*cfg, "billing/v3/invoice.proto", MigrateToProto3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`presence: "Invoice" field: "total" has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated`,
+		`default: "Invoice" field: "due" has default 7, which proto3 does not support: the field defaults to the zero value of its type`,
+		`presence: "Invoice" field: "due" has explicit presence, and loses it unless declared optional: its zero value is no longer serialized, and has-methods are no longer generated`,
+		`required: "Invoice" field: "payer" is required, which proto3 does not support: messages missing the field are no longer rejected by parsers`,
+		`packed: "Invoice" field: "amounts" is encoded expanded, and becomes packed unless declared [packed = false]: parsers accept both encodings, but the encoded bytes differ`,
+		`enum: "Status" is closed, and becomes open: unknown values are kept in the field, rather than in the unknown fields`,
+		`enum: "Status" starts with STATUS_PAID = 1, but the first value of a proto3 enum must be zero`,
+	}, impactStrings(impacts))
+
+	_, err = MigrateSyntaxCheck(*cfg, "billing/v3/invoice.proto", MigrateTo2023)
+	assert.EqualError(t, err, `"billing/v3/invoice.proto" already uses edition 2023`)
+}
@@ -0,0 +1,255 @@
+package protolock
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The compatibility modes which the Settings may assign to packages and
+// definitions, named after the compatibility levels of the schema registry.
+const (
+	// ModeNone disables the compatibility rules.
+	ModeNone = "NONE"
+
+	// ModeBackward checks that the updated definitions are a safe update of
+	// the locked ones. It is the mode of the definitions without any.
+	ModeBackward = "BACKWARD"
+
+	// ModeBackwardTransitive is ModeBackward, which also keeps the reserved
+	// fields of every earlier version, even when strict mode is disabled.
+	ModeBackwardTransitive = "BACKWARD_TRANSITIVE"
+
+	// ModeForward checks that the locked definitions are a safe update of
+	// the updated ones, i.e. the comparison in reverse.
+	ModeForward = "FORWARD"
+
+	// ModeForwardTransitive is ModeForward, which also keeps the reserved
+	// fields of every earlier version, even when strict mode is disabled.
+	ModeForwardTransitive = "FORWARD_TRANSITIVE"
+
+	// ModeFull checks both ModeBackward and ModeForward.
+	ModeFull = "FULL"
+
+	// ModeFullTransitive is ModeFull, which also keeps the reserved fields of
+	// every earlier version, even when strict mode is disabled.
+	ModeFullTransitive = "FULL_TRANSITIVE"
+
+	// ModeWire is ModeBackward, limited to the rules protecting the binary
+	// wire format, e.g. for messages which are only stored.
+	ModeWire = "WIRE"
+)
+
+var modes = []string{
+	ModeNone, ModeBackward, ModeBackwardTransitive, ModeForward,
+	ModeForwardTransitive, ModeFull, ModeFullTransitive, ModeWire,
+}
+
+// compatibilityRules are the rules which the compatibility modes apply,
+// mapped to whether they protect the binary wire format. The other rules
+// check the updated definitions, whatever their mode.
+var compatibilityRules = map[string]bool{
+	"NoUsingReservedFields":          true,
+	"NoRemovingReservedFields":       true,
+	"NoRemovingFieldsWithoutReserve": true,
+	"NoChangingFieldIDs":             true,
+	"NoChangingFieldTypes":           true,
+	"NoChangingImplicitEnumDefaults": true,
+	"NoChangingFieldNames":           false,
+	"NoRemovingRPCs":                 false,
+	"NoChangingRPCSignature":         false,
+	"NoRenamingOrMovingDefinitions":  false,
+}
+
+// reversibleRules are the compatibility rules which report a different change
+// when comparing in reverse. The others report a change to a definition
+// present on both sides, which breaks compatibility in both directions.
+var reversibleRules = map[string]bool{
+	"NoUsingReservedFields":          true,
+	"NoRemovingReservedFields":       true,
+	"NoRemovingFieldsWithoutReserve": true,
+	"NoRemovingRPCs":                 true,
+}
+
+// forwardSuffix marks the warnings found by comparing in reverse.
+const forwardSuffix = " (in reverse, for forward compatibility)"
+
+// validMode reports whether mode is one of the compatibility modes.
+func validMode(mode string) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a ModeAssignment) backward() bool {
+	switch a.Mode {
+	case ModeBackward, ModeBackwardTransitive, ModeFull, ModeFullTransitive, ModeWire:
+		return true
+	}
+	return false
+}
+
+func (a ModeAssignment) forward() bool {
+	switch a.Mode {
+	case ModeForward, ModeForwardTransitive, ModeFull, ModeFullTransitive:
+		return true
+	}
+	return false
+}
+
+func (a ModeAssignment) transitive() bool {
+	return strings.HasSuffix(a.Mode, "_TRANSITIVE")
+}
+
+// matches reports whether the assignment applies to the definition name (if
+// any, e.g. "acme.v1.Envelope") in package pkg.
+func (a ModeAssignment) matches(pkg, name string) bool {
+	if a.Package == "" && a.Type == "" {
+		return false
+	}
+	if a.Package != "" && !matchPattern(a.Package, pkg) {
+		return false
+	}
+	if a.Type != "" && (name == "" || !matchPattern(a.Type, name)) {
+		return false
+	}
+
+	return true
+}
+
+// reports reports whether a warning, found by comparing in reverse or not,
+// breaks the guarantees of the assignment.
+func (a ModeAssignment) reports(w Warning, reversed bool) bool {
+	if len(a.Rules) > 0 {
+		listed := false
+		for _, r := range a.Rules {
+			listed = listed || r == w.RuleName
+		}
+		if !listed {
+			return false
+		}
+	}
+
+	wire, ok := compatibilityRules[w.RuleName]
+	if !ok {
+		return !reversed
+	}
+	if w.RuleName == "NoRemovingReservedFields" && !strict && !a.transitive() {
+		return false
+	}
+
+	switch {
+	case a.Mode == ModeNone:
+		return false
+	case a.Mode == ModeWire:
+		return !reversed && wire && w.Category != CategorySource
+	case reversed:
+		return a.forward()
+	}
+
+	return a.backward() || (a.forward() && !reversibleRules[w.RuleName])
+}
+
+// assignedMode returns the first assignment of the Settings matching the
+// definition name in package pkg, or ModeBackward.
+func assignedMode(pkg, name string) ModeAssignment {
+	for _, a := range settings.Modes {
+		if a.matches(pkg, name) {
+			return a
+		}
+	}
+
+	return ModeAssignment{Mode: ModeBackward}
+}
+
+// compareModes compares the current vs. updated Protolock definitions under
+// the compatibility mode assigned to each of them: the backward comparison is
+// complemented by one in reverse for the forward modes, and each warning is
+// only kept if it breaks the guarantees of the mode of its definition.
+func compareModes(current, update Protolock, rules []Rule) []Warning {
+	transitive, forward := false, false
+	for _, a := range settings.Modes {
+		transitive = transitive || a.transitive()
+		forward = forward || a.forward()
+	}
+
+	var backwardRules, forwardRules []Rule
+	for _, rule := range rules {
+		if rule.Name == "NoRemovingReservedFields" && transitive && !strict {
+			rule.Func = noRemovingReservedFields
+		}
+		backwardRules = append(backwardRules, rule)
+		if reversibleRules[rule.Name] {
+			forwardRules = append(forwardRules, rule)
+		}
+	}
+
+	packages := filePackages(current, update)
+	modeOf := func(w Warning) ModeAssignment {
+		return assignedMode(warningEntity(packages, w))
+	}
+
+	var warnings []Warning
+	for _, w := range runRules(current, update, backwardRules) {
+		if a := modeOf(w); a.reports(w, false) {
+			w.Mode = a.Mode
+			warnings = append(warnings, w)
+		}
+	}
+	if !forward {
+		return warnings
+	}
+	for _, w := range runRules(update, current, forwardRules) {
+		if a := modeOf(w); a.reports(w, true) {
+			w.Mode = a.Mode
+			w.Message += forwardSuffix
+			warnings = append(warnings, w)
+		}
+	}
+
+	return warnings
+}
+
+// filePackages maps the files of the Protolocks, as located by warnings, to
+// their packages.
+func filePackages(locks ...Protolock) map[Protopath]string {
+	packages := make(map[Protopath]string)
+	for _, lock := range locks {
+		for _, def := range lock.Definitions {
+			packages[OSPath(def.Filepath)] = def.Def.Package.Name
+		}
+	}
+
+	return packages
+}
+
+// warningEntity returns the package of the file of a warning, and the
+// fully-qualified name of the definition it is about, if any.
+func warningEntity(packages map[Protopath]string, w Warning) (string, string) {
+	pkg := packages[w.Filepath]
+	if w.Entity == "" {
+		return pkg, ""
+	}
+
+	return pkg, qualify(pkg, w.Entity)
+}
+
+// validateModes checks that the Settings only assign known modes, and that
+// each assignment matches something.
+func validateModes(s Settings) error {
+	for i, a := range s.Modes {
+		if !validMode(a.Mode) {
+			return fmt.Errorf("modes[%d]: unknown mode %q, use one of %s",
+				i, a.Mode, strings.Join(modes, ", "),
+			)
+		}
+		if a.Package == "" && a.Type == "" {
+			return fmt.Errorf("modes[%d]: a package or type pattern is required", i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package protolock
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const modesProto = `syntax = "proto3";
+package billing.v1;
+
+message Request {
+  string id = 1;
+}
+
+message Event {
+  reserved 5;
+  string id = 1;
+}
+
+message Stored {
+  string id = 1;
+  int64 amount = 2;
+}
+
+message Legacy {
+  string id = 1;
+}
+`
+
+const modesUpdatedProto = `syntax = "proto3";
+package billing.v1;
+
+message Request {
+  string key = 1;
+}
+
+message Event {
+  string id = 1;
+  string source = 2;
+}
+
+message Stored {
+  string key = 1;
+  string amount = 2;
+}
+
+message Legacy {
+  int32 id = 1;
+}
+`
+
+func TestCompareModes(t *testing.T) {
+	SetStrict(false)
+	defer SetStrict(true)
+	defer SetSettings(Settings{})
+	SetSettings(Settings{Modes: []ModeAssignment{
+		{Type: "billing.v1.Event", Mode: ModeFullTransitive},
+		{Type: "billing.v1.Stored", Mode: ModeWire},
+		{Type: "billing.v1.Legacy", Mode: ModeNone},
+	}})
+
+	cur := parseTestLock(t, modesProto)
+	upd := parseTestLock(t, modesUpdatedProto)
+	report, err := Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+
+	var messages []string
+	for _, w := range report.Warnings {
+		messages = append(messages, w.Mode+": "+w.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		// the reserved fields are kept, although strict mode is disabled
+		`FULL_TRANSITIVE: "Event" is missing ID: 5, which had been reserved`,
+		`BACKWARD: "Request" field: "id" has been removed, but is not reserved`,
+		// the names are not part of the wire format
+		`WIRE: "Stored" field: "amount" has a different type: string, previously int64`,
+		`FULL_TRANSITIVE: "Event" field: "source" has been removed, but is not reserved` + forwardSuffix,
+		`FULL_TRANSITIVE: "Event" ID: "2" has been removed, but is not reserved` + forwardSuffix,
+	}, messages)
+	// the modes are assigned by the definition each warning is about
+	for _, w := range report.Warnings {
+		assert.True(t, strings.HasPrefix(w.Message, `"`+w.Entity+`" `), w.Message)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteReport(report, FormatText, &buf))
+	assert.Contains(t, buf.String(), `CONFLICT (WIRE): "Stored" field: "amount"`)
+
+	// the rules of an assignment restrict the warnings of its definitions
+	SetSettings(Settings{Modes: []ModeAssignment{
+		{Package: "billing.*", Mode: ModeBackward, Rules: []string{"NoChangingFieldTypes"}},
+	}})
+	report, err = Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+	messages = nil
+	for _, w := range report.Warnings {
+		messages = append(messages, w.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		`"Legacy" field: "id" has a different type: int32, previously string`,
+		`"Stored" field: "amount" has a different type: string, previously int64`,
+	}, messages)
+}
+
+func TestReadSettingsModes(t *testing.T) {
+	s, err := ReadSettings(strings.NewReader(`{"modes": [
+		{"package": "acme.events.*", "mode": "FULL_TRANSITIVE"},
+		{"type": "acme.v1.*Record", "mode": "WIRE", "rules": ["NoChangingFieldIDs"]}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, s.Modes, 2)
+	assert.Equal(t, ModeWire, s.Modes[1].Mode)
+
+	_, err = ReadSettings(strings.NewReader(`{"modes": [{"package": "acme.*", "mode": "SIDEWAYS"}]}`))
+	assert.Error(t, err)
+	_, err = ReadSettings(strings.NewReader(`{"modes": [{"mode": "FULL"}]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+package protolock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// the field numbers reserved for the implementation of protobuf
+	firstImplementationNumber = 19000
+	lastImplementationNumber  = 19999
+)
+
+// NoFieldNumbersOutsideRanges verifies that the fields and enum values which
+// are new in the updated Protolock use numbers within the ranges assigned to
+// the team authoring the changes (see NumberSettings), or, if the team is not
+// known, within the range of any team. Messages and enums without ranges are
+// not checked.
+func NoFieldNumbersOutsideRanges(cur, upd Protolock) ([]Warning, bool) {
+	if len(settings.Numbers.Ranges) == 0 {
+		return nil, true
+	}
+
+	var warnings []Warning
+	curIndex := getTypeIndex(cur)
+	updIndex := getTypeIndex(upd)
+	team := settings.Numbers.Team
+
+	var names []string
+	for name := range updIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ranges := settings.Numbers.rangesOf(name)
+		if len(ranges) == 0 {
+			continue
+		}
+		allowed := ranges
+		if team != "" {
+			allowed = filterRanges(ranges, team)
+		}
+
+		updated := updIndex[name]
+		locked := make(map[int]bool)
+		if current, ok := curIndex[name]; ok && current.Kind == updated.Kind {
+			for _, n := range typeNumbers(current) {
+				locked[n.number] = true
+			}
+		}
+
+		relName := strings.TrimPrefix(name, updated.Package+nestedPrefix)
+		if updated.Package == "" {
+			relName = name
+		}
+		for _, n := range typeNumbers(updated) {
+			if locked[n.number] || inRanges(allowed, n.number) {
+				continue
+			}
+			var msg string
+			switch {
+			case team == "":
+				msg = fmt.Sprintf(`%s uses number %d, outside the ranges of every team (%s)`,
+					fieldSubject(relName, n.name), n.number, describeRanges(allowed),
+				)
+			case len(allowed) == 0:
+				msg = fmt.Sprintf(`%s uses number %d, but team "%s" is assigned no numbers of "%s"`,
+					fieldSubject(relName, n.name), n.number, team, name,
+				)
+			default:
+				msg = fmt.Sprintf(`%s uses number %d, outside the ranges of team "%s" (%s)`,
+					fieldSubject(relName, n.name), n.number, team, describeRanges(allowed),
+				)
+			}
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(updated.Filepath),
+				Message:  msg,
+				Entity:   relName,
+			})
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// NextID suggests the number of the next field of a message, or value of an
+// enum, given by its fully-qualified name. The numbers used or reserved in
+// either the proto files in the tree or the proto.lock file are not reused.
+// If the numbers of the type are assigned to teams, the lowest free number in
+// the ranges of the team of the Settings is suggested; otherwise the number
+// following the highest number in use.
+func NextID(cfg Config, name string) (int, error) {
+	updated, err := getUpdatedLock(cfg)
+	if err != nil {
+		return 0, err
+	}
+	current := Protolock{}
+	if cfg.LockFileExists() {
+		lockFile, err := openLockFile(cfg)
+		if err != nil {
+			return 0, err
+		}
+		defer lockFile.Close()
+		current, err = FromReader(lockFile)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	name = strings.TrimPrefix(name, nestedPrefix)
+	typ, ok := getTypeIndex(*updated)[name]
+	if !ok {
+		return 0, fmt.Errorf("no message or enum %q found in the proto files", name)
+	}
+
+	used := make(map[int]bool)
+	for _, n := range typeNumbers(typ) {
+		used[n.number] = true
+	}
+	reserved := typ.Message.ReservedIDs
+	if typ.Kind == kindEnum {
+		reserved = typ.Enum.ReservedIDs
+	}
+	for _, id := range reserved {
+		used[id] = true
+	}
+	if locked, ok := getTypeIndex(current)[name]; ok && locked.Kind == typ.Kind {
+		for _, n := range typeNumbers(locked) {
+			used[n.number] = true
+		}
+	}
+
+	max := maxFieldID
+	if typ.Kind == kindEnum {
+		max = maxEnumValue
+	}
+	free := func(n int) bool {
+		if used[n] || n > max {
+			return false
+		}
+		return typ.Kind == kindEnum || n < firstImplementationNumber || n > lastImplementationNumber
+	}
+
+	ranges := settings.Numbers.rangesOf(name)
+	if len(ranges) == 0 {
+		next := 1
+		if typ.Kind == kindEnum {
+			next = 0
+		}
+		for n := range used {
+			if n >= next {
+				next = n + 1
+			}
+		}
+		for ; next <= max; next++ {
+			if free(next) {
+				return next, nil
+			}
+		}
+		return 0, fmt.Errorf("no numbers left in %q", name)
+	}
+
+	team := settings.Numbers.Team
+	if team == "" {
+		return 0, fmt.Errorf("the numbers of %q are assigned to teams %s, the team must be set",
+			name, describeTeams(ranges),
+		)
+	}
+	allowed := filterRanges(ranges, team)
+	if len(allowed) == 0 {
+		return 0, fmt.Errorf(`team "%s" is assigned no numbers of "%s"`, team, name)
+	}
+	for _, r := range allowed {
+		for n := r.From; n <= r.To; n++ {
+			if free(n) {
+				return n, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf(`the numbers of team "%s" in "%s" are used up (%s)`,
+		team, name, describeRanges(allowed),
+	)
+}
+
+// numbered is a field or enum value.
+type numbered struct {
+	name   string
+	number int
+}
+
+// typeNumbers lists the fields (including maps) of a message, or the values
+// of an enum.
+func typeNumbers(typ lockType) []numbered {
+	var numbers []numbered
+	if typ.Kind == kindEnum {
+		for _, v := range typ.Enum.EnumFields {
+			numbers = append(numbers, numbered{v.Name, v.Integer})
+		}
+		return numbers
+	}
+
+	for _, f := range typ.Message.Fields {
+		numbers = append(numbers, numbered{f.Name, f.ID})
+	}
+	for _, mp := range typ.Message.Maps {
+		numbers = append(numbers, numbered{mp.Field.Name, mp.Field.ID})
+	}
+
+	return numbers
+}
+
+// rangesOf returns the ranges of the message or enum with the fully-qualified
+// name, ordered by their first number.
+func (s NumberSettings) rangesOf(name string) []NumberRange {
+	var ranges []NumberRange
+	for _, r := range s.Ranges {
+		if matchPattern(r.Type, name) {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+
+	return ranges
+}
+
+func filterRanges(ranges []NumberRange, team string) []NumberRange {
+	var filtered []NumberRange
+	for _, r := range ranges {
+		if r.Team == team {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+func inRanges(ranges []NumberRange, n int) bool {
+	for _, r := range ranges {
+		if r.From <= n && n <= r.To {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeRanges lists ranges as e.g. "100-199, 300-399".
+func describeRanges(ranges []NumberRange) string {
+	var s []string
+	for _, r := range ranges {
+		s = append(s, fmt.Sprintf("%d-%d", r.From, r.To))
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// describeTeams lists the teams of ranges as e.g. `"core", "payments"`.
+func describeTeams(ranges []NumberRange) string {
+	seen := make(map[string]bool)
+	var teams []string
+	for _, r := range ranges {
+		if !seen[r.Team] {
+			seen[r.Team] = true
+			teams = append(teams, fmt.Sprintf("%q", r.Team))
+		}
+	}
+	sort.Strings(teams)
+
+	return strings.Join(teams, ", ")
+}
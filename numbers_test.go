@@ -0,0 +1,138 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const envelopeProto = `syntax = "proto3";
+package acme.v1;
+
+message Envelope {
+  string id = 1;
+  string payment_id = 100;
+  reserved 101;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+}
+`
+
+const envelopeUpdatedProto = `syntax = "proto3";
+package acme.v1;
+
+message Envelope {
+  string id = 1;
+  string payment_id = 100;
+  reserved 101;
+  string payment_method = 102;
+  string shipment_id = 200;
+  string trace_id = 2;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_PAYMENT = 150;
+}
+`
+
+var envelopeRanges = []NumberRange{
+	{Team: "core", Type: "acme.v1.*", From: 1, To: 99},
+	{Team: "payments", Type: "acme.v1.Envelope", From: 100, To: 199},
+	{Team: "shipping", Type: "acme.v1.Envelope", From: 200, To: 299},
+}
+
+func TestNoFieldNumbersOutsideRanges(t *testing.T) {
+	defer SetSettings(Settings{})
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, ProtoPath("acme/v1/envelope.proto"), envelopeProto),
+	}}
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, ProtoPath("acme/v1/envelope.proto"), envelopeUpdatedProto),
+	}}
+
+	// without ranges, nothing is checked
+	warnings, ok := NoFieldNumbersOutsideRanges(cur, upd)
+	assert.True(t, ok)
+	assert.Nil(t, warnings)
+
+	messages := func(team string) []string {
+		SetSettings(Settings{Numbers: NumberSettings{Ranges: envelopeRanges, Team: team}})
+		warnings, ok := NoFieldNumbersOutsideRanges(cur, upd)
+		var messages []string
+		for _, w := range warnings {
+			assert.Equal(t, OSPath(ProtoPath("acme/v1/envelope.proto")), w.Filepath)
+			messages = append(messages, w.Message)
+		}
+		assert.Equal(t, len(warnings) == 0, ok)
+		return messages
+	}
+
+	assert.Equal(t, []string{
+		`"Envelope" field: "shipment_id" uses number 200, outside the ranges of team "payments" (100-199)`,
+		`"Envelope" field: "trace_id" uses number 2, outside the ranges of team "payments" (100-199)`,
+		`"Kind" field: "KIND_PAYMENT" uses number 150, but team "payments" is assigned no numbers of "acme.v1.Kind"`,
+	}, messages("payments"))
+
+	// the existing fields are not checked, even outside of the team's ranges
+	assert.Equal(t, []string{
+		`"Envelope" field: "payment_method" uses number 102, outside the ranges of team "core" (1-99)`,
+		`"Envelope" field: "shipment_id" uses number 200, outside the ranges of team "core" (1-99)`,
+		`"Kind" field: "KIND_PAYMENT" uses number 150, outside the ranges of team "core" (1-99)`,
+	}, messages("core"))
+
+	// without a team, only numbers outside of every range are reported
+	assert.Equal(t, []string{
+		`"Kind" field: "KIND_PAYMENT" uses number 150, outside the ranges of every team (1-99)`,
+	}, messages(""))
+}
+
+func TestNextID(t *testing.T) {
+	defer SetSettings(Settings{})
+	dir, err := ioutil.TempDir("", "nextid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "acme", "v1", "envelope.proto")
+	writeTestFile(t, path, envelopeUpdatedProto)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := Init(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	// the numbers removed since the lock was committed are not reused
+	writeTestFile(t, path, envelopeProto)
+
+	next := func(team, name string) (int, error) {
+		SetSettings(Settings{Numbers: NumberSettings{Ranges: envelopeRanges, Team: team}})
+		return NextID(*cfg, name)
+	}
+	id, err := next("payments", "acme.v1.Envelope")
+	require.NoError(t, err)
+	assert.Equal(t, 103, id)
+	id, err = next("core", ".acme.v1.Envelope")
+	require.NoError(t, err)
+	assert.Equal(t, 3, id)
+	_, err = next("", "acme.v1.Envelope")
+	assert.EqualError(t, err, `the numbers of "acme.v1.Envelope" are assigned to teams "core", "payments", "shipping", the team must be set`)
+	_, err = next("payments", "acme.v1.Kind")
+	assert.EqualError(t, err, `team "payments" is assigned no numbers of "acme.v1.Kind"`)
+	_, err = next("payments", "acme.v1.Missing")
+	assert.EqualError(t, err, `no message or enum "acme.v1.Missing" found in the proto files`)
+
+	// without ranges, the number following the highest number is suggested
+	SetSettings(Settings{})
+	id, err = NextID(*cfg, "acme.v1.Envelope")
+	require.NoError(t, err)
+	assert.Equal(t, 201, id)
+	id, err = NextID(*cfg, "acme.v1.Kind")
+	require.NoError(t, err)
+	assert.Equal(t, 151, id)
+}
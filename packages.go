@@ -0,0 +1,188 @@
+package protolock
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NoInconsistentPackages verifies that all files of a package in the updated
+// Protolock share the same value for each of the consistent file options (see
+// PackageSettings), and that each file's directory matches its package, e.g.
+// a file in package "billing.v1" is located in a "billing/v1" directory.
+// Only the files added or changed since the current Protolock are reported,
+// and only once the Settings configure the rule. Files can be exempted from
+// either check through the Settings.
+func NoInconsistentPackages(cur, upd Protolock) ([]Warning, bool) {
+	if !strict || !settings.Packages.configured() {
+		return nil, true
+	}
+
+	var warnings []Warning
+	packages := getPackageFiles(upd)
+	changed := getChangedFiles(cur, upd)
+
+	var names []string
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	options := settings.Packages.Options
+	if len(options) == 0 {
+		options = DefaultConsistentOptions
+	}
+
+	for _, name := range names {
+		defs := packages[name]
+
+		for _, option := range options {
+			values := make(map[string][]Protopath)
+			for _, def := range defs {
+				if packageExempted(name, def.Filepath, option) {
+					continue
+				}
+				value := fileOption(def.Def, option)
+				values[value] = append(values[value], def.Filepath)
+			}
+			if len(values) < 2 {
+				continue
+			}
+
+			expected := consensusValue(values)
+			var found []string
+			for value := range values {
+				found = append(found, value)
+			}
+			sort.Strings(found)
+			for _, value := range found {
+				if value == expected {
+					continue
+				}
+				for _, p := range values[value] {
+					if !changed[p] {
+						continue
+					}
+					msg := fmt.Sprintf(
+						`"%s" package option "%s" is %s, while it is %s in %s`,
+						name, option, describeValue(value),
+						describeValue(expected), OSPath(values[expected][0]),
+					)
+					warnings = append(warnings, Warning{
+						Filepath: OSPath(p),
+						Message:  msg,
+						Category: CategorySource,
+					})
+				}
+			}
+		}
+
+		dir := strings.Replace(name, ".", "/", -1)
+		for _, def := range defs {
+			if !changed[def.Filepath] || packageExempted(name, def.Filepath, CheckDirectory) {
+				continue
+			}
+			fileDir := path.Dir(slashPath(def.Filepath))
+			if fileDir == dir || strings.HasSuffix(fileDir, "/"+dir) {
+				continue
+			}
+			msg := fmt.Sprintf(
+				`"%s" package file is in directory "%s", expected a directory ending in "%s"`,
+				name, fileDir, dir,
+			)
+			warnings = append(warnings, Warning{
+				Filepath: OSPath(def.Filepath),
+				Message:  msg,
+				Category: CategorySource,
+			})
+		}
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// getPackageFiles groups the Definitions of a Protolock by package, ignoring
+// files without a package.
+func getPackageFiles(lock Protolock) map[string][]Definition {
+	packages := make(map[string][]Definition)
+	for _, def := range lock.Definitions {
+		if def.Def.Package.Name == "" {
+			continue
+		}
+		packages[def.Def.Package.Name] = append(
+			packages[def.Def.Package.Name], def,
+		)
+	}
+
+	return packages
+}
+
+// getChangedFiles returns the files of the updated Protolock which are not in
+// the current one, or whose definitions differ from it.
+func getChangedFiles(cur, upd Protolock) map[Protopath]bool {
+	current := make(map[Protopath]Entry)
+	for _, def := range cur.Definitions {
+		current[def.Filepath] = def.Def
+	}
+
+	changed := make(map[Protopath]bool)
+	for _, def := range upd.Definitions {
+		if entry, ok := current[def.Filepath]; !ok || !equalEntries(entry, def.Def) {
+			changed[def.Filepath] = true
+		}
+	}
+
+	return changed
+}
+
+// fileOption returns the value of a file option, or an empty string if the
+// option is not set.
+func fileOption(entry Entry, name string) string {
+	for _, o := range entry.Options {
+		if o.Name == name {
+			return o.Value
+		}
+	}
+
+	return ""
+}
+
+// consensusValue returns the value shared by most files, preferring the value
+// of the first file (by path) in case of a tie.
+func consensusValue(values map[string][]Protopath) string {
+	var expected string
+	var first Protopath
+	for value, paths := range values {
+		sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
+		switch {
+		case expected == "" && first == "",
+			len(paths) > len(values[expected]),
+			len(paths) == len(values[expected]) && paths[0] < first:
+			expected, first = value, paths[0]
+		}
+	}
+
+	return expected
+}
+
+func describeValue(value string) string {
+	if value == "" {
+		return "not set"
+	}
+	return fmt.Sprintf(`"%s"`, value)
+}
+
+func packageExempted(pkg string, file Protopath, check string) bool {
+	for _, e := range settings.Packages.Exemptions {
+		if e.exempts(pkg, file, check) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package protolock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const billingInvoiceProto = `syntax = "proto3";
+package billing.v1;
+
+option go_package = "example.com/billing/v1;billing";
+option java_package = "com.example.billing.v1";
+
+message Invoice {}
+`
+
+const billingPaymentProto = `syntax = "proto3";
+package billing.v1;
+
+option go_package = "example.com/billing/v1;billing";
+option java_package = "com.example.billing.v1";
+
+message Payment {}
+`
+
+const billingRefundProto = `syntax = "proto3";
+package billing.v1;
+
+option go_package = "example.com/billing;billing";
+
+message Refund {}
+`
+
+func packageTestLock(t *testing.T) Protolock {
+	return Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, ProtoPath("proto/billing/v1/invoice.proto"), billingInvoiceProto),
+		parseTestProtoAt(t, ProtoPath("proto/billing/v1/payment.proto"), billingPaymentProto),
+		parseTestProtoAt(t, ProtoPath("proto/billing/refund.proto"), billingRefundProto),
+	}}
+}
+
+func TestNoInconsistentPackages(t *testing.T) {
+	SetStrict(true)
+	defer SetSettings(Settings{})
+	lock := packageTestLock(t)
+
+	// the rule is only enforced once configured
+	warnings, ok := NoInconsistentPackages(Protolock{}, lock)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+
+	SetSettings(Settings{Packages: PackageSettings{Options: DefaultConsistentOptions}})
+	warnings, ok = NoInconsistentPackages(Protolock{}, lock)
+	assert.False(t, ok)
+	require.Len(t, warnings, 3)
+
+	var messages []string
+	for _, w := range warnings {
+		assert.Equal(t, OSPath(ProtoPath("proto/billing/refund.proto")), w.Filepath)
+		messages = append(messages, w.Message)
+	}
+	invoice := OSPath(ProtoPath("proto/billing/v1/invoice.proto"))
+	assert.Equal(t, []string{
+		`"billing.v1" package option "go_package" is "example.com/billing;billing", while it is "example.com/billing/v1;billing" in ` + string(invoice),
+		`"billing.v1" package option "java_package" is not set, while it is "com.example.billing.v1" in ` + string(invoice),
+		`"billing.v1" package file is in directory "proto/billing", expected a directory ending in "billing/v1"`,
+	}, messages)
+
+	// the files which have not changed are not reported
+	warnings, ok = NoInconsistentPackages(lock, lock)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+
+	SetStrict(false)
+	warnings, ok = NoInconsistentPackages(Protolock{}, lock)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+	SetStrict(true)
+}
+
+func TestNoInconsistentPackagesExemptions(t *testing.T) {
+	SetStrict(true)
+	defer SetSettings(Settings{})
+	lock := packageTestLock(t)
+
+	SetSettings(Settings{Packages: PackageSettings{
+		Exemptions: []PackageExemption{
+			{File: "proto/billing/refund.proto", Checks: []string{CheckDirectory, "go_package"}},
+		},
+	}})
+	warnings, ok := NoInconsistentPackages(Protolock{}, lock)
+	assert.False(t, ok)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0].Message, `"java_package"`)
+
+	SetSettings(Settings{Packages: PackageSettings{
+		Options:    []string{"go_package"},
+		Exemptions: []PackageExemption{{Package: "billing.*"}},
+	}})
+	warnings, ok = NoInconsistentPackages(Protolock{}, lock)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+}
+
+func TestMatchPattern(t *testing.T) {
+	assert.True(t, matchPattern("billing.v1", "billing.v1"))
+	assert.True(t, matchPattern("internal.*", "internal"))
+	assert.True(t, matchPattern("internal.*", "internal.billing.v1"))
+	assert.False(t, matchPattern("internal.*", "internals.billing"))
+	assert.True(t, matchPattern("third_party/*", "third_party/google/api/http.proto"))
+	assert.True(t, matchPattern("*/legacy.proto", "billing/legacy.proto"))
+	assert.False(t, matchPattern("billing", "billing.v1"))
+}
+
+func TestReadSettings(t *testing.T) {
+	s, err := ReadSettings(strings.NewReader(`{
+		"packages": {
+			"options": ["go_package"],
+			"exemptions": [{"package": "legacy.*", "checks": ["directory"]}]
+		}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go_package"}, s.Packages.Options)
+	assert.Equal(t, "legacy.*", s.Packages.Exemptions[0].Package)
+
+	s, err = LoadSettings("testdata/does-not-exist.json")
+	assert.NoError(t, err)
+	assert.Equal(t, Settings{}, s)
+}
@@ -18,6 +18,11 @@ const LockFileName = "proto.lock"
 
 type Protolock struct {
 	Definitions []Definition `json:"definitions,omitempty"`
+	// Shard is set on a partial Protolock, which only contains the
+	// definitions of the files in the shard.
+	Shard *Shard `json:"shard,omitempty"`
+	// Untracked is the audit trail of the files removed by Untrack.
+	Untracked []Untracked `json:"untracked,omitempty"`
 }
 
 type Definition struct {
@@ -32,6 +37,11 @@ type Entry struct {
 	Imports  []Import  `json:"imports,omitempty"`
 	Package  Package   `json:"package,omitempty"`
 	Options  []Option  `json:"options,omitempty"`
+	// Syntax is the syntax of the file, e.g. "proto3". It is empty in
+	// proto.lock files written before the syntax was recorded.
+	Syntax string `json:"syntax,omitempty"`
+	// Edition is the edition of a file of SyntaxEditions, e.g. "2024".
+	Edition string `json:"edition,omitempty"`
 }
 
 type Import struct {
@@ -57,12 +67,34 @@ type Message struct {
 	Filepath      Protopath `json:"filepath,omitempty"`
 	Messages      []Message `json:"messages,omitempty"`
 	Options       []Option  `json:"options,omitempty"`
+	RenamedFrom   string    `json:"renamed_from,omitempty"`
+	MovedFrom     Protopath `json:"moved_from,omitempty"`
+	// Fingerprint identifies the wire format of the message, see
+	// Fingerprints.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Visibility is the effective VisibilityExport or VisibilityLocal of the
+	// message, in files of edition 2024 or later.
+	Visibility string `json:"visibility,omitempty"`
+	// Extensions are the field numbers reserved for extensions by the
+	// message, in proto2 files and editions.
+	Extensions []ExtensionRange `json:"extensions,omitempty"`
+	// IsExtend is set on the message recording an extend block, named after
+	// the message it extends.
+	IsExtend bool `json:"is_extend,omitempty"`
+}
+
+// ExtensionRange is a range of field numbers reserved for extensions, where
+// To is inclusive, and is maxFieldID for "max".
+type ExtensionRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
 }
 
 type EnumField struct {
-	Name    string   `json:"name,omitempty"`
-	Integer int      `json:"integer,omitempty"`
-	Options []Option `json:"options,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Integer     int      `json:"integer,omitempty"`
+	Options     []Option `json:"options,omitempty"`
+	RenamedFrom string   `json:"renamed_from,omitempty"`
 }
 
 type Enum struct {
@@ -71,6 +103,11 @@ type Enum struct {
 	ReservedIDs   []int       `json:"reserved_ids,omitempty"`
 	ReservedNames []string    `json:"reserved_names,omitempty"`
 	AllowAlias    bool        `json:"allow_alias,omitempty"`
+	MovedFrom     Protopath   `json:"moved_from,omitempty"`
+	Options       []Option    `json:"options,omitempty"`
+	// Visibility is the effective VisibilityExport or VisibilityLocal of the
+	// enum, in files of edition 2024 or later.
+	Visibility string `json:"visibility,omitempty"`
 }
 
 type Map struct {
@@ -79,17 +116,25 @@ type Map struct {
 }
 
 type Field struct {
-	ID         int      `json:"id,omitempty"`
-	Name       string   `json:"name,omitempty"`
-	Type       string   `json:"type,omitempty"`
-	IsRepeated bool     `json:"is_repeated,omitempty"`
-	Options    []Option `json:"options,omitempty"`
+	ID          int      `json:"id,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	IsRepeated  bool     `json:"is_repeated,omitempty"`
+	Options     []Option `json:"options,omitempty"`
+	RenamedFrom string   `json:"renamed_from,omitempty"`
+	OneOf       string   `json:"oneof,omitempty"`
+	// IsRequired is set on the required fields of proto2 files.
+	IsRequired bool `json:"is_required,omitempty"`
+	// IsOptional is set on the fields of proto3 files declared optional, which
+	// have explicit presence.
+	IsOptional bool `json:"is_optional,omitempty"`
 }
 
 type Service struct {
-	Name     string    `json:"name,omitempty"`
-	RPCs     []RPC     `json:"rpcs,omitempty"`
-	Filepath Protopath `json:"filepath,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	RPCs        []RPC     `json:"rpcs,omitempty"`
+	Filepath    Protopath `json:"filepath,omitempty"`
+	RenamedFrom string    `json:"renamed_from,omitempty"`
 }
 
 type RPC struct {
@@ -99,18 +144,30 @@ type RPC struct {
 	InStreamed  bool     `json:"in_streamed,omitempty"`
 	OutStreamed bool     `json:"out_streamed,omitempty"`
 	Options     []Option `json:"options,omitempty"`
+	RenamedFrom string   `json:"renamed_from,omitempty"`
 }
 
 type Report struct {
 	Current  Protolock `json:"current,omitempty"`
 	Updated  Protolock `json:"updated,omitempty"`
 	Warnings []Warning `json:"warnings,omitempty"`
+	// Risk is the overall risk of the warnings, see ScoreReport.
+	Risk *Risk `json:"risk,omitempty"`
 }
 
 type Warning struct {
 	Filepath Protopath `json:"filepath,omitempty"`
 	Message  string    `json:"message,omitempty"`
-	RuleName string    `json:"rulename,omitempty"`
+	// Entity is the name of the message, enum or service the warning is
+	// about, relative to the package of its file, if any.
+	Entity   string `json:"entity,omitempty"`
+	RuleName string `json:"rulename,omitempty"`
+	Category string `json:"category,omitempty"`
+	// Mode is the compatibility mode the warning was found under, if the
+	// Settings assign any.
+	Mode string `json:"mode,omitempty"`
+	// Risk prioritises the review of the warning, see ScoreReport.
+	Risk *Risk `json:"risk,omitempty"`
 }
 
 type ProtoFile struct {
@@ -118,30 +175,68 @@ type ProtoFile struct {
 	Entry     Entry
 }
 
+const (
+	// SyntaxProto2 is the syntax of a file which declares "proto2", or
+	// does not declare any syntax.
+	SyntaxProto2 = "proto2"
+
+	// SyntaxProto3 is the syntax of a file which declares "proto3".
+	SyntaxProto3 = "proto3"
+
+	// SyntaxEditions is the syntax of a file which declares an edition,
+	// e.g. `edition = "2024";`.
+	SyntaxEditions = "editions"
+)
+
+const (
+	// CategoryWire is the Warning category of a change which breaks the
+	// binary wire format.
+	CategoryWire = "wire"
+
+	// CategorySource is the Warning category of a change which remains
+	// wire-compatible, but breaks code generated from the previous
+	// definitions.
+	CategorySource = "source"
+)
+
 var (
-	enums []Enum
-	msgs  []Message
-	svcs  []Service
-	imps  []Import
-	pkg   Package
-	opts  []Option
+	enums  []Enum
+	msgs   []Message
+	svcs   []Service
+	imps   []Import
+	pkg    Package
+	opts   []Option
+	syntax string
+	// editions is the edition source of the file being parsed.
+	editions editionSource
 
 	ErrWarningsFound = errors.New("comparison found one or more warnings")
 )
 
 func Parse(filename string, r io.Reader) (Entry, error) {
-	parser := proto.NewParser(r)
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	editions = prepareEditions(src)
+
+	parser := proto.NewParser(bytes.NewReader(editions.src))
 	parser.Filename(filename)
 	def, err := parser.Parse()
 	if err != nil {
 		return Entry{}, err
 	}
+	editions.defaults = symbolVisibilityDefault(def)
 
 	enums = []Enum{}
 	msgs = []Message{}
 	svcs = []Service{}
 	imps = []Import{}
 	opts = []Option{}
+	syntax = SyntaxProto2
+	if editions.edition != "" {
+		syntax = SyntaxEditions
+	}
 
 	proto.Walk(
 		def,
@@ -150,6 +245,7 @@ func Parse(filename string, r io.Reader) (Entry, error) {
 		proto.WithMessage(withMessage),
 		protoWithImport(withImport),
 		protoWithPackage(withPackage),
+		protoWithSyntax(withSyntax),
 		proto.WithOption(withOption),
 	)
 
@@ -160,9 +256,25 @@ func Parse(filename string, r io.Reader) (Entry, error) {
 		Imports:  imps,
 		Package:  pkg,
 		Options:  opts,
+		Syntax:   syntax,
+		Edition:  editions.edition,
 	}, nil
 }
 
+// ParseProto parses the syntax tree of a .proto file, accepting the editions
+// syntax as Parse does. The edition keywords and reserved identifiers are
+// blanked out, so the positions of the elements match the source.
+func ParseProto(filename string, r io.Reader) (*proto.Proto, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := proto.NewParser(bytes.NewReader(prepareEditions(src).src))
+	parser.Filename(filename)
+	return parser.Parse()
+}
+
 func withEnum(e *proto.Enum) {
 	errs := checkComments(e)
 	if errs != nil {
@@ -185,15 +297,19 @@ func withEnum(e *proto.Enum) {
 }
 
 func parseEnum(e *proto.Enum) Enum {
+	_, nested := e.Parent.(*proto.Message)
 	enum := Enum{
-		Name: e.Name,
+		Name:       e.Name,
+		MovedFrom:  movedFrom(e.Comment),
+		Visibility: editions.visibility(e.Position, nested),
 	}
 
 	for _, v := range e.Elements {
 		if ef, ok := v.(*proto.EnumField); ok {
 			field := EnumField{
-				Name:    ef.Name,
-				Integer: ef.Integer,
+				Name:        ef.Name,
+				Integer:     ef.Integer,
+				RenamedFrom: hintValue(CommentRenamedFrom, ef.Comment, ef.InlineComment),
 			}
 			for _, ee := range ef.Elements {
 				if o, ok := ee.(*proto.Option); ok {
@@ -206,6 +322,10 @@ func parseEnum(e *proto.Enum) Enum {
 			enum.EnumFields = append(enum.EnumFields, field)
 		}
 
+		if o, ok := v.(*proto.Option); ok {
+			enum.Options = append(enum.Options, parseOption(o))
+		}
+
 		if r, ok := v.(*proto.Reserved); ok {
 			// collect all reserved field IDs from the ranges
 			for _, rng := range r.Ranges {
@@ -222,7 +342,7 @@ func parseEnum(e *proto.Enum) Enum {
 			}
 
 			// add all reserved field names
-			enum.ReservedNames = append(enum.ReservedNames, r.FieldNames...)
+			enum.ReservedNames = append(enum.ReservedNames, editions.reservedNames(r)...)
 		}
 	}
 
@@ -241,7 +361,8 @@ func withService(s *proto.Service) {
 	}
 
 	svc := Service{
-		Name: s.Name,
+		Name:        s.Name,
+		RenamedFrom: hintValue(CommentRenamedFrom, s.Comment),
 	}
 
 	for _, v := range s.Elements {
@@ -253,6 +374,7 @@ func withService(s *proto.Service) {
 				InStreamed:  r.StreamsRequest,
 				OutStreamed: r.StreamsReturns,
 				Options:     parseOptions(r.Options),
+				RenamedFrom: hintValue(CommentRenamedFrom, r.Comment, r.InlineComment),
 			})
 		}
 	}
@@ -279,19 +401,29 @@ func withMessage(m *proto.Message) {
 }
 
 func parseMessage(m *proto.Message) Message {
+	_, topLevel := m.Parent.(*proto.Proto)
 	msg := Message{
-		Name: m.Name,
+		Name:        m.Name,
+		RenamedFrom: hintValue(CommentRenamedFrom, m.Comment),
+		MovedFrom:   movedFrom(m.Comment),
+		Visibility:  editions.visibility(m.Position, !topLevel),
+		IsExtend:    m.IsExtend,
 	}
 
 	for _, v := range m.Elements {
 
 		if f, ok := v.(*proto.NormalField); ok {
 			msg.Fields = append(msg.Fields, Field{
-				ID:         f.Sequence,
-				Name:       f.Name,
-				Type:       f.Type,
-				IsRepeated: f.Repeated,
-				Options:    parseOptions(f.Options),
+				ID:          f.Sequence,
+				Name:        f.Name,
+				Type:        f.Type,
+				IsRepeated:  f.Repeated,
+				Options:     parseOptions(f.Options),
+				RenamedFrom: hintValue(CommentRenamedFrom, f.Comment, f.InlineComment),
+				IsRequired:  f.Required,
+				// the optional label of proto2 is that of every singular
+				// field, and is not recorded
+				IsOptional: f.Optional && syntax == SyntaxProto3,
 			})
 		}
 
@@ -300,11 +432,12 @@ func parseMessage(m *proto.Message) Message {
 			msg.Maps = append(msg.Maps, Map{
 				KeyType: mp.KeyType,
 				Field: Field{
-					ID:         f.Sequence,
-					Name:       f.Name,
-					Type:       f.Type,
-					IsRepeated: false,
-					Options:    parseOptions(f.Options),
+					ID:          f.Sequence,
+					Name:        f.Name,
+					Type:        f.Type,
+					IsRepeated:  false,
+					Options:     parseOptions(f.Options),
+					RenamedFrom: hintValue(CommentRenamedFrom, f.Comment, f.InlineComment),
 				},
 			})
 		}
@@ -314,11 +447,13 @@ func parseMessage(m *proto.Message) Message {
 			for _, el := range oo.Elements {
 				if f, ok := el.(*proto.OneOfField); ok {
 					fields = append(fields, Field{
-						ID:         f.Sequence,
-						Name:       f.Name,
-						Type:       f.Type,
-						IsRepeated: false,
-						Options:    parseOptions(f.Options),
+						ID:          f.Sequence,
+						Name:        f.Name,
+						Type:        f.Type,
+						IsRepeated:  false,
+						Options:     parseOptions(f.Options),
+						RenamedFrom: hintValue(CommentRenamedFrom, f.Comment, f.InlineComment),
+						OneOf:       oo.Name,
 					})
 				}
 			}
@@ -341,7 +476,17 @@ func parseMessage(m *proto.Message) Message {
 			}
 
 			// add all reserved field names
-			msg.ReservedNames = append(msg.ReservedNames, r.FieldNames...)
+			msg.ReservedNames = append(msg.ReservedNames, editions.reservedNames(r)...)
+		}
+
+		if e, ok := v.(*proto.Extensions); ok {
+			for _, rng := range e.Ranges {
+				to := rng.To
+				if rng.Max {
+					to = maxFieldID
+				}
+				msg.Extensions = append(msg.Extensions, ExtensionRange{From: rng.From, To: to})
+			}
 		}
 
 		if o, ok := v.(*proto.Option); ok {
@@ -356,6 +501,16 @@ func parseMessage(m *proto.Message) Message {
 	return msg
 }
 
+// movedFrom returns the previous file of a type, as declared by the
+// CommentMovedFrom hint, in the Protopath format.
+func movedFrom(c *proto.Comment) Protopath {
+	path := hintValue(CommentMovedFrom, c)
+	if path == "" {
+		return ""
+	}
+	return ProtoPath(Protopath(filepath.FromSlash(path)))
+}
+
 func withOption(o *proto.Option) {
 	if _, ok := o.Parent.(*proto.Proto); !ok {
 		return
@@ -384,16 +539,37 @@ func parseOption(o *proto.Option) Option {
 }
 
 func parseAggregatedValues(o *proto.Option) []Option {
+	return parseLiteralMap(o.Constant.OrderedMap)
+}
+
+// parseLiteralMap records the fields of a message literal, including nested
+// message literals as aggregated options, and each element of a list as a
+// separate option of the same name.
+func parseLiteralMap(m proto.LiteralMap) []Option {
 	var aggOpts []Option
-	for _, nl := range o.Constant.OrderedMap {
-		aggOpts = append(aggOpts, Option{
-			Name:  nl.Name,
-			Value: nl.Source,
-		})
+	for _, nl := range m {
+		aggOpts = append(aggOpts, parseLiteral(nl.Name, nl.Literal)...)
 	}
 	return aggOpts
 }
 
+func parseLiteral(name string, l *proto.Literal) []Option {
+	if l == nil {
+		return []Option{{Name: name}}
+	}
+	if l.OrderedMap != nil {
+		return []Option{{Name: name, Aggregated: parseLiteralMap(l.OrderedMap)}}
+	}
+	if len(l.Array) > 0 {
+		var opts []Option
+		for _, el := range l.Array {
+			opts = append(opts, parseLiteral(name, el)...)
+		}
+		return opts
+	}
+	return []Option{{Name: name, Value: l.Source}}
+}
+
 func isAggregatedOption(o *proto.Option) bool {
 	return o.Constant.Source == "" && o.Constant.OrderedMap != nil
 }
@@ -427,6 +603,18 @@ func withPackage(im *proto.Package) {
 	}
 }
 
+func protoWithSyntax(apply func(p *proto.Syntax)) proto.Handler {
+	return func(v proto.Visitee) {
+		if s, ok := v.(*proto.Syntax); ok {
+			apply(s)
+		}
+	}
+}
+
+func withSyntax(s *proto.Syntax) {
+	syntax = s.Value
+}
+
 // openLockFile opens and returns the lock file on disk for reading.
 func openLockFile(cfg Config) (io.ReadCloser, error) {
 	f, err := os.Open(cfg.LockFilePath())
@@ -458,19 +646,53 @@ func FromReader(r io.Reader) (Protolock, error) {
 // one or more warnings to report to the caller. If no error is returned, the
 // Report can be ignored.
 func Compare(current, update Protolock) (*Report, error) {
-	var warnings []Warning
-	var wg sync.WaitGroup
+	return compareRules(current, update, Rules)
+}
+
+// compareRules is Compare, limited to the provided rules.
+func compareRules(current, update Protolock, rules []Rule) (*Report, error) {
 	report := &Report{
 		Current: current,
 		Updated: update,
 	}
-	for _, rule := range Rules {
+	if len(settings.Modes) > 0 {
+		report.Warnings = compareModes(current, update, rules)
+	} else {
+		report.Warnings = runRules(current, update, rules)
+	}
+
+	if len(report.Warnings) != 0 {
+		return report, ErrWarningsFound
+	}
+
+	return report, nil
+}
+
+// runRules runs each rule on the current vs. updated Protolock definitions,
+// returning the warnings named after their rule. The declared renames are
+// applied to the current definitions once, for all the rules following them.
+func runRules(current, update Protolock, rules []Rule) []Warning {
+	renamed := current
+	for _, rule := range rules {
+		if rule.FollowsRenames {
+			renamed = applyDeclaredRenames(current, update)
+			break
+		}
+	}
+
+	var warnings []Warning
+	var wg sync.WaitGroup
+	for _, rule := range rules {
 		wg.Add(1)
 		go func() {
 			if debug {
 				beginRuleDebug(rule.Name)
 			}
-			_warnings, _ := rule.Func(current, update)
+			cur := current
+			if rule.FollowsRenames {
+				cur = renamed
+			}
+			_warnings, _ := rule.Func(cur, update)
 			for i := range _warnings {
 				_warnings[i].RuleName = rule.Name
 			}
@@ -483,13 +705,8 @@ func Compare(current, update Protolock) (*Report, error) {
 		}()
 		wg.Wait()
 	}
-	report.Warnings = warnings
 
-	if len(report.Warnings) != 0 {
-		return report, ErrWarningsFound
-	}
-
-	return report, nil
+	return warnings
 }
 
 // getProtoFiles finds recursively all .proto files to be processed.
@@ -552,7 +769,25 @@ func getUpdatedLock(cfg Config) (*Protolock, error) {
 		return nil, err
 	}
 
+	// the files untracked from the proto.lock file are carried over, and
+	// those which reappear in the tree are retracked
+	untracked, err := getUntracked(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[Protopath]bool)
+
 	for _, path := range protoFiles {
+		rel, err := filepath.Rel(root, path)
+		if err == nil {
+			sources[ProtoPath(Protopath(rel))] = true
+		}
+
+		// only parse the files of the configured shard, if any
+		if cfg.Sharded() && err == nil && !cfg.Shard.Contains(ProtoPath(Protopath(rel))) {
+			continue
+		}
+
 		f, err := os.Open(path)
 		if err != nil {
 			return nil, err
@@ -595,6 +830,13 @@ func getUpdatedLock(cfg Config) (*Protolock, error) {
 			Def:      file.Entry,
 		})
 	}
+	if cfg.Sharded() {
+		shard := cfg.Shard
+		updated.Shard = &shard
+	}
+	retrackUntracked(untracked, sources)
+	updated.Untracked = untracked
+	setFingerprints(&updated)
 
 	return &updated, nil
 }
@@ -0,0 +1,318 @@
+// Package protolocktest runs the protolock checks from within Go tests, so
+// that breaking changes to proto files fail "go test" like any other test:
+//
+//	func TestProtos(t *testing.T) {
+//		protolocktest.AssertCompatible(t, "api", "api/proto.lock")
+//		protolocktest.AssertUpToDate(t, "api", "api/proto.lock")
+//	}
+//
+// Running "go test -update" regenerates the proto.lock file, as "protolock
+// commit" would: only if no breaking changes are found. The checks set the
+// strict mode and settings of the protolock package until the end of the test,
+// so tests using them must not run in parallel.
+package protolocktest
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+	"github.com/nilslice/protolock"
+)
+
+const updateFlag = "update"
+
+func init() {
+	// the flag is shared with any package which registered it first
+	if flag.Lookup(updateFlag) == nil {
+		flag.Bool(updateFlag, false, "regenerate the proto.lock files checked by protolocktest")
+	}
+}
+
+// Option configures the checks of AssertCompatible and AssertUpToDate.
+type Option func(*options)
+
+type options struct {
+	strict   bool
+	ignore   []string
+	settings string
+	update   *bool
+}
+
+// Strict enables or disables the strict mode of the rules, which is enabled
+// by default, as for the protolock command.
+func Strict(strict bool) Option {
+	return func(o *options) {
+		o.strict = strict
+	}
+}
+
+// Ignore excludes the proto files at the paths (relative to the proto root)
+// from the checks.
+func Ignore(paths ...string) Option {
+	return func(o *options) {
+		o.ignore = append(o.ignore, paths...)
+	}
+}
+
+// Settings reads the protolock.json settings file at path, rather than the
+// one next to the proto.lock file.
+func Settings(path string) Option {
+	return func(o *options) {
+		o.settings = path
+	}
+}
+
+// Update overrides the -update flag of "go test".
+func Update(update bool) Option {
+	return func(o *options) {
+		o.update = &update
+	}
+}
+
+// AssertCompatible reports each breaking change of the proto files within
+// protoRoot, compared to the proto.lock file at lockPath (or in the directory
+// lockPath), as a test error located at the proto file and line. It returns
+// whether the proto files are compatible.
+func AssertCompatible(t testing.TB, protoRoot, lockPath string, opts ...Option) bool {
+	t.Helper()
+	return check(t, protoRoot, lockPath, false, opts)
+}
+
+// AssertUpToDate reports a test error if the proto.lock file at lockPath (or
+// in the directory lockPath) does not record the current definitions of the
+// proto files within protoRoot. It returns whether the proto.lock file is
+// up-to-date.
+func AssertUpToDate(t testing.TB, protoRoot, lockPath string, opts ...Option) bool {
+	t.Helper()
+	return check(t, protoRoot, lockPath, true, opts)
+}
+
+func check(t testing.TB, protoRoot, lockPath string, upToDate bool, opts []Option) bool {
+	t.Helper()
+
+	o := options{strict: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	lockDir := lockPath
+	if filepath.Base(lockPath) == protolock.LockFileName {
+		lockDir = filepath.Dir(lockPath)
+	}
+	cfg, err := protolock.NewConfig(lockDir, protoRoot, strings.Join(o.ignore, ","), false)
+	if err != nil {
+		t.Errorf("protolock: %v", err)
+		return false
+	}
+
+	settingsPath := o.settings
+	if settingsPath == "" {
+		settingsPath = cfg.SettingsFilePath()
+	}
+	settings, err := protolock.LoadSettings(settingsPath)
+	if err != nil {
+		t.Errorf("protolock: %v", err)
+		return false
+	}
+	prevSettings, prevStrict := protolock.CurrentSettings(), protolock.StrictMode()
+	t.Cleanup(func() {
+		protolock.SetSettings(prevSettings)
+		protolock.SetStrict(prevStrict)
+	})
+	protolock.SetSettings(settings)
+	protolock.SetStrict(o.strict)
+
+	if !cfg.LockFileExists() {
+		if o.updating() {
+			return save(t, cfg, protolock.Init)
+		}
+		t.Errorf(`protolock: no %s found, run "go test -update" to create it`,
+			cfg.LockFilePath(),
+		)
+		return false
+	}
+
+	report, err := protolock.Status(*cfg)
+	if err != nil && err != protolock.ErrWarningsFound {
+		t.Errorf("protolock: %v", err)
+		return false
+	}
+
+	stale := !report.Current.Equal(&report.Updated)
+	if o.updating() && stale {
+		if len(report.Warnings) == 0 {
+			return save(t, cfg, protolock.Commit)
+		}
+		if upToDate {
+			t.Errorf("protolock: %s was not updated, as %d breaking changes were found",
+				cfg.LockFilePath(), len(report.Warnings),
+			)
+			return false
+		}
+	}
+
+	if upToDate {
+		if stale {
+			t.Errorf(`protolock: %s is not up-to-date with the proto files, run "go test -update" to regenerate it`,
+				cfg.LockFilePath(),
+			)
+			return false
+		}
+		return true
+	}
+
+	for _, w := range report.Warnings {
+		t.Errorf("%s: %s (%s)", location(cfg.ProtoRoot, w), w.Message, w.RuleName)
+	}
+
+	return len(report.Warnings) == 0
+}
+
+func (o options) updating() bool {
+	if o.update != nil {
+		return *o.update
+	}
+	f := flag.Lookup(updateFlag)
+	if f == nil {
+		return false
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	update, _ := getter.Get().(bool)
+
+	return update
+}
+
+// save writes the proto.lock file returned by Init or Commit.
+func save(t testing.TB, cfg *protolock.Config, lock func(protolock.Config) (io.Reader, error)) bool {
+	t.Helper()
+
+	r, err := lock(*cfg)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(cfg.LockFilePath())
+		if err == nil {
+			_, err = io.Copy(f, r)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		t.Errorf("protolock: %v", err)
+		return false
+	}
+
+	t.Logf("protolock: updated %s", cfg.LockFilePath())
+	return true
+}
+
+// location returns the path of the file of a warning, followed by the line of
+// the definition it refers to (its Entity), if the definition is found in the
+// file.
+func location(protoRoot string, w protolock.Warning) string {
+	path := filepath.Join(protoRoot, string(w.Filepath))
+	if w.Entity == "" {
+		return path
+	}
+	// the message names its entity first, which may also name a child
+	message := strings.TrimPrefix(w.Message, strconv.Quote(w.Entity))
+	if line := definitionLine(path, w.Entity, message); line > 0 {
+		return fmt.Sprintf("%s:%d", path, line)
+	}
+
+	return path
+}
+
+// definitionLine finds the line of a definition in a proto file, or of its
+// child (a field, enum value or RPC) which is named first by the message of
+// the warning, if any. Definitions are named as the entities of the warnings:
+// nested messages by their path from the top-level message, and nested enums
+// by their immediate parent message.
+func definitionLine(path, name, message string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	def, err := protolock.ParseProto(path, f)
+	if err != nil {
+		return 0
+	}
+
+	line := 0
+	found := func(defName string, pos int, children map[string]int) {
+		if defName != name || line > 0 {
+			return
+		}
+		line = pos
+		first := -1
+		for child, l := range children {
+			i := strings.Index(message, strconv.Quote(child))
+			if i >= 0 && (first < 0 || i < first) {
+				first, line = i, l
+			}
+		}
+	}
+
+	var walk func(prefix string, elements []proto.Visitee)
+	walk = func(prefix string, elements []proto.Visitee) {
+		for _, e := range elements {
+			switch e := e.(type) {
+			case *proto.Message:
+				children := make(map[string]int)
+				for _, el := range e.Elements {
+					switch el := el.(type) {
+					case *proto.NormalField:
+						children[el.Name] = el.Position.Line
+					case *proto.MapField:
+						children[el.Name] = el.Position.Line
+					case *proto.Oneof:
+						for _, oo := range el.Elements {
+							if f, ok := oo.(*proto.OneOfField); ok {
+								children[f.Name] = f.Position.Line
+							}
+						}
+					}
+				}
+				found(prefix+e.Name, e.Position.Line, children)
+				walk(prefix+e.Name+".", e.Elements)
+
+			case *proto.Enum:
+				children := make(map[string]int)
+				for _, el := range e.Elements {
+					if f, ok := el.(*proto.EnumField); ok {
+						children[f.Name] = f.Position.Line
+					}
+				}
+				enumName := e.Name
+				if p, ok := e.Parent.(*proto.Message); ok {
+					enumName = p.Name + "." + e.Name
+				}
+				found(enumName, e.Position.Line, children)
+
+			case *proto.Service:
+				children := make(map[string]int)
+				for _, el := range e.Elements {
+					if rpc, ok := el.(*proto.RPC); ok {
+						children[rpc.Name] = rpc.Position.Line
+					}
+				}
+				found(e.Name, e.Position.Line, children)
+			}
+		}
+	}
+	walk("", def.Elements)
+
+	return line
+}
@@ -0,0 +1,165 @@
+package protolocktest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nilslice/protolock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lockedProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1;
+  int64 total = 2;
+}
+`
+
+const changedProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1;
+  string total = 2;
+}
+`
+
+const extendedProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1;
+  int64 total = 2;
+  string currency = 3;
+}
+`
+
+// recorder captures the errors of the checks, which would otherwise fail
+// the test running them.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Logf(format string, args ...interface{}) {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func writeProto(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "billing", "v1", "invoice.proto")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestAssertCompatible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protolocktest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	lockPath := filepath.Join(dir, "proto.lock")
+	writeProto(t, dir, lockedProto)
+
+	// without a proto.lock file, -update creates it
+	r := &recorder{TB: t}
+	assert.False(t, AssertCompatible(r, dir, lockPath, Update(false)))
+	assert.Len(t, r.errors, 1)
+
+	r = &recorder{TB: t}
+	assert.True(t, AssertCompatible(r, dir, lockPath, Update(true)))
+	assert.Empty(t, r.errors)
+	assert.FileExists(t, lockPath)
+
+	// breaking changes are reported at their line, and are not locked
+	path := writeProto(t, dir, changedProto)
+	r = &recorder{TB: t}
+	assert.False(t, AssertCompatible(r, dir, dir, Update(true)))
+	require.Len(t, r.errors, 1)
+	assert.Equal(t,
+		path+`:6: "Invoice" field: "total" has a different type: string, previously int64 (NoChangingFieldTypes)`,
+		r.errors[0],
+	)
+
+	r = &recorder{TB: t}
+	assert.False(t, AssertUpToDate(r, dir, lockPath, Update(true)))
+	assert.Len(t, r.errors, 1)
+}
+
+func TestAssertUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protolocktest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeProto(t, dir, lockedProto)
+
+	r := &recorder{TB: t}
+	require.True(t, AssertUpToDate(r, dir, dir, Update(true)))
+	assert.True(t, AssertUpToDate(r, dir, dir, Update(false)))
+	assert.Empty(t, r.errors)
+
+	writeProto(t, dir, extendedProto)
+	assert.True(t, AssertCompatible(r, dir, dir, Update(false)))
+	assert.False(t, AssertUpToDate(r, dir, dir, Update(false)))
+	assert.Len(t, r.errors, 1)
+
+	r = &recorder{TB: t}
+	assert.True(t, AssertUpToDate(r, dir, dir, Update(true)))
+	assert.True(t, AssertUpToDate(r, dir, dir, Update(false)))
+	assert.Empty(t, r.errors)
+}
+
+func TestAssertCompatibleEditions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protolocktest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeProto(t, dir, `edition = "2023";
+package billing.v1;
+
+message Invoice {
+  reserved total;
+  string id = 1;
+  int64 amount = 3;
+}
+`)
+
+	r := &recorder{TB: t}
+	require.True(t, AssertCompatible(r, dir, dir, Update(true)))
+
+	path := writeProto(t, dir, `edition = "2023";
+package billing.v1;
+
+message Invoice {
+  reserved total;
+  string id = 1;
+  string amount = 3;
+}
+`)
+	assert.False(t, AssertCompatible(r, dir, dir, Update(false)))
+	require.Len(t, r.errors, 1)
+	assert.Equal(t,
+		path+`:7: "Invoice" field: "amount" has a different type: string, previously int64 (NoChangingFieldTypes)`,
+		r.errors[0],
+	)
+}
+
+func TestChecksRestoreGlobals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protolocktest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeProto(t, dir, lockedProto)
+
+	t.Run("non-strict", func(t *testing.T) {
+		r := &recorder{TB: t}
+		require.True(t, AssertCompatible(r, dir, dir, Update(true), Strict(false)))
+		assert.False(t, protolock.StrictMode())
+	})
+	assert.True(t, protolock.StrictMode())
+}
@@ -0,0 +1,587 @@
+package protolock
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/scanner"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emicklei/proto"
+)
+
+// identifier matches a valid name of a field or enum value.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// refactorTarget is a field or enum value, located within its proto file.
+type refactorTarget struct {
+	target   string
+	path     Protopath
+	file     string
+	src      []byte
+	syntax   string
+	parent   string
+	name     string
+	id       int
+	enum     bool
+	comment  *proto.Comment
+	options  []*proto.Option
+	position scanner.Position
+	// oneof is the oneof containing the field, if any.
+	oneof *proto.Oneof
+	// siblings are the names of the other fields of the parent message, or
+	// the other values of the parent enum, in order.
+	siblings []string
+	first    bool
+	// oneofSiblings is the number of other fields of the oneof.
+	oneofSiblings int
+}
+
+// edit replaces the bytes of a source file between start and end.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// Deprecate marks a field or enum value deprecated in its proto file, setting
+// the "deprecated" option, and (if sunset is not empty) a CommentSunset hint
+// with the date after which it is to be removed. The target is named by its
+// fully-qualified parent message or enum and its name, e.g.
+// "billing.v1.Invoice.total". It returns the path of the edited file, and
+// the Report of the comparison with the proto.lock file, which is made before
+// the file is written: if it fails, the file is left unedited.
+func Deprecate(cfg Config, target, sunset string) (Protopath, *Report, error) {
+	t, err := locateTarget(cfg, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var deprecated *proto.Option
+	for _, o := range t.options {
+		if o.Name == "deprecated" {
+			deprecated = o
+		}
+	}
+	if deprecated != nil && deprecated.Constant.Source == "true" {
+		return "", nil, fmt.Errorf("%s is already deprecated", target)
+	}
+	if sunset != "" {
+		if _, err := time.Parse("2006-01-02", sunset); err != nil {
+			return "", nil, fmt.Errorf("invalid sunset date %q, expected YYYY-MM-DD", sunset)
+		}
+	}
+
+	start, end, err := t.statement()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var edits []edit
+	switch {
+	case deprecated != nil:
+		// e.g. "deprecated = false" is rewritten, rather than repeated
+		at, err := offsetOf(t.src, deprecated.Constant.Position)
+		if err != nil {
+			return "", nil, err
+		}
+		value := deprecated.Constant.Source
+		if !bytes.HasPrefix(t.src[at:], []byte(value)) {
+			return "", nil, fmt.Errorf("%s: cannot find the deprecated option of %s", t.file, target)
+		}
+		edits = append(edits, edit{at, at + len(value), "true"})
+	case len(t.options) > 0:
+		closing := lastCode(t.src, start, end, ']')
+		if closing < 0 {
+			return "", nil, fmt.Errorf("%s: cannot find the options of %s", t.file, target)
+		}
+		at := trimSpaceLeft(t.src, start, closing)
+		edits = append(edits, edit{at, at, ", deprecated = true"})
+	default:
+		at := trimSpaceLeft(t.src, start, end)
+		edits = append(edits, edit{at, at, " [deprecated = true]"})
+	}
+	if sunset != "" {
+		edits = append(edits, t.hint(start, CommentSunset+" "+sunset))
+	}
+
+	return t.apply(cfg, edits)
+}
+
+// Remove deletes a field or enum value from its proto file, and reserves its
+// number and name in its parent message or enum, so that neither can be
+// reused. A target with a CommentSunset hint is only removed once its date has
+// passed. The target is named as for Deprecate.
+func Remove(cfg Config, target string) (Protopath, *Report, error) {
+	t, err := locateTarget(cfg, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case t.oneof != nil && t.oneofSiblings == 0:
+		return "", nil, fmt.Errorf("cannot remove %s, the only field of oneof %q", target, t.oneof.Name)
+	case len(t.siblings) == 0 && t.enum:
+		return "", nil, fmt.Errorf("cannot remove %s, the only value of %q", target, t.parent)
+	case t.enum && t.first && t.syntax == SyntaxProto3:
+		return "", nil, fmt.Errorf("cannot remove %s, the first value of a proto3 enum must be zero", target)
+	}
+	// dates formatted as YYYY-MM-DD compare as strings
+	today := time.Now().UTC().Format("2006-01-02")
+	if sunset := hintValue(CommentSunset, t.comment); sunset != "" && today <= sunset {
+		return "", nil, fmt.Errorf("cannot remove %s until after its sunset date %s", target, sunset)
+	}
+
+	start, end, err := t.statement()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the statement is removed along with its leading comment, and (if it is
+	// on its own lines) the whole lines
+	from := start
+	if t.comment != nil && t.comment.Position.Line < t.position.Line {
+		from, err = offsetOf(t.src, t.comment.Position)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	indent := ""
+	if ls := lineStart(t.src, from); strings.TrimSpace(string(t.src[ls:from])) == "" {
+		indent = string(t.src[ls:from])
+		from = ls
+	}
+	to := end + 1
+	rest := lineEnd(t.src, to)
+	if after := strings.TrimSpace(string(t.src[to:rest])); indent != "" && (after == "" || strings.HasPrefix(after, "//")) {
+		to = rest
+		if to < len(t.src) {
+			to++
+		}
+	}
+
+	reserved := fmt.Sprintf("reserved %d;\nreserved %s;\n", t.id, formatReservedNames([]string{t.name}, t.syntax)[0])
+
+	var edits []edit
+	if t.oneof == nil {
+		edits = append(edits, edit{from, to, indentLines(reserved, indent)})
+	} else {
+		// reserved statements are not allowed within a oneof, so they are
+		// added to the message, before the oneof
+		edits = append(edits, edit{from, to, ""})
+		at, err := offsetOf(t.src, t.oneof.Position)
+		if err != nil {
+			return "", nil, err
+		}
+		ls := lineStart(t.src, at)
+		edits = append(edits, edit{ls, ls, indentLines(reserved, string(t.src[ls:at]))})
+	}
+
+	return t.apply(cfg, edits)
+}
+
+// Rename renames a field or enum value in its proto file, adding a
+// CommentRenamedFrom hint with its previous name, unless it already has one
+// (i.e. it was renamed since the proto.lock file was committed). The target
+// is named as for Deprecate.
+func Rename(cfg Config, target, name string) (Protopath, *Report, error) {
+	t, err := locateTarget(cfg, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !identifier.MatchString(name) {
+		return "", nil, fmt.Errorf("invalid name %q", name)
+	}
+	for _, sibling := range t.siblings {
+		if sibling == name {
+			return "", nil, fmt.Errorf("%q already has a field named %q", t.parent, name)
+		}
+	}
+
+	start, end, err := t.statement()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the name is the identifier before the "=" of the statement
+	eq := firstCode(t.src, start, end, '=')
+	if eq < 0 {
+		return "", nil, fmt.Errorf("%s: cannot find the name of %s", t.file, target)
+	}
+	nameEnd := trimSpaceLeft(t.src, start, eq)
+	nameStart := nameEnd
+	for nameStart > start && isIdentByte(t.src[nameStart-1]) {
+		nameStart--
+	}
+	if string(t.src[nameStart:nameEnd]) != t.name {
+		return "", nil, fmt.Errorf("%s: cannot find the name of %s", t.file, target)
+	}
+
+	edits := []edit{{nameStart, nameEnd, name}}
+	if hintValue(CommentRenamedFrom, t.comment) == "" {
+		edits = append(edits, t.hint(start, CommentRenamedFrom+" "+t.name))
+	}
+
+	return t.apply(cfg, edits)
+}
+
+// locateTarget finds a field or enum value in its proto file, which is found
+// through the proto.lock file.
+func locateTarget(cfg Config, target string) (*refactorTarget, error) {
+	i := strings.LastIndex(target, nestedPrefix)
+	if i <= 0 || i == len(target)-1 {
+		return nil, fmt.Errorf("invalid target %q, expected e.g. pkg.Message.field", target)
+	}
+	typ, name := target[:i], target[i+1:]
+
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, ok := getTypeIndex(lock)[typ]
+	if !ok {
+		return nil, fmt.Errorf("no message or enum %q found in proto.lock", typ)
+	}
+	parent := typ
+	if locked.Package != "" {
+		parent = strings.TrimPrefix(typ, locked.Package+nestedPrefix)
+	}
+
+	t := &refactorTarget{
+		target: target,
+		path:   locked.Filepath,
+		file:   filepath.Join(cfg.ProtoRoot, string(OSPath(locked.Filepath))),
+		parent: parent,
+		name:   name,
+		enum:   locked.Kind == kindEnum,
+		syntax: SyntaxProto2,
+	}
+	t.src, err = ioutil.ReadFile(t.file)
+	if err != nil {
+		return nil, err
+	}
+	// the edition keywords are blanked out, keeping the offsets of t.src
+	editions := prepareEditions(t.src)
+	if editions.edition != "" {
+		t.syntax = SyntaxEditions
+	}
+	parser := proto.NewParser(bytes.NewReader(editions.src))
+	parser.Filename(t.file)
+	def, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	var walk func(prefix string, elements []proto.Visitee)
+	walk = func(prefix string, elements []proto.Visitee) {
+		for _, e := range elements {
+			switch e := e.(type) {
+			case *proto.Syntax:
+				t.syntax = e.Value
+			case *proto.Message:
+				if !t.enum && prefix+e.Name == parent {
+					found = t.findField(e) || found
+				}
+				walk(prefix+e.Name+nestedPrefix, e.Elements)
+			case *proto.Enum:
+				nested := e.Name
+				if p, ok := e.Parent.(*proto.Message); ok {
+					nested = p.Name + nestedPrefix + e.Name
+				}
+				if t.enum && (prefix+e.Name == parent || nested == parent) {
+					found = t.findValue(e) || found
+				}
+			}
+		}
+	}
+	walk("", def.Elements)
+
+	if !found {
+		return nil, fmt.Errorf("%s: %q has no field %q", t.file, parent, name)
+	}
+
+	return t, nil
+}
+
+func (t *refactorTarget) findField(msg *proto.Message) bool {
+	found := false
+	match := func(f *proto.Field, oneof *proto.Oneof) {
+		if f.Name != t.name {
+			t.siblings = append(t.siblings, f.Name)
+			return
+		}
+		found = true
+		t.id = f.Sequence
+		t.comment = f.Comment
+		t.options = f.Options
+		t.position = f.Position
+		t.oneof = oneof
+	}
+
+	for _, e := range msg.Elements {
+		switch e := e.(type) {
+		case *proto.NormalField:
+			match(e.Field, nil)
+		case *proto.MapField:
+			match(e.Field, nil)
+		case *proto.Oneof:
+			for _, el := range e.Elements {
+				if f, ok := el.(*proto.OneOfField); ok {
+					match(f.Field, e)
+				}
+			}
+		}
+	}
+
+	if found && t.oneof != nil {
+		for _, el := range t.oneof.Elements {
+			if f, ok := el.(*proto.OneOfField); ok && f.Name != t.name {
+				t.oneofSiblings++
+			}
+		}
+	}
+
+	return found
+}
+
+func (t *refactorTarget) findValue(enum *proto.Enum) bool {
+	found := false
+	for _, e := range enum.Elements {
+		v, ok := e.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		if v.Name != t.name {
+			t.siblings = append(t.siblings, v.Name)
+			continue
+		}
+		found = true
+		t.first = len(t.siblings) == 0
+		t.id = v.Integer
+		t.comment = v.Comment
+		t.position = v.Position
+		for _, el := range v.Elements {
+			if o, ok := el.(*proto.Option); ok {
+				t.options = append(t.options, o)
+			}
+		}
+	}
+
+	return found
+}
+
+// statement returns the offsets of the start of the statement declaring the
+// target (including its label), and of the ";" which ends it.
+func (t *refactorTarget) statement() (int, int, error) {
+	start, err := offsetOf(t.src, t.position)
+	if err != nil {
+		return 0, 0, err
+	}
+	ls := lineStart(t.src, start)
+	switch strings.TrimSpace(string(t.src[ls:start])) {
+	case "repeated", "optional", "required":
+		start = ls + len(t.src[ls:start]) - len(bytes.TrimLeft(t.src[ls:start], " \t"))
+	}
+
+	end := firstCode(t.src, start, len(t.src), ';')
+	if end < 0 {
+		return 0, 0, fmt.Errorf("%s: cannot find the end of %s", t.file, t.target)
+	}
+
+	return start, end, nil
+}
+
+// hint returns an edit adding a hint comment on its own line, before the
+// statement starting at start.
+func (t *refactorTarget) hint(start int, hint string) edit {
+	ls := lineStart(t.src, start)
+	indent := string(t.src[ls:start])
+	if strings.TrimSpace(indent) != "" {
+		indent = ""
+	}
+
+	return edit{ls, ls, indent + "// " + hint + "\n"}
+}
+
+// apply compares the tree, with the edits applied to the proto file, with the
+// proto.lock file, then writes the edited file unless the comparison fails.
+func (t *refactorTarget) apply(cfg Config, edits []edit) (Protopath, *Report, error) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	src := t.src
+	for _, e := range edits {
+		var b []byte
+		b = append(b, src[:e.start]...)
+		b = append(b, e.text...)
+		b = append(b, src[e.end:]...)
+		src = b
+	}
+
+	updated, err := getUpdatedLock(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	entry, err := Parse(t.file, bytes.NewReader(src))
+	if err != nil {
+		return "", nil, err
+	}
+	for i, def := range updated.Definitions {
+		if slashPath(def.Filepath) == slashPath(t.path) {
+			updated.Definitions[i].Def = entry
+		}
+	}
+	setFingerprints(updated)
+
+	// the edits make the proto.lock file out of date, until it is committed
+	report, err := statusOf(cfg, updated, refactorRules())
+	if err != nil && err != ErrOutOfDate {
+		return "", report, err
+	}
+
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return "", nil, err
+	}
+	if werr := ioutil.WriteFile(t.file, src, info.Mode()); werr != nil {
+		return "", nil, werr
+	}
+
+	return OSPath(t.path), report, err
+}
+
+// refactorRules returns the rules checked by the refactoring commands: all but
+// NoRenamingOrMovingDefinitions, as the renames they declare are intended.
+func refactorRules() []Rule {
+	var rules []Rule
+	for _, rule := range Rules {
+		if rule.Name != "NoRenamingOrMovingDefinitions" {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// offsetOf converts a line and column (in characters) to a byte offset.
+func offsetOf(src []byte, pos scanner.Position) (int, error) {
+	line := 1
+	offset := 0
+	for line < pos.Line {
+		i := bytes.IndexByte(src[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("%s: line %d not found", pos.Filename, pos.Line)
+		}
+		offset += i + 1
+		line++
+	}
+	for col := 1; col < pos.Column && offset < len(src); col++ {
+		_, size := utf8.DecodeRune(src[offset:])
+		offset += size
+	}
+
+	return offset, nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func lineStart(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+func lineEnd(src []byte, offset int) int {
+	i := bytes.IndexByte(src[offset:], '\n')
+	if i < 0 {
+		return len(src)
+	}
+	return offset + i
+}
+
+// trimSpaceLeft returns the offset following the last non-space byte before
+// end, but not before start.
+func trimSpaceLeft(src []byte, start, end int) int {
+	for end > start && (src[end-1] == ' ' || src[end-1] == '\t') {
+		end--
+	}
+	return end
+}
+
+func indentLines(text, indent string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// firstCode returns the offset of the first c between start and end which is
+// neither in a string nor in a comment, or -1 if there is none.
+func firstCode(src []byte, start, end int, c byte) int {
+	found := -1
+	scanCode(src, start, end, func(i int) bool {
+		if src[i] == c {
+			found = i
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// lastCode is firstCode, returning the last c.
+func lastCode(src []byte, start, end int, c byte) int {
+	found := -1
+	scanCode(src, start, end, func(i int) bool {
+		if src[i] == c {
+			found = i
+		}
+		return true
+	})
+	return found
+}
+
+// scanCode calls fn with the offset of each byte between start and end which
+// is neither in a string nor in a comment, until fn returns false.
+func scanCode(src []byte, start, end int, fn func(i int) bool) {
+	for i := start; i < end; i++ {
+		switch {
+		case src[i] == '"' || src[i] == '\'':
+			quote := src[i]
+			for i++; i < end && src[i] != quote; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case src[i] == '/' && i+1 < end && src[i+1] == '/':
+			i = lineEnd(src, i)
+		case src[i] == '/' && i+1 < end && src[i+1] == '*':
+			j := bytes.Index(src[i+2:end], []byte("*/"))
+			if j < 0 {
+				return
+			}
+			i += j + 3
+		default:
+			if !fn(i) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const refactorProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1 [deprecated = false];
+  // the total, in cents
+  int64 total = 2; // deprecated soon
+  repeated string tags = 3 [json_name = "labels"];
+  oneof payer {
+    string person = 4;
+    string company = 5;
+  }
+
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    STATUS_PAID = 1;
+  }
+}
+`
+
+const refactoredProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  string id = 1 [deprecated = true];
+  reserved 2;
+  reserved "total";
+  // @protolock:sunset 2027-01-31
+  repeated string tags = 3 [json_name = "labels", deprecated = true];
+  reserved 5;
+  reserved "company";
+  oneof payer {
+    // @protolock:renamed-from person
+    string payer_name = 4;
+  }
+
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    // @protolock:renamed-from STATUS_PAID
+    STATUS_SETTLED = 1 [deprecated = true];
+  }
+}
+`
+
+func TestRefactor(t *testing.T) {
+	cfg, cleanup := refactorTestConfig(t, "billing/v1/invoice.proto", refactorProto)
+	defer cleanup()
+	path := filepath.Join(cfg.ProtoRoot, "billing", "v1", "invoice.proto")
+
+	// renames break source compatibility, but are intended, so the result is
+	// clean even in strict mode
+	check := func(p Protopath, report *Report, err error) {
+		assert.Equal(t, OSPath(ProtoPath("billing/v1/invoice.proto")), p)
+		assert.Empty(t, report.Warnings)
+		assert.NoError(t, err)
+	}
+
+	check(Remove(*cfg, "billing.v1.Invoice.total"))
+	check(Deprecate(*cfg, "billing.v1.Invoice.tags", "2027-01-31"))
+	check(Deprecate(*cfg, "billing.v1.Invoice.id", ""))
+	check(Remove(*cfg, "billing.v1.Invoice.company"))
+	check(Rename(*cfg, "billing.v1.Invoice.person", "payer_name"))
+	check(Deprecate(*cfg, "billing.v1.Invoice.Status.STATUS_PAID", ""))
+	check(Rename(*cfg, "billing.v1.Invoice.Status.STATUS_PAID", "STATUS_SETTLED"))
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, refactoredProto, string(b))
+
+	// the targets are checked before the file is edited
+	_, _, err = Remove(*cfg, "billing.v1.Invoice.payer_name")
+	assert.EqualError(t, err, `cannot remove billing.v1.Invoice.payer_name, the only field of oneof "payer"`)
+	_, _, err = Remove(*cfg, "billing.v1.Invoice.Status.STATUS_UNSPECIFIED")
+	assert.Error(t, err)
+	_, _, err = Deprecate(*cfg, "billing.v1.Invoice.tags", "")
+	assert.EqualError(t, err, "billing.v1.Invoice.tags is already deprecated")
+	_, _, err = Rename(*cfg, "billing.v1.Invoice.id", "tags")
+	assert.Error(t, err)
+	_, _, err = Rename(*cfg, "billing.v1.Missing.id", "key")
+	assert.EqualError(t, err, `no message or enum "billing.v1.Missing" found in proto.lock`)
+
+	b2, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(b), string(b2))
+
+	// the file is not edited if the comparison fails
+	unreserved := strings.Replace(string(b), "  string id = 1 [deprecated = true];\n", "", 1)
+	writeTestFile(t, path, unreserved)
+	p, report, err := Deprecate(*cfg, "billing.v1.Invoice.Status.STATUS_UNSPECIFIED", "")
+	assert.Equal(t, ErrWarningsFound, err)
+	assert.Equal(t, Protopath(""), p)
+	require.Len(t, report.Warnings, 2)
+	for _, w := range report.Warnings {
+		assert.Equal(t, "NoRemovingFieldsWithoutReserve", w.RuleName)
+	}
+	b3, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, unreserved, string(b3))
+}
+
+const refactorEditionsProto = `edition = "2023";
+package billing.v2;
+
+message Invoice {
+  string id = 1;
+  int64 total = 2;
+}
+`
+
+func refactorTestConfig(t *testing.T, path, proto string) (*Config, func()) {
+	dir, err := ioutil.TempDir("", "refactor")
+	require.NoError(t, err)
+	writeTestFile(t, filepath.Join(dir, path), proto)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	r, err := Init(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+
+	return cfg, func() { os.RemoveAll(dir) }
+}
+
+func TestRemoveEditions(t *testing.T) {
+	cfg, cleanup := refactorTestConfig(t, "billing/v2/invoice.proto", refactorEditionsProto)
+	defer cleanup()
+
+	// editions reserve names as identifiers rather than strings
+	_, report, err := Remove(*cfg, "billing.v2.Invoice.total")
+	require.NoError(t, err)
+	assert.Empty(t, report.Warnings)
+
+	b, err := ioutil.ReadFile(filepath.Join(cfg.ProtoRoot, "billing", "v2", "invoice.proto"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "  reserved 2;\n  reserved total;\n")
+}
+
+func TestRemoveSunset(t *testing.T) {
+	cfg, cleanup := refactorTestConfig(t, "billing/v1/invoice.proto", refactorProto)
+	defer cleanup()
+
+	// a target is only removed once its sunset date has passed
+	_, _, err := Deprecate(*cfg, "billing.v1.Invoice.total", "2999-12-31")
+	require.NoError(t, err)
+	_, _, err = Remove(*cfg, "billing.v1.Invoice.total")
+	assert.EqualError(t, err, "cannot remove billing.v1.Invoice.total until after its sunset date 2999-12-31")
+
+	_, _, err = Deprecate(*cfg, "billing.v1.Invoice.tags", "2000-01-31")
+	require.NoError(t, err)
+	_, report, err := Remove(*cfg, "billing.v1.Invoice.tags")
+	require.NoError(t, err)
+	assert.Empty(t, report.Warnings)
+}
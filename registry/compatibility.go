@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nilslice/protolock"
+)
+
+// SchemaType is the only schema type supported by the registry.
+const SchemaType = "PROTOBUF"
+
+// schemaRules returns the names of the protolock rules which apply to the
+// registry: those comparing the changes to a single file, rather than relating
+// the definitions of different files of a tree.
+func schemaRules() map[string]bool {
+	rules := make(map[string]bool)
+	for _, rule := range protolock.Rules {
+		if !rule.CrossFile {
+			rules[rule.Name] = true
+		}
+	}
+
+	return rules
+}
+
+// parseSchema parses a schema into a Protolock with a single Definition, so
+// that it can be compared to another schema registered for the same subject.
+func parseSchema(subject, schema string) (protolock.Protolock, error) {
+	path := protolock.Protopath(subject + ".proto")
+	entry, err := protolock.Parse(path.String(), strings.NewReader(schema))
+	if err != nil {
+		return protolock.Protolock{}, err
+	}
+
+	return protolock.Protolock{
+		Definitions: []protolock.Definition{
+			{
+				Filepath: path,
+				Def:      entry,
+			},
+		},
+	}, nil
+}
+
+// checkCompatibility compares a schema against the previous versions of a
+// subject (oldest first) according to a compatibility level, using the
+// protolock rules. It returns a message for each warning found.
+func checkCompatibility(level, subject, schema string, previous []Version) ([]string, error) {
+	if level == CompatibilityNone || len(previous) == 0 {
+		return nil, nil
+	}
+
+	updated, err := parseSchema(subject, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(level, "_TRANSITIVE") {
+		previous = previous[len(previous)-1:]
+	}
+	backward := strings.HasPrefix(level, CompatibilityBackward) ||
+		strings.HasPrefix(level, CompatibilityFull)
+	forward := strings.HasPrefix(level, CompatibilityForward) ||
+		strings.HasPrefix(level, CompatibilityFull)
+
+	rules := schemaRules()
+	var messages []string
+	for _, v := range previous {
+		current, err := parseSchema(subject, v.Schema)
+		if err != nil {
+			return nil, err
+		}
+
+		// backward compatibility checks that the new schema is a safe
+		// update of the previous one, forward compatibility the reverse
+		var reports []*protolock.Report
+		if backward {
+			report, err := protolock.Compare(current, updated)
+			if err != nil && err != protolock.ErrWarningsFound {
+				return nil, err
+			}
+			reports = append(reports, report)
+		}
+		if forward {
+			report, err := protolock.Compare(updated, current)
+			if err != nil && err != protolock.ErrWarningsFound {
+				return nil, err
+			}
+			reports = append(reports, report)
+		}
+
+		for _, report := range reports {
+			for _, w := range report.Warnings {
+				if !rules[w.RuleName] {
+					continue
+				}
+				messages = append(messages, fmt.Sprintf(
+					"version %d: %s [%s]", v.Version, w.Message, w.RuleName,
+				))
+			}
+		}
+	}
+
+	return messages, nil
+}
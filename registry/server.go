@@ -0,0 +1,546 @@
+// Package registry implements the core of the Confluent Schema Registry REST
+// API for PROTOBUF schemas, backed by a local file. The compatibility of each
+// new schema version is checked using the protolock rules.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Error codes, as defined by the Confluent Schema Registry.
+const (
+	errSubjectNotFound      = 40401
+	errVersionNotFound      = 40402
+	errSchemaNotFound       = 40403
+	errIncompatibleSchema   = 409
+	errInvalidSchema        = 42201
+	errInvalidVersion       = 42202
+	errInvalidCompatibility = 42203
+	errStore                = 50001
+)
+
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+type schemaResponse struct {
+	Subject    string `json:"subject,omitempty"`
+	ID         int    `json:"id"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType"`
+	Schema     string `json:"schema"`
+}
+
+type configRequest struct {
+	Compatibility string `json:"compatibility"`
+}
+
+type configResponse struct {
+	CompatibilityLevel string `json:"compatibilityLevel"`
+}
+
+type compatibilityResponse struct {
+	IsCompatible bool     `json:"is_compatible"`
+	Messages     []string `json:"messages,omitempty"`
+}
+
+// Server is an http.Handler serving the registry API from a Store.
+type Server struct {
+	store *Store
+}
+
+// NewServer returns a Server for the provided Store.
+func NewServer(store *Store) *Server {
+	return &Server{
+		store: store,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		p, err := url.PathUnescape(part)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		parts = append(parts, p)
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		respond(w, http.StatusOK, struct{}{})
+
+	case len(parts) == 1 && parts[0] == "subjects" && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, s.store.subjects())
+
+	case len(parts) == 2 && parts[0] == "subjects":
+		switch r.Method {
+		case http.MethodPost:
+			s.lookupSchema(w, r, parts[1])
+		case http.MethodDelete:
+			s.deleteSubject(w, r, parts[1])
+		default:
+			methodNotAllowed(w)
+		}
+
+	case len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions":
+		switch r.Method {
+		case http.MethodGet:
+			s.listVersions(w, parts[1])
+		case http.MethodPost:
+			s.registerSchema(w, r, parts[1])
+		default:
+			methodNotAllowed(w)
+		}
+
+	case len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions":
+		switch r.Method {
+		case http.MethodGet:
+			s.getVersion(w, parts[1], parts[3], false)
+		case http.MethodDelete:
+			s.deleteVersion(w, r, parts[1], parts[3])
+		default:
+			methodNotAllowed(w)
+		}
+
+	case len(parts) == 5 && parts[0] == "subjects" && parts[2] == "versions" &&
+		parts[4] == "schema" && r.Method == http.MethodGet:
+		s.getVersion(w, parts[1], parts[3], true)
+
+	case len(parts) == 2 && parts[0] == "schemas" && parts[1] == "types" &&
+		r.Method == http.MethodGet:
+		respond(w, http.StatusOK, []string{SchemaType})
+
+	case len(parts) >= 3 && len(parts) <= 4 && parts[0] == "schemas" &&
+		parts[1] == "ids" && r.Method == http.MethodGet:
+		s.getSchemaByID(w, parts[2], len(parts) == 4 && parts[3] == "schema")
+
+	case len(parts) >= 4 && len(parts) <= 5 && parts[0] == "compatibility" &&
+		parts[1] == "subjects" && parts[3] == "versions" && r.Method == http.MethodPost:
+		version := ""
+		if len(parts) == 5 {
+			version = parts[4]
+		}
+		s.testCompatibility(w, r, parts[2], version)
+
+	case len(parts) <= 2 && parts[0] == "config":
+		subject := ""
+		if len(parts) == 2 {
+			subject = parts[1]
+		}
+		s.config(w, r, subject)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	// error codes are the HTTP status, optionally followed by two digits
+	status := code
+	if status > 999 {
+		status /= 100
+	}
+	respond(w, status, registryError{
+		ErrorCode: code,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// decodeSchema reads a schema request, and checks that the schema is a
+// PROTOBUF schema which can be parsed.
+func decodeSchema(w http.ResponseWriter, r *http.Request, subject string) (schemaRequest, bool) {
+	var req schemaRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondError(w, errInvalidSchema, "invalid request: %v", err)
+		return req, false
+	}
+	if req.SchemaType != SchemaType {
+		respondError(w, errInvalidSchema, "unsupported schema type %q, only %s is supported", req.SchemaType, SchemaType)
+		return req, false
+	}
+	if _, err := parseSchema(subject, req.Schema); err != nil {
+		respondError(w, errInvalidSchema, "invalid schema: %v", err)
+		return req, false
+	}
+
+	return req, true
+}
+
+// parseVersion parses a version number, where "latest" is returned as -1.
+func parseVersion(w http.ResponseWriter, version string) (int, bool) {
+	if version == "latest" {
+		return -1, true
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil || (n < 1 && n != -1) {
+		respondError(w, errInvalidVersion, "the specified version %q is not a valid version id", version)
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (s *Server) subject(w http.ResponseWriter, name string) (*Subject, bool) {
+	subject, ok := s.store.data.Subjects[name]
+	if !ok || len(subject.live()) == 0 {
+		respondError(w, errSubjectNotFound, "subject %q not found", name)
+		return nil, false
+	}
+
+	return subject, true
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, name string) {
+	subject, ok := s.subject(w, name)
+	if !ok {
+		return
+	}
+
+	versions := []int{}
+	for _, v := range subject.live() {
+		versions = append(versions, v.Version)
+	}
+	respond(w, http.StatusOK, versions)
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, name, version string, raw bool) {
+	subject, ok := s.subject(w, name)
+	if !ok {
+		return
+	}
+	n, ok := parseVersion(w, version)
+	if !ok {
+		return
+	}
+	v, ok := subject.version(n)
+	if !ok {
+		respondError(w, errVersionNotFound, "version %s not found", version)
+		return
+	}
+
+	if raw {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(v.Schema))
+		return
+	}
+	respond(w, http.StatusOK, schemaResponse{
+		Subject:    name,
+		ID:         v.ID,
+		Version:    v.Version,
+		SchemaType: SchemaType,
+		Schema:     v.Schema,
+	})
+}
+
+func (s *Server) getSchemaByID(w http.ResponseWriter, id string, raw bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		respondError(w, errSchemaNotFound, "schema %s not found", id)
+		return
+	}
+	schema, ok := s.store.schemaByID(n)
+	if !ok {
+		respondError(w, errSchemaNotFound, "schema %s not found", id)
+		return
+	}
+
+	if raw {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(schema))
+		return
+	}
+	respond(w, http.StatusOK, schemaResponse{
+		SchemaType: SchemaType,
+		Schema:     schema,
+	})
+}
+
+func (s *Server) lookupSchema(w http.ResponseWriter, r *http.Request, name string) {
+	subject, ok := s.subject(w, name)
+	if !ok {
+		return
+	}
+	req, ok := decodeSchema(w, r, name)
+	if !ok {
+		return
+	}
+
+	for _, v := range subject.live() {
+		if v.Schema == req.Schema {
+			respond(w, http.StatusOK, schemaResponse{
+				Subject:    name,
+				ID:         v.ID,
+				Version:    v.Version,
+				SchemaType: SchemaType,
+				Schema:     v.Schema,
+			})
+			return
+		}
+	}
+
+	respondError(w, errSchemaNotFound, "schema not found")
+}
+
+func (s *Server) registerSchema(w http.ResponseWriter, r *http.Request, name string) {
+	req, ok := decodeSchema(w, r, name)
+	if !ok {
+		return
+	}
+
+	subject, ok := s.store.data.Subjects[name]
+	if !ok {
+		subject = &Subject{}
+	}
+
+	// registering an existing schema again is a no-op
+	live := subject.live()
+	for _, v := range live {
+		if v.Schema == req.Schema {
+			respond(w, http.StatusOK, map[string]int{"id": v.ID})
+			return
+		}
+	}
+
+	messages, err := checkCompatibility(s.store.compatibility(name), name, req.Schema, live)
+	if err != nil {
+		respondError(w, errInvalidSchema, "invalid schema: %v", err)
+		return
+	}
+	if len(messages) > 0 {
+		respondError(w, errIncompatibleSchema,
+			"schema being registered is incompatible with an earlier schema for subject %q: %s",
+			name, strings.Join(messages, "; "),
+		)
+		return
+	}
+
+	data := s.store.data.clone()
+	subject, ok = data.Subjects[name]
+	if !ok {
+		subject = &Subject{}
+		data.Subjects[name] = subject
+	}
+
+	// the same schema shares its id across subjects
+	id, ok := s.store.idOf(req.Schema)
+	if !ok {
+		id = data.NextID
+		data.NextID++
+	}
+	version := 1
+	if n := len(subject.Versions); n > 0 {
+		version = subject.Versions[n-1].Version + 1
+	}
+	subject.Versions = append(subject.Versions, Version{
+		Version: version,
+		ID:      id,
+		Schema:  req.Schema,
+	})
+
+	if err := s.store.save(data); err != nil {
+		respondError(w, errStore, "error saving schema: %v", err)
+		return
+	}
+	respond(w, http.StatusOK, map[string]int{"id": id})
+}
+
+func (s *Server) deleteSubject(w http.ResponseWriter, r *http.Request, name string) {
+	data := s.store.data.clone()
+	subject, ok := data.Subjects[name]
+	if !ok {
+		respondError(w, errSubjectNotFound, "subject %q not found", name)
+		return
+	}
+
+	versions := []int{}
+	for i, v := range subject.Versions {
+		if !v.Deleted {
+			versions = append(versions, v.Version)
+		}
+		subject.Versions[i].Deleted = true
+	}
+	if r.URL.Query().Get("permanent") == "true" {
+		delete(data.Subjects, name)
+	}
+
+	if err := s.store.save(data); err != nil {
+		respondError(w, errStore, "error deleting subject: %v", err)
+		return
+	}
+	respond(w, http.StatusOK, versions)
+}
+
+func (s *Server) deleteVersion(w http.ResponseWriter, r *http.Request, name, version string) {
+	data := s.store.data.clone()
+	subject, ok := data.Subjects[name]
+	if !ok {
+		respondError(w, errSubjectNotFound, "subject %q not found", name)
+		return
+	}
+	n, ok := parseVersion(w, version)
+	if !ok {
+		return
+	}
+	v, ok := subject.version(n)
+	if !ok {
+		respondError(w, errVersionNotFound, "version %s not found", version)
+		return
+	}
+
+	var versions []Version
+	for _, each := range subject.Versions {
+		if each.Version == v.Version {
+			if r.URL.Query().Get("permanent") == "true" {
+				continue
+			}
+			each.Deleted = true
+		}
+		versions = append(versions, each)
+	}
+	subject.Versions = versions
+
+	if err := s.store.save(data); err != nil {
+		respondError(w, errStore, "error deleting version: %v", err)
+		return
+	}
+	respond(w, http.StatusOK, v.Version)
+}
+
+func (s *Server) testCompatibility(w http.ResponseWriter, r *http.Request, name, version string) {
+	req, ok := decodeSchema(w, r, name)
+	if !ok {
+		return
+	}
+
+	level := s.store.compatibility(name)
+	var previous []Version
+	if subject, ok := s.store.data.Subjects[name]; ok {
+		previous = subject.live()
+		if version != "" {
+			n, ok := parseVersion(w, version)
+			if !ok {
+				return
+			}
+			v, ok := subject.version(n)
+			if !ok {
+				respondError(w, errVersionNotFound, "version %s not found", version)
+				return
+			}
+			// a specific version is compared directly, without
+			// considering the transitive history
+			previous = []Version{v}
+			level = strings.TrimSuffix(level, "_TRANSITIVE")
+		}
+	} else if version != "" {
+		respondError(w, errSubjectNotFound, "subject %q not found", name)
+		return
+	}
+
+	messages, err := checkCompatibility(level, name, req.Schema, previous)
+	if err != nil {
+		respondError(w, errInvalidSchema, "invalid schema: %v", err)
+		return
+	}
+
+	resp := compatibilityResponse{IsCompatible: len(messages) == 0}
+	if r.URL.Query().Get("verbose") == "true" {
+		resp.Messages = messages
+	}
+	respond(w, http.StatusOK, resp)
+}
+
+func (s *Server) config(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		level := s.store.data.Compatibility
+		if name != "" {
+			subject, ok := s.store.data.Subjects[name]
+			if !ok || subject.Compatibility == "" {
+				respondError(w, errSubjectNotFound, "subject %q does not have subject-level compatibility configured", name)
+				return
+			}
+			level = subject.Compatibility
+		}
+		if level == "" {
+			level = DefaultCompatibility
+		}
+		respond(w, http.StatusOK, configResponse{CompatibilityLevel: level})
+
+	case http.MethodPut:
+		var req configRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || !validCompatibility(req.Compatibility) {
+			respondError(w, errInvalidCompatibility, "invalid compatibility level %q", req.Compatibility)
+			return
+		}
+		data := s.store.data.clone()
+		if name == "" {
+			data.Compatibility = req.Compatibility
+		} else {
+			subject, ok := data.Subjects[name]
+			if !ok {
+				subject = &Subject{}
+				data.Subjects[name] = subject
+			}
+			subject.Compatibility = req.Compatibility
+		}
+
+		if err := s.store.save(data); err != nil {
+			respondError(w, errStore, "error saving config: %v", err)
+			return
+		}
+		respond(w, http.StatusOK, req)
+
+	case http.MethodDelete:
+		if name == "" {
+			methodNotAllowed(w)
+			return
+		}
+		data := s.store.data.clone()
+		subject, ok := data.Subjects[name]
+		if !ok || subject.Compatibility == "" {
+			respondError(w, errSubjectNotFound, "subject %q not found", name)
+			return
+		}
+		level := subject.Compatibility
+		subject.Compatibility = ""
+
+		if err := s.store.save(data); err != nil {
+			respondError(w, errStore, "error saving config: %v", err)
+			return
+		}
+		respond(w, http.StatusOK, configRequest{Compatibility: level})
+
+	default:
+		methodNotAllowed(w)
+	}
+}
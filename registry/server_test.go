@@ -0,0 +1,248 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schemaV1 = `syntax = "proto3";
+package test;
+
+message Order {
+  string id = 1;
+  int64 amount = 2;
+}
+`
+
+const schemaV2 = `syntax = "proto3";
+package test;
+
+message Order {
+  string id = 1;
+  int64 amount = 2;
+  string currency = 3;
+}
+`
+
+const schemaBreaking = `syntax = "proto3";
+package test;
+
+message Order {
+  string id = 1;
+  string amount = 2;
+}
+`
+
+func newTestServer(t *testing.T) (*httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir("", "registry")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "registry.json")
+
+	store, err := OpenStore(path)
+	require.NoError(t, err)
+	srv := httptest.NewServer(NewServer(store))
+
+	return srv, path, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func doRequest(t *testing.T, method, url string, body interface{}, out interface{}) int {
+	var r *strings.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		r = strings.NewReader(string(b))
+	} else {
+		r = strings.NewReader("")
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func register(t *testing.T, url, subject, schema string) (int, map[string]interface{}) {
+	var out map[string]interface{}
+	code := doRequest(t, http.MethodPost, url+"/subjects/"+subject+"/versions",
+		schemaRequest{Schema: schema, SchemaType: SchemaType}, &out,
+	)
+	return code, out
+}
+
+func TestRegisterSchemas(t *testing.T) {
+	srv, path, done := newTestServer(t)
+	defer done()
+
+	code, out := register(t, srv.URL, "orders-value", schemaV1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), out["id"])
+
+	// registering the same schema again returns the same id
+	code, out = register(t, srv.URL, "orders-value", schemaV1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), out["id"])
+
+	code, out = register(t, srv.URL, "orders-value", schemaV2)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), out["id"])
+
+	// a breaking change is rejected
+	code, out = register(t, srv.URL, "orders-value", schemaBreaking)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, float64(errIncompatibleSchema), out["error_code"])
+	assert.Contains(t, out["message"], "NoChangingFieldTypes")
+
+	var versions []int
+	code = doRequest(t, http.MethodGet, srv.URL+"/subjects/orders-value/versions", nil, &versions)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{1, 2}, versions)
+
+	var latest schemaResponse
+	code = doRequest(t, http.MethodGet, srv.URL+"/subjects/orders-value/versions/latest", nil, &latest)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, latest.Version)
+	assert.Equal(t, schemaV2, latest.Schema)
+	assert.Equal(t, SchemaType, latest.SchemaType)
+
+	var byID schemaResponse
+	code = doRequest(t, http.MethodGet, srv.URL+"/schemas/ids/1", nil, &byID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, schemaV1, byID.Schema)
+
+	var subjects []string
+	doRequest(t, http.MethodGet, srv.URL+"/subjects", nil, &subjects)
+	assert.Equal(t, []string{"orders-value"}, subjects)
+
+	// the store survives a restart
+	store, err := OpenStore(path)
+	require.NoError(t, err)
+	assert.Len(t, store.data.Subjects["orders-value"].Versions, 2)
+	assert.Equal(t, 3, store.data.NextID)
+}
+
+func TestCompatibilityAndConfig(t *testing.T) {
+	srv, _, done := newTestServer(t)
+	defer done()
+
+	register(t, srv.URL, "orders-value", schemaV1)
+
+	var compat compatibilityResponse
+	code := doRequest(t, http.MethodPost,
+		srv.URL+"/compatibility/subjects/orders-value/versions/latest?verbose=true",
+		schemaRequest{Schema: schemaBreaking, SchemaType: SchemaType}, &compat,
+	)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, compat.IsCompatible)
+	assert.NotEmpty(t, compat.Messages)
+
+	var config configResponse
+	doRequest(t, http.MethodGet, srv.URL+"/config", nil, &config)
+	assert.Equal(t, DefaultCompatibility, config.CompatibilityLevel)
+
+	var out map[string]interface{}
+	code = doRequest(t, http.MethodPut, srv.URL+"/config/orders-value",
+		configRequest{Compatibility: "SOMETIMES"}, &out,
+	)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, float64(errInvalidCompatibility), out["error_code"])
+
+	code = doRequest(t, http.MethodPut, srv.URL+"/config/orders-value",
+		configRequest{Compatibility: CompatibilityNone}, nil,
+	)
+	assert.Equal(t, http.StatusOK, code)
+
+	// with compatibility disabled, the breaking change is accepted
+	code, _ = register(t, srv.URL, "orders-value", schemaBreaking)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestDeleteAndErrors(t *testing.T) {
+	srv, _, done := newTestServer(t)
+	defer done()
+
+	var out map[string]interface{}
+	code := doRequest(t, http.MethodGet, srv.URL+"/subjects/missing/versions", nil, &out)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, float64(errSubjectNotFound), out["error_code"])
+
+	code, out = register(t, srv.URL, "orders-value", "message {")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, float64(errInvalidSchema), out["error_code"])
+
+	register(t, srv.URL, "orders-value", schemaV1)
+	register(t, srv.URL, "orders-value", schemaV2)
+
+	var version int
+	code = doRequest(t, http.MethodDelete, srv.URL+"/subjects/orders-value/versions/2", nil, &version)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, version)
+
+	code = doRequest(t, http.MethodGet, srv.URL+"/subjects/orders-value/versions/2", nil, &out)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, float64(errVersionNotFound), out["error_code"])
+
+	var deleted []int
+	code = doRequest(t, http.MethodDelete, srv.URL+"/subjects/orders-value", nil, &deleted)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{1}, deleted)
+
+	var subjects []string
+	doRequest(t, http.MethodGet, srv.URL+"/subjects", nil, &subjects)
+	assert.Empty(t, subjects)
+}
+
+func TestFailedSaveIsNotApplied(t *testing.T) {
+	srv, path, done := newTestServer(t)
+	defer done()
+
+	code, _ := register(t, srv.URL, "orders-value", schemaV1)
+	require.Equal(t, http.StatusOK, code)
+
+	// the store can no longer be written once its directory is gone
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+
+	var out map[string]interface{}
+	code, out = register(t, srv.URL, "orders-value", schemaV2)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, float64(errStore), out["error_code"])
+	code = doRequest(t, http.MethodPut, srv.URL+"/config",
+		configRequest{Compatibility: CompatibilityNone}, &out,
+	)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	code = doRequest(t, http.MethodDelete, srv.URL+"/subjects/orders-value", nil, &out)
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	var versions []int
+	doRequest(t, http.MethodGet, srv.URL+"/subjects/orders-value/versions", nil, &versions)
+	assert.Equal(t, []int{1}, versions)
+	var config configResponse
+	doRequest(t, http.MethodGet, srv.URL+"/config", nil, &config)
+	assert.Equal(t, DefaultCompatibility, config.CompatibilityLevel)
+}
+
+func TestSchemaRules(t *testing.T) {
+	rules := schemaRules()
+	assert.True(t, rules["NoChangingFieldTypes"])
+	assert.False(t, rules["NoInconsistentPackages"])
+	assert.False(t, rules["NoStaleHints"])
+	assert.False(t, rules["NoConflictingHTTPRoutes"])
+}
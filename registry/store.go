@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Compatibility levels, as defined by the Confluent Schema Registry.
+const (
+	CompatibilityNone               = "NONE"
+	CompatibilityBackward           = "BACKWARD"
+	CompatibilityBackwardTransitive = "BACKWARD_TRANSITIVE"
+	CompatibilityForward            = "FORWARD"
+	CompatibilityForwardTransitive  = "FORWARD_TRANSITIVE"
+	CompatibilityFull               = "FULL"
+	CompatibilityFullTransitive     = "FULL_TRANSITIVE"
+
+	// DefaultCompatibility is used until another global level is configured.
+	DefaultCompatibility = CompatibilityBackward
+)
+
+func validCompatibility(level string) bool {
+	switch level {
+	case CompatibilityNone,
+		CompatibilityBackward, CompatibilityBackwardTransitive,
+		CompatibilityForward, CompatibilityForwardTransitive,
+		CompatibilityFull, CompatibilityFullTransitive:
+		return true
+	}
+	return false
+}
+
+// Version is a single registered version of a subject's schema.
+type Version struct {
+	Version int    `json:"version"`
+	ID      int    `json:"id"`
+	Schema  string `json:"schema"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Subject is a named, versioned sequence of schemas.
+type Subject struct {
+	Compatibility string    `json:"compatibility,omitempty"`
+	Versions      []Version `json:"versions,omitempty"`
+}
+
+type storeData struct {
+	NextID        int                 `json:"next_id"`
+	Compatibility string              `json:"compatibility,omitempty"`
+	Subjects      map[string]*Subject `json:"subjects,omitempty"`
+}
+
+// Store is a file-backed collection of subjects and their schemas. Every
+// change is written to the file before it is acknowledged.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data storeData
+}
+
+// OpenStore reads the store at path, or starts an empty store if the file does
+// not exist yet.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: storeData{
+			NextID:   1,
+			Subjects: make(map[string]*Subject),
+		},
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &s.data)
+	if err != nil {
+		return nil, err
+	}
+	if s.data.Subjects == nil {
+		s.data.Subjects = make(map[string]*Subject)
+	}
+
+	return s, nil
+}
+
+// clone returns a deep copy of the data, which a change is made to before it
+// is saved.
+func (d storeData) clone() storeData {
+	cp := d
+	cp.Subjects = make(map[string]*Subject, len(d.Subjects))
+	for name, subject := range d.Subjects {
+		sub := *subject
+		sub.Versions = append([]Version(nil), subject.Versions...)
+		cp.Subjects[name] = &sub
+	}
+
+	return cp
+}
+
+// save writes the changed data to the store's file, replacing the previous
+// contents only once they have been written in full, and then serves it. If
+// the data cannot be written, the store is left unchanged. The caller must
+// hold the lock.
+func (s *Store) save(data storeData) error {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".registry")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.data = data
+
+	return nil
+}
+
+// subjects returns the names of all subjects with at least one version which
+// has not been deleted.
+func (s *Store) subjects() []string {
+	names := []string{}
+	for name, subject := range s.data.Subjects {
+		if len(subject.live()) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// live returns the versions of a subject which have not been deleted, oldest
+// first.
+func (subject *Subject) live() []Version {
+	var versions []Version
+	for _, v := range subject.Versions {
+		if !v.Deleted {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions
+}
+
+// version finds a live version of a subject by number, where -1 is the latest.
+func (subject *Subject) version(n int) (Version, bool) {
+	live := subject.live()
+	if len(live) == 0 {
+		return Version{}, false
+	}
+	if n == -1 {
+		return live[len(live)-1], true
+	}
+	for _, v := range live {
+		if v.Version == n {
+			return v, true
+		}
+	}
+
+	return Version{}, false
+}
+
+// schemaByID finds the schema registered under id, in any subject.
+func (s *Store) schemaByID(id int) (string, bool) {
+	for _, subject := range s.data.Subjects {
+		for _, v := range subject.Versions {
+			if v.ID == id {
+				return v.Schema, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// idOf finds the id of a schema which was already registered, in any subject.
+func (s *Store) idOf(schema string) (int, bool) {
+	for _, subject := range s.data.Subjects {
+		for _, v := range subject.Versions {
+			if v.Schema == schema {
+				return v.ID, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// compatibility returns the level configured for a subject, falling back to
+// the global level.
+func (s *Store) compatibility(name string) string {
+	if subject, ok := s.data.Subjects[name]; ok && subject.Compatibility != "" {
+		return subject.Compatibility
+	}
+	if s.data.Compatibility != "" {
+		return s.data.Compatibility
+	}
+
+	return DefaultCompatibility
+}
@@ -0,0 +1,675 @@
+package protolock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	renameMessage   = "message"
+	renameEnum      = "enum"
+	renameField     = "field"
+	renameEnumValue = "enum value"
+	renameService   = "service"
+	renameRPC       = "rpc"
+)
+
+// declaredRename is a rename or move of a definition, as declared by the
+// CommentRenamedFrom and CommentMovedFrom hints in the updated Protolock.
+type declaredRename struct {
+	kind string
+
+	// path, parent and name locate the definition in the updated Protolock,
+	// where parent is the enclosing message, enum or service (if any)
+	path   Protopath
+	parent string
+	name   string
+
+	// oldPath, oldParent and oldName locate the predecessor of the
+	// definition in the current Protolock
+	oldPath   Protopath
+	oldParent string
+	oldName   string
+}
+
+func (r declaredRename) qualifiedName() string {
+	return qualify(r.parent, r.name)
+}
+
+func (r declaredRename) qualifiedOldName() string {
+	return qualify(r.oldParent, r.oldName)
+}
+
+// subject describes the definition as it is referred to in warnings.
+func (r declaredRename) subject() string {
+	switch r.kind {
+	case renameField, renameEnumValue:
+		return fmt.Sprintf(`"%s" field: "%s"`, r.parent, r.name)
+	case renameRPC:
+		return fmt.Sprintf(`"%s" RPC: "%s"`, r.parent, r.name)
+	}
+	return fmt.Sprintf(`"%s"`, r.name)
+}
+
+// entity returns the name of the message, enum or service the subject belongs
+// to, as it is named in the warnings.
+func (r declaredRename) entity() string {
+	switch r.kind {
+	case renameField, renameEnumValue, renameRPC:
+		return r.parent
+	}
+	return r.qualifiedName()
+}
+
+// hint formats the hints which declared the rename or move.
+func (r declaredRename) hint() string {
+	var hints []string
+	if r.oldName != r.name {
+		hints = append(hints, CommentRenamedFrom+" "+r.oldName)
+	}
+	if r.oldPath != r.path {
+		hints = append(hints, CommentMovedFrom+" "+string(OSPath(r.oldPath)))
+	}
+	return strings.Join(hints, ", ")
+}
+
+// lockEntities:
+// table of filepath -> kind -> qualified name -> exists
+// i.e.
+/*
+	["test.proto"]	->	["message"]	->	["Channel"]		->	true
+					->	["Channel.A"]		->	true
+			->	["field"]	->	["Channel.id"]		->	true
+			->	["rpc"]		->	["ChannelChanger.Next"]	->	true
+*/
+type lockEntities map[Protopath]map[string]map[string]bool
+
+func (e lockEntities) add(path Protopath, kind, name string) {
+	if e[path] == nil {
+		e[path] = make(map[string]map[string]bool)
+	}
+	if e[path][kind] == nil {
+		e[path][kind] = make(map[string]bool)
+	}
+	e[path][kind][name] = true
+}
+
+func (e lockEntities) has(path Protopath, kind, name string) bool {
+	return e[path][kind][name]
+}
+
+// getLockEntities collects every definition which can be renamed or moved.
+func getLockEntities(lock Protolock) lockEntities {
+	entities := make(lockEntities)
+	var addMessage func(path Protopath, parent string, msg Message)
+	addMessage = func(path Protopath, parent string, msg Message) {
+		name := qualify(parent, msg.Name)
+		entities.add(path, renameMessage, name)
+		for _, field := range msg.Fields {
+			entities.add(path, renameField, qualify(name, field.Name))
+		}
+		for _, mp := range msg.Maps {
+			entities.add(path, renameField, qualify(name, mp.Field.Name))
+		}
+		for _, m := range msg.Messages {
+			addMessage(path, name, m)
+		}
+	}
+
+	for _, def := range lock.Definitions {
+		for _, msg := range def.Def.Messages {
+			addMessage(def.Filepath, "", msg)
+		}
+		for _, enum := range def.Def.Enums {
+			entities.add(def.Filepath, renameEnum, enum.Name)
+			for _, field := range enum.EnumFields {
+				entities.add(def.Filepath, renameEnumValue, qualify(enum.Name, field.Name))
+			}
+		}
+		for _, svc := range def.Def.Services {
+			entities.add(def.Filepath, renameService, svc.Name)
+			for _, rpc := range svc.RPCs {
+				entities.add(def.Filepath, renameRPC, qualify(svc.Name, rpc.Name))
+			}
+		}
+	}
+
+	return entities
+}
+
+// getDeclaredRenames collects the renames and moves declared by hints in the
+// lock. Renames of enclosing definitions are always listed before the renames
+// of the definitions they contain.
+func getDeclaredRenames(lock Protolock) []declaredRename {
+	var renames []declaredRename
+	for _, def := range lock.Definitions {
+		// nested enums are named after their immediate parent message, so
+		// track the previous name and file of each renamed or moved message
+		type previous struct {
+			name string
+			path Protopath
+		}
+		parents := make(map[string]previous)
+
+		var walk func(msg Message, parent, oldParent string, oldPath Protopath)
+		walk = func(msg Message, parent, oldParent string, oldPath Protopath) {
+			if parent == "" && msg.MovedFrom != "" {
+				oldPath = msg.MovedFrom
+			}
+			oldName := msg.Name
+			if msg.RenamedFrom != "" {
+				oldName = msg.RenamedFrom
+			}
+			r := declaredRename{
+				kind:      renameMessage,
+				path:      def.Filepath,
+				parent:    parent,
+				name:      msg.Name,
+				oldPath:   oldPath,
+				oldParent: oldParent,
+				oldName:   oldName,
+			}
+			if r.oldName != r.name || r.oldPath != r.path {
+				renames = append(renames, r)
+				parents[msg.Name] = previous{name: oldName, path: oldPath}
+			}
+
+			fields := append([]Field{}, msg.Fields...)
+			for _, mp := range msg.Maps {
+				fields = append(fields, mp.Field)
+			}
+			for _, field := range fields {
+				if field.RenamedFrom == "" {
+					continue
+				}
+				renames = append(renames, declaredRename{
+					kind:      renameField,
+					path:      def.Filepath,
+					parent:    r.qualifiedName(),
+					name:      field.Name,
+					oldPath:   oldPath,
+					oldParent: r.qualifiedOldName(),
+					oldName:   field.RenamedFrom,
+				})
+			}
+
+			for _, m := range msg.Messages {
+				walk(m, r.qualifiedName(), r.qualifiedOldName(), oldPath)
+			}
+		}
+		for _, msg := range def.Def.Messages {
+			walk(msg, "", "", def.Filepath)
+		}
+
+		for _, enum := range def.Def.Enums {
+			oldName, oldPath := enum.Name, def.Filepath
+			if i := strings.Index(enum.Name, nestedPrefix); i > 0 {
+				if p, ok := parents[enum.Name[:i]]; ok {
+					oldName = p.name + enum.Name[i:]
+					oldPath = p.path
+				}
+			} else if enum.MovedFrom != "" {
+				oldPath = enum.MovedFrom
+				renames = append(renames, declaredRename{
+					kind:    renameEnum,
+					path:    def.Filepath,
+					name:    enum.Name,
+					oldPath: oldPath,
+					oldName: oldName,
+				})
+			}
+
+			for _, field := range enum.EnumFields {
+				if field.RenamedFrom == "" {
+					continue
+				}
+				renames = append(renames, declaredRename{
+					kind:      renameEnumValue,
+					path:      def.Filepath,
+					parent:    enum.Name,
+					name:      field.Name,
+					oldPath:   oldPath,
+					oldParent: oldName,
+					oldName:   field.RenamedFrom,
+				})
+			}
+		}
+
+		for _, svc := range def.Def.Services {
+			oldName := svc.Name
+			if svc.RenamedFrom != "" {
+				oldName = svc.RenamedFrom
+				renames = append(renames, declaredRename{
+					kind:    renameService,
+					path:    def.Filepath,
+					name:    svc.Name,
+					oldPath: def.Filepath,
+					oldName: oldName,
+				})
+			}
+
+			for _, rpc := range svc.RPCs {
+				if rpc.RenamedFrom == "" {
+					continue
+				}
+				renames = append(renames, declaredRename{
+					kind:      renameRPC,
+					path:      def.Filepath,
+					parent:    svc.Name,
+					name:      rpc.Name,
+					oldPath:   def.Filepath,
+					oldParent: oldName,
+					oldName:   rpc.RenamedFrom,
+				})
+			}
+		}
+	}
+
+	return renames
+}
+
+// isPending reports whether the declared rename is yet to be recorded in the
+// lock, i.e. its predecessor is locked, but the definition itself is not.
+func (r declaredRename) isPending(locked lockEntities) bool {
+	return locked.has(r.oldPath, r.kind, r.qualifiedOldName()) &&
+		!locked.has(r.path, r.kind, r.qualifiedName())
+}
+
+// applyDeclaredRenames returns a copy of the current Protolock, in which the
+// predecessor of each pending rename or move declared in the updated Protolock
+// has been given its new name and location.
+func applyDeclaredRenames(cur, upd Protolock) Protolock {
+	var pending []declaredRename
+	locked := getLockEntities(cur)
+	for _, r := range getDeclaredRenames(upd) {
+		if r.isPending(locked) {
+			pending = append(pending, r)
+		}
+	}
+	if len(pending) == 0 {
+		return cur
+	}
+
+	// type references are resolved before any definitions are renamed, as
+	// the scopes in which they are resolved may be renamed too
+	types := make(map[string]string)
+	for _, r := range pending {
+		if r.kind != renameMessage && r.kind != renameEnum {
+			continue
+		}
+		oldType := qualify(packageOf(cur, r.oldPath).Name, r.qualifiedOldName())
+		newType := qualify(packageOf(upd, r.path).Name, r.qualifiedName())
+		if oldType != newType {
+			types[oldType] = newType
+		}
+	}
+
+	lock := copyProtolock(cur)
+	lock.renameTypeReferences(types)
+	for _, r := range pending {
+		switch r.kind {
+		case renameMessage:
+			lock.renameMessage(r, packageOf(upd, r.path))
+		case renameEnum:
+			lock.moveEnum(r, packageOf(upd, r.path))
+		case renameField:
+			if msg := lock.findMessage(r.path, r.parent); msg != nil {
+				for i := range msg.Fields {
+					if msg.Fields[i].Name == r.oldName {
+						msg.Fields[i].Name = r.name
+					}
+				}
+				for i := range msg.Maps {
+					if msg.Maps[i].Field.Name == r.oldName {
+						msg.Maps[i].Field.Name = r.name
+					}
+				}
+			}
+		case renameEnumValue:
+			if def := lock.findDefinition(r.path); def != nil {
+				for i, enum := range def.Def.Enums {
+					if enum.Name != r.parent {
+						continue
+					}
+					for j := range enum.EnumFields {
+						if enum.EnumFields[j].Name == r.oldName {
+							def.Def.Enums[i].EnumFields[j].Name = r.name
+						}
+					}
+				}
+			}
+		case renameService, renameRPC:
+			if def := lock.findDefinition(r.path); def != nil {
+				for i, svc := range def.Def.Services {
+					if r.kind == renameService && svc.Name == r.oldName {
+						def.Def.Services[i].Name = r.name
+					}
+					if r.kind == renameRPC && svc.Name == r.parent {
+						for j := range svc.RPCs {
+							if svc.RPCs[j].Name == r.oldName {
+								def.Def.Services[i].RPCs[j].Name = r.name
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return lock
+}
+
+// renameTypeReferences rewrites every field, map and RPC type which refers to
+// a renamed message or enum (or to a type nested within one), keeping the
+// qualification used by the reference.
+func (lock *Protolock) renameTypeReferences(types map[string]string) {
+	if len(types) == 0 {
+		return
+	}
+
+	index := getTypeIndex(*lock)
+	rename := func(scope, typ string) string {
+		name, ok := index.resolve(scope, typ)
+		if !ok {
+			return typ
+		}
+
+		// the most specific rename applies, e.g. a rename of a nested
+		// message takes precedence over a rename of its parent
+		var from string
+		for old := range types {
+			if (name == old || strings.HasPrefix(name, old+nestedPrefix)) && len(old) > len(from) {
+				from = old
+			}
+		}
+		if from == "" {
+			return typ
+		}
+		name = types[from] + strings.TrimPrefix(name, from)
+
+		if strings.HasPrefix(typ, nestedPrefix) {
+			return nestedPrefix + name
+		}
+		parts := strings.Split(name, nestedPrefix)
+		n := len(strings.Split(typ, nestedPrefix))
+		if n > len(parts) {
+			return name
+		}
+		return strings.Join(parts[len(parts)-n:], nestedPrefix)
+	}
+
+	var walk func(scope string, msg *Message)
+	walk = func(scope string, msg *Message) {
+		name := qualify(scope, msg.Name)
+		for i := range msg.Fields {
+			msg.Fields[i].Type = rename(name, msg.Fields[i].Type)
+		}
+		for i := range msg.Maps {
+			msg.Maps[i].Field.Type = rename(name, msg.Maps[i].Field.Type)
+		}
+		for i := range msg.Messages {
+			walk(name, &msg.Messages[i])
+		}
+	}
+
+	for _, def := range lock.Definitions {
+		pkg := def.Def.Package.Name
+		for i := range def.Def.Messages {
+			walk(pkg, &def.Def.Messages[i])
+		}
+		for _, svc := range def.Def.Services {
+			for i := range svc.RPCs {
+				svc.RPCs[i].InType = rename(pkg, svc.RPCs[i].InType)
+				svc.RPCs[i].OutType = rename(pkg, svc.RPCs[i].OutType)
+			}
+		}
+	}
+}
+
+// renameMessage moves the predecessor of a message to its new name and
+// location. Renames of enclosing messages have already been applied, so
+// nested messages are found within their new parent.
+func (lock *Protolock) renameMessage(r declaredRename, pkg Package) {
+	srcPath, oldName := r.path, qualify(r.parent, r.oldName)
+	if r.parent == "" {
+		srcPath = r.oldPath
+	}
+
+	dst := lock.definitionIndex(r.path, pkg)
+	src := lock.definitionIndex(srcPath, pkg)
+	msg, ok := takeMessage(&lock.Definitions[src].Def.Messages, oldName)
+	if !ok {
+		return
+	}
+	msg.Name = r.name
+
+	if r.parent == "" {
+		lock.Definitions[dst].Def.Messages = append(
+			lock.Definitions[dst].Def.Messages, msg,
+		)
+	} else if parent := lock.findMessage(r.path, r.parent); parent != nil {
+		parent.Messages = append(parent.Messages, msg)
+	}
+
+	// nested enums are named after their immediate parent, and follow it
+	var enums []Enum
+	for _, enum := range lock.Definitions[src].Def.Enums {
+		if strings.HasPrefix(enum.Name, r.oldName+nestedPrefix) {
+			enum.Name = r.name + strings.TrimPrefix(enum.Name, r.oldName)
+			if src != dst {
+				lock.Definitions[dst].Def.Enums = append(
+					lock.Definitions[dst].Def.Enums, enum,
+				)
+				continue
+			}
+		}
+		enums = append(enums, enum)
+	}
+	lock.Definitions[src].Def.Enums = enums
+}
+
+// moveEnum moves the predecessor of a top-level enum to its new location.
+func (lock *Protolock) moveEnum(r declaredRename, pkg Package) {
+	dst := lock.definitionIndex(r.path, pkg)
+	src := lock.definitionIndex(r.oldPath, pkg)
+
+	var enums []Enum
+	for _, enum := range lock.Definitions[src].Def.Enums {
+		if enum.Name == r.oldName {
+			enum.Name = r.name
+			lock.Definitions[dst].Def.Enums = append(
+				lock.Definitions[dst].Def.Enums, enum,
+			)
+			continue
+		}
+		enums = append(enums, enum)
+	}
+	lock.Definitions[src].Def.Enums = enums
+}
+
+// definitionIndex returns the index of the Definition for a path, adding an
+// empty Definition if there is none.
+func (lock *Protolock) definitionIndex(path Protopath, pkg Package) int {
+	for i, def := range lock.Definitions {
+		if def.Filepath == path {
+			return i
+		}
+	}
+
+	lock.Definitions = append(lock.Definitions, Definition{
+		Filepath: path,
+		Def:      Entry{Package: pkg},
+	})
+	return len(lock.Definitions) - 1
+}
+
+func (lock *Protolock) findDefinition(path Protopath) *Definition {
+	for i := range lock.Definitions {
+		if lock.Definitions[i].Filepath == path {
+			return &lock.Definitions[i]
+		}
+	}
+	return nil
+}
+
+// findMessage returns the message with a qualified name (e.g. "Outer.Inner")
+// within a file, or nil if it does not exist.
+func (lock *Protolock) findMessage(path Protopath, name string) *Message {
+	def := lock.findDefinition(path)
+	if def == nil {
+		return nil
+	}
+
+	msgs := def.Def.Messages
+	var found *Message
+	for _, part := range strings.Split(name, nestedPrefix) {
+		found = nil
+		for i := range msgs {
+			if msgs[i].Name == part {
+				found = &msgs[i]
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		msgs = found.Messages
+	}
+
+	return found
+}
+
+// takeMessage removes and returns the message with a qualified name from a
+// slice of messages (or their nested messages).
+func takeMessage(msgs *[]Message, name string) (Message, bool) {
+	parts := strings.SplitN(name, nestedPrefix, 2)
+	for i, msg := range *msgs {
+		if msg.Name != parts[0] {
+			continue
+		}
+		if len(parts) == 2 {
+			return takeMessage(&(*msgs)[i].Messages, parts[1])
+		}
+		*msgs = append((*msgs)[:i:i], (*msgs)[i+1:]...)
+		return msg, true
+	}
+
+	return Message{}, false
+}
+
+func packageOf(lock Protolock, path Protopath) Package {
+	for _, def := range lock.Definitions {
+		if def.Filepath == path {
+			return def.Def.Package
+		}
+	}
+	return Package{}
+}
+
+// copyProtolock returns a deep copy of a Protolock, so that it may be
+// modified without affecting the original.
+func copyProtolock(lock Protolock) Protolock {
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return lock
+	}
+
+	var cp Protolock
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return lock
+	}
+
+	return cp
+}
+
+// NoRenamingOrMovingDefinitions compares the current vs. updated Protolock
+// definitions and will return a list of warnings for each definition which has
+// been renamed or moved, as declared by a hint. Such changes are compatible on
+// the wire, but break generated code. This rule is only enforced when strict
+// mode is enabled.
+func NoRenamingOrMovingDefinitions(cur, upd Protolock) ([]Warning, bool) {
+	if !strict {
+		return nil, true
+	}
+
+	var warnings []Warning
+	locked := getLockEntities(cur)
+	for _, r := range getDeclaredRenames(upd) {
+		if !r.isPending(locked) {
+			continue
+		}
+
+		var msg string
+		switch {
+		case r.oldName != r.name && r.oldPath != r.path:
+			msg = fmt.Sprintf(
+				`%s has been renamed and moved, previously "%s" in %s`,
+				r.subject(), r.oldName, OSPath(r.oldPath),
+			)
+		case r.oldPath != r.path:
+			msg = fmt.Sprintf(
+				`%s has been moved, previously in %s`,
+				r.subject(), OSPath(r.oldPath),
+			)
+		default:
+			msg = fmt.Sprintf(
+				`%s has been renamed, previously "%s"`,
+				r.subject(), r.oldName,
+			)
+		}
+		warnings = append(warnings, Warning{
+			Filepath: OSPath(r.path),
+			Message:  msg,
+			Entity:   r.entity(),
+			Category: CategorySource,
+		})
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
+
+// NoStaleHints compares the current vs. updated Protolock definitions and will
+// return a list of warnings for each rename or move hint which no longer has
+// any effect, either because the lock has caught up with the change, or
+// because the hint does not match any locked definition. This rule is only
+// enforced when strict mode is enabled.
+func NoStaleHints(cur, upd Protolock) ([]Warning, bool) {
+	if !strict {
+		return nil, true
+	}
+
+	var warnings []Warning
+	locked := getLockEntities(cur)
+	for _, r := range getDeclaredRenames(upd) {
+		if r.isPending(locked) {
+			continue
+		}
+
+		msg := fmt.Sprintf(
+			`%s has a hint (%s) which does not match any locked definition`,
+			r.subject(), r.hint(),
+		)
+		if locked.has(r.path, r.kind, r.qualifiedName()) {
+			msg = fmt.Sprintf(
+				`%s has a stale hint (%s) which can be removed`,
+				r.subject(), r.hint(),
+			)
+		}
+		warnings = append(warnings, Warning{
+			Filepath: OSPath(r.path),
+			Message:  msg,
+			Entity:   r.entity(),
+		})
+	}
+
+	if warnings != nil {
+		return warnings, false
+	}
+
+	return nil, true
+}
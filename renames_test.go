@@ -0,0 +1,182 @@
+package protolock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const beforeRenamesProto = `syntax = "proto3";
+package test;
+
+message Stream {
+  reserved 9;
+  int64 id = 1;
+  string title = 2;
+
+  message Item {
+    string label = 1;
+  }
+
+  enum Kind {
+    UNKNOWN = 0;
+    SOUND = 1;
+  }
+}
+
+enum Moving {
+  A = 0;
+}
+
+service Streamer {
+  rpc Tune(Stream) returns (Stream);
+}
+`
+
+const afterRenamesProto = `syntax = "proto3";
+package test;
+
+// @protolock:renamed-from Stream
+message Channel {
+  reserved 9;
+  int64 id = 1;
+  string name = 2; // @protolock:renamed-from title
+
+  // @protolock:renamed-from Item
+  message Entry {
+    string label = 1;
+  }
+
+  enum Kind {
+    UNKNOWN = 0;
+    // @protolock:renamed-from SOUND
+    AUDIO = 1;
+  }
+}
+
+// @protolock:renamed-from Streamer
+service ChannelChanger {
+  // @protolock:renamed-from Tune
+  rpc Next(Channel) returns (Channel);
+}
+`
+
+const movedEnumProto = `syntax = "proto3";
+package test;
+
+// @protolock:moved-from old/moving.proto
+enum Moving {
+  A = 0;
+}
+`
+
+func parseTestProtoAt(t *testing.T, path Protopath, proto string) Definition {
+	entry, err := Parse(string(path), strings.NewReader(proto))
+	require.NoError(t, err)
+	return Definition{Filepath: path, Def: entry}
+}
+
+func TestParseRenameHints(t *testing.T) {
+	lock := parseTestProto(t, afterRenamesProto)
+	def := lock.Definitions[0].Def
+
+	assert.Equal(t, "Stream", def.Messages[0].RenamedFrom)
+	assert.Equal(t, "title", def.Messages[0].Fields[1].RenamedFrom)
+	assert.Equal(t, "Item", def.Messages[0].Messages[0].RenamedFrom)
+	assert.Equal(t, "SOUND", def.Enums[0].EnumFields[1].RenamedFrom)
+	assert.Equal(t, "Streamer", def.Services[0].RenamedFrom)
+	assert.Equal(t, "Tune", def.Services[0].RPCs[0].RenamedFrom)
+
+	moved := parseTestProtoAt(t, "new.proto", movedEnumProto)
+	assert.Equal(t, ProtoPath("old/moving.proto"), moved.Def.Enums[0].MovedFrom)
+}
+
+func TestDeclaredRenames(t *testing.T) {
+	SetStrict(true)
+	// the test files are not laid out by package
+	SetSettings(Settings{Packages: PackageSettings{
+		Exemptions: []PackageExemption{{Package: "test"}},
+	}})
+	defer SetSettings(Settings{})
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", beforeRenamesProto),
+	}}
+	// move the "Moving" enum out of the current file into its own file
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", afterRenamesProto),
+		parseTestProtoAt(t, ProtoPath("new/moving.proto"), strings.Replace(
+			movedEnumProto, "old/moving.proto", "test.proto", 1,
+		)),
+	}}
+
+	// without hints, the renames would be reported as removals
+	for _, rule := range []RuleFunc{
+		NoRemovingFieldsWithoutReserve,
+		NoRemovingReservedFields,
+		NoRemovingRPCs,
+	} {
+		warnings, ok := rule(cur, upd)
+		assert.False(t, ok)
+		assert.NotEmpty(t, warnings)
+	}
+
+	report, err := Compare(cur, upd)
+	assert.Equal(t, ErrWarningsFound, err)
+	var messages []string
+	for _, w := range report.Warnings {
+		assert.Equal(t, "NoRenamingOrMovingDefinitions", w.RuleName)
+		assert.Equal(t, CategorySource, w.Category)
+		messages = append(messages, w.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		`"Channel" has been renamed, previously "Stream"`,
+		`"Channel" field: "name" has been renamed, previously "title"`,
+		`"Entry" has been renamed, previously "Item"`,
+		`"Channel.Kind" field: "AUDIO" has been renamed, previously "SOUND"`,
+		`"ChannelChanger" has been renamed, previously "Streamer"`,
+		`"ChannelChanger" RPC: "Next" has been renamed, previously "Tune"`,
+		`"Moving" has been moved, previously in test.proto`,
+	}, messages)
+
+	// once the lock has caught up, the hints are reported as stale
+	warnings, ok := NoStaleHints(upd, upd)
+	assert.False(t, ok)
+	assert.Len(t, warnings, 7)
+	assert.Contains(t, warnings[0].Message, "stale hint (@protolock:renamed-from Stream)")
+
+	SetStrict(false)
+	warnings, ok = NoStaleHints(upd, upd)
+	SetStrict(true)
+	assert.True(t, ok)
+	assert.Nil(t, warnings)
+
+	warnings, ok = NoRenamingOrMovingDefinitions(upd, upd)
+	assert.True(t, ok)
+	assert.Len(t, warnings, 0)
+}
+
+func TestApplyDeclaredRenames(t *testing.T) {
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", beforeRenamesProto),
+	}}
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", afterRenamesProto),
+	}}
+
+	renamed := applyDeclaredRenames(cur, upd)
+	def := renamed.Definitions[0].Def
+	require.Len(t, def.Messages, 1)
+	assert.Equal(t, "Channel", def.Messages[0].Name)
+	assert.Equal(t, "name", def.Messages[0].Fields[1].Name)
+	assert.Equal(t, "Entry", def.Messages[0].Messages[0].Name)
+	assert.Equal(t, "Channel.Kind", def.Enums[0].Name)
+	assert.Equal(t, "AUDIO", def.Enums[0].EnumFields[1].Name)
+	assert.Equal(t, "ChannelChanger", def.Services[0].Name)
+	assert.Equal(t, "Next", def.Services[0].RPCs[0].Name)
+	assert.Equal(t, "Channel", def.Services[0].RPCs[0].InType)
+
+	// the original lock must not be modified
+	assert.Equal(t, "Stream", cur.Definitions[0].Def.Messages[0].Name)
+}
@@ -1,11 +1,35 @@
 package protolock
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
 )
 
+const (
+	// FormatText renders a report as one "CONFLICT" line per warning.
+	FormatText = "text"
+
+	// FormatJSON renders a report as the JSON encoding of the Report.
+	FormatJSON = "json"
+
+	// FormatSARIF renders a report as a SARIF 2.1.0 log, for code scanning.
+	FormatSARIF = "sarif"
+
+	// FormatJUnit renders a report as JUnit XML, with a test case per rule.
+	FormatJUnit = "junit"
+
+	// FormatMarkdown renders a report as a Markdown table, e.g. for a pull
+	// request comment.
+	FormatMarkdown = "markdown"
+)
+
+// ErrUnknownReportFormat indicates that a report format other than those
+// supported by WriteReport was requested.
+var ErrUnknownReportFormat = errors.New("unknown report format, use text, json, sarif, junit or markdown")
+
 // HandleReport checks a report for warnigs and writes warnings to an io.Writer.
 // The returned int (an exit code) is 1 if warnings are encountered.
 func HandleReport(report *Report, w io.Writer, err error) (int, error) {
@@ -13,19 +37,66 @@ func HandleReport(report *Report, w io.Writer, err error) (int, error) {
 		// sort the warnings so they are grouped by file location
 		orderByPathAndMessage(report.Warnings)
 
-		for _, warning := range report.Warnings {
-			fmt.Fprintf(
-				w,
-				"CONFLICT: %s [%s]\n",
-				warning.Message, warning.Filepath,
-			)
-		}
+		writeTextReport(report, w)
 		return 1, err
 	}
 
 	return 0, err
 }
 
+// WriteReport renders a report in the given format to an io.Writer. Unlike
+// HandleReport, a report is written even if there are no warnings, so that
+// every format produces a valid (possibly empty) document. The report is
+// scored by risk (see ScoreReport), and the JSON, SARIF and Markdown formats
+// list the riskiest warnings first, while the text and JUnit formats group
+// them by file location.
+func WriteReport(report *Report, format string, w io.Writer) error {
+	ScoreReport(report)
+	switch format {
+	case FormatJSON, FormatSARIF, FormatMarkdown:
+		orderByRisk(report.Warnings)
+	default:
+		orderByPathAndMessage(report.Warnings)
+	}
+
+	switch format {
+	case FormatText:
+		writeTextReport(report, w)
+		return nil
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case FormatSARIF:
+		return writeSARIFReport(report, w)
+	case FormatJUnit:
+		return writeJUnitReport(report, w)
+	case FormatMarkdown:
+		return writeMarkdownReport(report, w)
+	default:
+		return ErrUnknownReportFormat
+	}
+}
+
+func writeTextReport(report *Report, w io.Writer) {
+	for _, warning := range report.Warnings {
+		conflict := "CONFLICT"
+		if warning.Mode != "" {
+			conflict += " (" + warning.Mode + ")"
+		}
+		fmt.Fprintf(
+			w,
+			"%s: %s [%s]\n",
+			conflict, warning.Message, warning.Filepath,
+		)
+	}
+
+	// the overall risk is only known once the report is scored
+	if report.Risk != nil && len(report.Warnings) > 0 {
+		fmt.Fprintf(w, "RISK: %s (%d)\n", report.Risk.Level, report.Risk.Score)
+	}
+}
+
 func orderByPathAndMessage(warnings []Warning) {
 	sort.Slice(warnings, func(i, j int) bool {
 		if warnings[i].Filepath < warnings[j].Filepath {
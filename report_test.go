@@ -0,0 +1,140 @@
+package protolock
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var reportWarnings = []Warning{
+	{
+		Filepath: Protopath("path/to/b.proto"),
+		Message:  `"Channel" is missing ID: 108, which had been reserved`,
+		RuleName: "NoRemovingReservedFields",
+	},
+	{
+		Filepath: Protopath("path/to/a.proto"),
+		Message:  `"ChannelChanger" is missing RPC: "Next", which should be available`,
+		RuleName: "NoRemovingRPCs",
+	},
+	{
+		Filepath: Protopath("path/to/a.proto"),
+		Message:  "A sample warning!",
+	},
+}
+
+func TestHandleReport(t *testing.T) {
+	report := &Report{Warnings: append([]Warning{}, reportWarnings...)}
+
+	// the default output is grouped by file location, without risk scores
+	buf := &bytes.Buffer{}
+	code, err := HandleReport(report, buf, ErrWarningsFound)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, ErrWarningsFound, err)
+	assert.Equal(t,
+		"CONFLICT: \"ChannelChanger\" is missing RPC: \"Next\", which should be available [path/to/a.proto]\n"+
+			"CONFLICT: A sample warning! [path/to/a.proto]\n"+
+			"CONFLICT: \"Channel\" is missing ID: 108, which had been reserved [path/to/b.proto]\n",
+		buf.String(),
+	)
+
+	code, err = HandleReport(&Report{}, buf, nil)
+	assert.Equal(t, 0, code)
+	assert.NoError(t, err)
+}
+
+func TestWriteReportText(t *testing.T) {
+	report := &Report{Warnings: append([]Warning{}, reportWarnings...)}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(report, FormatText, buf))
+	assert.Equal(t,
+		"CONFLICT: \"ChannelChanger\" is missing RPC: \"Next\", which should be available [path/to/a.proto]\n"+
+			"CONFLICT: A sample warning! [path/to/a.proto]\n"+
+			"CONFLICT: \"Channel\" is missing ID: 108, which had been reserved [path/to/b.proto]\n"+
+			"RISK: high (62)\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.NoError(t, WriteReport(&Report{}, FormatText, buf))
+	assert.Empty(t, buf.String())
+
+	assert.Equal(t, ErrUnknownReportFormat, WriteReport(report, "yaml", buf))
+}
+
+func TestWriteReportSARIF(t *testing.T) {
+	report := &Report{Warnings: append([]Warning{}, reportWarnings...)}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(report, FormatSARIF, buf))
+
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	assert.Len(t, log.Runs[0].Tool.Driver.Rules, len(Rules)+1)
+	require.Len(t, log.Runs[0].Results, 3)
+	// the riskiest results are listed first
+	assert.Equal(t, "NoRemovingRPCs", log.Runs[0].Results[0].RuleID)
+	assert.Equal(t, "NoRemovingReservedFields", log.Runs[0].Results[1].RuleID)
+	assert.Equal(t, "Plugin", log.Runs[0].Results[2].RuleID)
+	assert.Equal(t, "error", log.Runs[0].Results[0].Level)
+	assert.Equal(t, "warning", log.Runs[0].Results[2].Level)
+	assert.Equal(t, "high", log.Runs[0].Properties["riskLevel"])
+	assert.Equal(t,
+		"path/to/a.proto",
+		log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI,
+	)
+
+	buf.Reset()
+	require.NoError(t, WriteReport(&Report{}, FormatSARIF, buf))
+	assert.Contains(t, buf.String(), `"results": []`)
+}
+
+func TestWriteReportJUnit(t *testing.T) {
+	report := &Report{Warnings: append([]Warning{}, reportWarnings...)}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(report, FormatJUnit, buf))
+
+	var suites junitTestSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &suites))
+	require.Len(t, suites.Suites, 1)
+	assert.Equal(t, len(Rules)+1, suites.Suites[0].Tests)
+	assert.Equal(t, 3, suites.Suites[0].Failures)
+	for _, tc := range suites.Suites[0].Cases {
+		if tc.Name == "NoChangingFieldIDs" {
+			assert.Nil(t, tc.Failure)
+		}
+		if tc.Name == "NoRemovingRPCs" {
+			require.NotNil(t, tc.Failure)
+			assert.Contains(t, tc.Failure.Text, `missing RPC: "Next"`)
+		}
+	}
+}
+
+func TestWriteReportMarkdown(t *testing.T) {
+	report := &Report{Warnings: append([]Warning{}, reportWarnings...)}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(report, FormatMarkdown, buf))
+	assert.Equal(t,
+		"### protolock: high risk (62)\n\n"+
+			"3 warning(s) found.\n\n"+
+			"| Risk | Rule | File | Warning |\n"+
+			"| --- | --- | --- | --- |\n"+
+			"| high (60) | NoRemovingRPCs | `path/to/a.proto` | \"ChannelChanger\" is missing RPC: \"Next\", which should be available |\n"+
+			"| high (55) | NoRemovingReservedFields | `path/to/b.proto` | \"Channel\" is missing ID: 108, which had been reserved |\n"+
+			"| medium (40) | Plugin | `path/to/a.proto` | A sample warning! |\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.NoError(t, WriteReport(&Report{}, FormatMarkdown, buf))
+	assert.Equal(t, "### protolock: no warnings\n", buf.String())
+}
@@ -0,0 +1,105 @@
+package protolock
+
+import (
+	"strings"
+)
+
+// typeKind identifies the kind of a named type within a Protolock.
+type typeKind string
+
+const (
+	kindMessage typeKind = "message"
+	kindEnum    typeKind = "enum"
+)
+
+// lockType is a named message or enum, located within a Protolock.
+type lockType struct {
+	Name     string
+	Package  string
+	Kind     typeKind
+	Filepath Protopath
+	Message  Message
+	Enum     Enum
+}
+
+// typeIndex:
+// table of fully-qualified type name (without leading ".") -> lockType
+// i.e.
+/*
+	["test.Channel"]	->	{Kind: message, Filepath: "test.proto", ...}
+	["test.Channel.A"]	->	{Kind: message, Filepath: "test.proto", ...}
+	["test.TestEnum"]	->	{Kind: enum, Filepath: "test.proto", ...}
+*/
+type typeIndex map[string]lockType
+
+// getTypeIndex collects every message (including nested messages) and enum
+// in the lock, keyed by their fully-qualified names.
+func getTypeIndex(lock Protolock) typeIndex {
+	index := make(typeIndex)
+	for _, def := range lock.Definitions {
+		pkg := def.Def.Package.Name
+		for _, msg := range def.Def.Messages {
+			indexMessage(index, def.Filepath, pkg, qualify(pkg, msg.Name), msg)
+		}
+		for _, enum := range def.Def.Enums {
+			index[qualify(pkg, enum.Name)] = lockType{
+				Name:     qualify(pkg, enum.Name),
+				Package:  pkg,
+				Kind:     kindEnum,
+				Filepath: def.Filepath,
+				Enum:     enum,
+			}
+		}
+	}
+
+	return index
+}
+
+func indexMessage(index typeIndex, path Protopath, pkg, name string, msg Message) {
+	index[name] = lockType{
+		Name:     name,
+		Package:  pkg,
+		Kind:     kindMessage,
+		Filepath: path,
+		Message:  msg,
+	}
+	for _, m := range msg.Messages {
+		indexMessage(index, path, pkg, name+nestedPrefix+m.Name, m)
+	}
+}
+
+// resolve finds the fully-qualified name of typ, as referenced from within
+// scope (a package name, optionally followed by enclosing message names),
+// following the protobuf scoping rules: the innermost scope is searched
+// first, then each enclosing scope in turn.
+func (index typeIndex) resolve(scope, typ string) (string, bool) {
+	if strings.HasPrefix(typ, nestedPrefix) {
+		name := strings.TrimPrefix(typ, nestedPrefix)
+		_, ok := index[name]
+		return name, ok
+	}
+
+	for {
+		name := qualify(scope, typ)
+		if _, ok := index[name]; ok {
+			return name, true
+		}
+		if scope == "" {
+			return "", false
+		}
+		i := strings.LastIndex(scope, nestedPrefix)
+		if i < 0 {
+			scope = ""
+			continue
+		}
+		scope = scope[:i]
+	}
+}
+
+// qualify joins a scope and a name using the nested type separator.
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + nestedPrefix + name
+}
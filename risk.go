@@ -0,0 +1,297 @@
+package protolock
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The risk levels of warnings and reports, from the lowest to the highest.
+const (
+	RiskLow      = "low"
+	RiskMedium   = "medium"
+	RiskHigh     = "high"
+	RiskCritical = "critical"
+)
+
+// The stability levels of packages, see PackageStability.
+const (
+	StabilityStable = "stable"
+	StabilityBeta   = "beta"
+	StabilityAlpha  = "alpha"
+)
+
+// Risk scores a warning, or a whole report, from 0 to 100 to prioritise its
+// review.
+type Risk struct {
+	Score int    `json:"score"`
+	Level string `json:"level"`
+}
+
+// ruleSeverity ranks the rules from 1 (hygiene) to 3 (breaks consumers at
+// runtime). Warnings of other rules, i.e. of plugins, rank 2.
+var ruleSeverity = map[string]int{
+	"NoUsingReservedFields":          3,
+	"NoRemovingFieldsWithoutReserve": 3,
+	"NoChangingFieldIDs":             3,
+	"NoChangingFieldTypes":           3,
+	"NoRemovingRPCs":                 3,
+	"NoChangingRPCSignature":         3,
+	"NoConflictingHTTPRoutes":        3,
+	"NoRemovingReservedFields":       2,
+	"NoChangingFieldNames":           2,
+	"NoChangingImplicitEnumDefaults": 2,
+	"NoRenamingOrMovingDefinitions":  2,
+	"NoDanglingHTTPFieldPaths":       2,
+	"NoLocalizingReferencedTypes":    2,
+	"NoStaleHints":                   1,
+	"NoInconsistentPackages":         1,
+	"NoUsingDeprecatedTypes":         1,
+	"NoFieldNumbersOutsideRanges":    1,
+	"NoForbiddenPackageDependencies": 1,
+}
+
+// packageVersion matches the version of a package, e.g. "v1" or "v2beta1".
+var packageVersion = regexp.MustCompile(`(^|\.)v\d+(alpha|beta)?\d*$`)
+
+// riskLevel returns the level of a score.
+func riskLevel(score int) string {
+	switch {
+	case score >= 75:
+		return RiskCritical
+	case score >= 50:
+		return RiskHigh
+	case score >= 25:
+		return RiskMedium
+	}
+	return RiskLow
+}
+
+// ScoreReport sets the Risk of each warning of the report, and that of the
+// report: the score of its riskiest warning, raised by 2 for each other
+// warning of a high or critical level. Each warning scores:
+//
+//   - 15 to 45 for the severity of its rule,
+//   - 15 if it breaks the wire format, or 5 if it only breaks source code,
+//   - 15 if its definition is reachable from the RPCs of a public service,
+//   - 3 for each RPC referencing it (up to 15),
+//   - 10 for a stable package, or 5 for a beta package,
+//
+// and is halved if its definition is deprecated.
+func ScoreReport(report *Report) {
+	scorer := newRiskScorer(report.Current, report.Updated)
+	score, raised := 0, 0
+	for i := range report.Warnings {
+		r := scorer.score(report.Warnings[i])
+		report.Warnings[i].Risk = &r
+		if r.Score > score {
+			score = r.Score
+		}
+		if r.Level == RiskHigh || r.Level == RiskCritical {
+			raised++
+		}
+	}
+	if len(report.Warnings) == 0 {
+		report.Risk = &Risk{Score: 0, Level: RiskLow}
+		return
+	}
+
+	// the riskiest warning is not counted twice
+	if riskLevel(score) == RiskHigh || riskLevel(score) == RiskCritical {
+		raised--
+	}
+	score += 2 * raised
+	if score > 100 {
+		score = 100
+	}
+	report.Risk = &Risk{Score: score, Level: riskLevel(score)}
+}
+
+// orderByRisk sorts the warnings by decreasing risk, then by file location.
+func orderByRisk(warnings []Warning) {
+	riskOf := func(w Warning) int {
+		if w.Risk == nil {
+			return 0
+		}
+		return w.Risk.Score
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if ri, rj := riskOf(warnings[i]), riskOf(warnings[j]); ri != rj {
+			return ri > rj
+		}
+		if warnings[i].Filepath != warnings[j].Filepath {
+			return warnings[i].Filepath < warnings[j].Filepath
+		}
+		return warnings[i].Message < warnings[j].Message
+	})
+}
+
+// riskScorer relates the definitions of the updated Protolock (or of the
+// current one, for those which have been removed) to the RPCs of the public
+// services referencing them.
+type riskScorer struct {
+	packages map[Protopath]string
+	index    typeIndex
+	// rpcs counts the RPCs of public services referencing each message or
+	// enum, directly or through the fields of other messages, and the RPCs of
+	// each public service.
+	rpcs map[string]int
+}
+
+func newRiskScorer(current, updated Protolock) riskScorer {
+	s := riskScorer{
+		packages: filePackages(current, updated),
+		index:    getTypeIndex(current),
+		rpcs:     make(map[string]int),
+	}
+	for name, t := range getTypeIndex(updated) {
+		s.index[name] = t
+	}
+
+	seen := make(map[string]bool)
+	for _, lock := range []Protolock{updated, current} {
+		for _, def := range lock.Definitions {
+			pkg := def.Def.Package.Name
+			if settings.Risk.internal(pkg) {
+				continue
+			}
+			for _, svc := range def.Def.Services {
+				name := qualify(pkg, svc.Name)
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				s.rpcs[name] = len(svc.RPCs)
+				for _, rpc := range svc.RPCs {
+					reached := make(map[string]bool)
+					for _, typ := range []string{rpc.InType, rpc.OutType} {
+						if target, ok := s.index.resolve(pkg, typ); ok {
+							s.reach(target, reached)
+						}
+					}
+					for target := range reached {
+						s.rpcs[target]++
+					}
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+// reach collects the message or enum name, and the types of the fields of a
+// message, recursively.
+func (s riskScorer) reach(name string, reached map[string]bool) {
+	if reached[name] {
+		return
+	}
+	reached[name] = true
+
+	t := s.index[name]
+	if t.Kind != kindMessage {
+		return
+	}
+	for _, f := range t.Message.Fields {
+		if target, ok := s.index.resolve(name, f.Type); ok {
+			s.reach(target, reached)
+		}
+	}
+	for _, mp := range t.Message.Maps {
+		if target, ok := s.index.resolve(name, mp.Field.Type); ok {
+			s.reach(target, reached)
+		}
+	}
+}
+
+func (s riskScorer) score(w Warning) Risk {
+	severity, ok := ruleSeverity[w.RuleName]
+	if !ok {
+		severity = 2
+	}
+	score := 15 * severity
+
+	wire, compatibility := compatibilityRules[w.RuleName]
+	switch {
+	case w.Category == CategoryWire || (wire && w.Category != CategorySource):
+		score += 15
+	case w.Category == CategorySource || compatibility:
+		score += 5
+	}
+
+	pkg, name := warningEntity(s.packages, w)
+	if rpcs := s.rpcs[name]; rpcs > 0 {
+		score += 15
+		if rpcs > 5 {
+			rpcs = 5
+		}
+		score += 3 * rpcs
+	}
+
+	switch settings.Risk.stability(pkg) {
+	case StabilityStable:
+		score += 10
+	case StabilityBeta:
+		score += 5
+	}
+
+	if t, ok := s.index[name]; ok && isDeprecatedType(t) {
+		score /= 2
+	}
+
+	if score > 100 {
+		score = 100
+	}
+	return Risk{Score: score, Level: riskLevel(score)}
+}
+
+// internal reports whether the services of package pkg are not public.
+func (s RiskSettings) internal(pkg string) bool {
+	for _, pattern := range s.Internal {
+		if matchPattern(pattern, pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stability returns the stability level of package pkg: that assigned by the
+// first matching PackageStability, or else that of its version, e.g. "v1" is
+// stable, "v1beta1" is beta and "v1alpha" is alpha. A package without a
+// version is considered stable.
+func (s RiskSettings) stability(pkg string) string {
+	for _, ps := range s.Stability {
+		if matchPattern(ps.Package, pkg) {
+			return ps.Level
+		}
+	}
+
+	version := packageVersion.FindStringSubmatch(pkg)
+	if version == nil {
+		return StabilityStable
+	}
+	switch version[2] {
+	case "alpha":
+		return StabilityAlpha
+	case "beta":
+		return StabilityBeta
+	}
+	return StabilityStable
+}
+
+// validateRisk checks that the Settings only assign known stability levels.
+func validateRisk(s Settings) error {
+	for i, ps := range s.Risk.Stability {
+		switch ps.Level {
+		case StabilityStable, StabilityBeta, StabilityAlpha:
+		default:
+			return fmt.Errorf("risk.stability[%d]: unknown level %q, use one of %s",
+				i, ps.Level, strings.Join([]string{StabilityStable, StabilityBeta, StabilityAlpha}, ", "),
+			)
+		}
+	}
+
+	return nil
+}
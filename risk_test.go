@@ -0,0 +1,78 @@
+package protolock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const riskProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  Line line = 1;
+}
+
+message Line {}
+
+message Legacy {
+  option deprecated = true;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (Invoice);
+  rpc GetLine(Line) returns (Line);
+}
+`
+
+func TestScoreReport(t *testing.T) {
+	lock := parseTestLock(t, riskProto)
+	path := OSPath(ProtoPath("billing/v1/invoice.proto"))
+	report := &Report{
+		Current: lock,
+		Updated: lock,
+		Warnings: []Warning{
+			{Filepath: path, RuleName: "NoChangingFieldIDs", Entity: "Legacy", Message: `"Legacy" field: "id" has a different ID: 2, previously 1`},
+			{Filepath: path, RuleName: "NoChangingFieldIDs", Entity: "Line", Message: `"Line" field: "id" has a different ID: 2, previously 1`},
+			{Filepath: path, RuleName: "NoChangingRPCSignature", Entity: "Billing", Message: `"Billing" RPC: "Get" has a different request message: "Line", previously "Invoice"`},
+			{Filepath: path, RuleName: "NoStaleHints", Entity: "Invoice", Message: `"Invoice" has a stale hint`},
+		},
+	}
+	ScoreReport(report)
+
+	var scores []int
+	for _, w := range report.Warnings {
+		require.NotNil(t, w.Risk)
+		scores = append(scores, w.Risk.Score)
+	}
+	// 45 (severity) + 15 (wire) + 10 (stable), halved as deprecated
+	assert.Equal(t, 35, scores[0])
+	// + 15 (reachable) + 2 * 3 (referencing RPCs)
+	assert.Equal(t, 91, scores[1])
+	// 45 + 5 (source) + 15 + 2 * 3 (RPCs of the service) + 10
+	assert.Equal(t, 81, scores[2])
+	// 15 + 15 + 3 + 10
+	assert.Equal(t, 43, scores[3])
+	assert.Equal(t, RiskMedium, report.Warnings[0].Risk.Level)
+	assert.Equal(t, &Risk{Score: 93, Level: RiskCritical}, report.Risk)
+
+	orderByRisk(report.Warnings)
+	assert.Equal(t, 91, report.Warnings[0].Risk.Score)
+	assert.Equal(t, 35, report.Warnings[3].Risk.Score)
+
+	// the services of internal packages are not public
+	defer SetSettings(Settings{})
+	SetSettings(Settings{Risk: RiskSettings{Internal: []string{"billing.*"}}})
+	ScoreReport(report)
+	assert.Equal(t, 70, report.Warnings[0].Risk.Score)
+}
+
+func TestRiskSettingsStability(t *testing.T) {
+	s := RiskSettings{Stability: []PackageStability{{Package: "legacy.*", Level: StabilityBeta}}}
+	assert.Equal(t, StabilityStable, s.stability("billing.v1"))
+	assert.Equal(t, StabilityBeta, s.stability("billing.v2beta1"))
+	assert.Equal(t, StabilityAlpha, s.stability("billing.v1alpha"))
+	assert.Equal(t, StabilityStable, s.stability("billing"))
+	assert.Equal(t, StabilityBeta, s.stability("legacy.v1"))
+}
@@ -10,36 +10,95 @@ var (
 	// are added to this package.
 	Rules = []Rule{
 		{
-			Name: "NoUsingReservedFields",
-			Func: NoUsingReservedFields,
+			Name:           "NoUsingReservedFields",
+			Func:           NoUsingReservedFields,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoRemovingReservedFields",
-			Func: NoRemovingReservedFields,
+			Name:           "NoRemovingReservedFields",
+			Func:           NoRemovingReservedFields,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoRemovingFieldsWithoutReserve",
-			Func: NoRemovingFieldsWithoutReserve,
+			Name:           "NoRemovingFieldsWithoutReserve",
+			Func:           NoRemovingFieldsWithoutReserve,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoChangingFieldIDs",
-			Func: NoChangingFieldIDs,
+			Name:           "NoChangingFieldIDs",
+			Func:           NoChangingFieldIDs,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoChangingFieldTypes",
-			Func: NoChangingFieldTypes,
+			Name:           "NoChangingFieldTypes",
+			Func:           NoChangingFieldTypes,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoChangingFieldNames",
-			Func: NoChangingFieldNames,
+			Name:           "NoChangingFieldNames",
+			Func:           NoChangingFieldNames,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoRemovingRPCs",
-			Func: NoRemovingRPCs,
+			Name:           "NoRemovingRPCs",
+			Func:           NoRemovingRPCs,
+			FollowsRenames: true,
 		},
 		{
-			Name: "NoChangingRPCSignature",
-			Func: NoChangingRPCSignature,
+			Name:           "NoChangingRPCSignature",
+			Func:           NoChangingRPCSignature,
+			FollowsRenames: true,
+		},
+		{
+			Name:           "NoChangingImplicitEnumDefaults",
+			Func:           NoChangingImplicitEnumDefaults,
+			CrossFile:      true,
+			FollowsRenames: true,
+		},
+		{
+			Name:      "NoRenamingOrMovingDefinitions",
+			Func:      NoRenamingOrMovingDefinitions,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoStaleHints",
+			Func:      NoStaleHints,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoInconsistentPackages",
+			Func:      NoInconsistentPackages,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoUsingDeprecatedTypes",
+			Func:      NoUsingDeprecatedTypes,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoFieldNumbersOutsideRanges",
+			Func:      NoFieldNumbersOutsideRanges,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoForbiddenPackageDependencies",
+			Func:      NoForbiddenPackageDependencies,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoConflictingHTTPRoutes",
+			Func:      NoConflictingHTTPRoutes,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoDanglingHTTPFieldPaths",
+			Func:      NoDanglingHTTPFieldPaths,
+			CrossFile: true,
+		},
+		{
+			Name:      "NoLocalizingReferencedTypes",
+			Func:      NoLocalizingReferencedTypes,
+			CrossFile: true,
 		},
 	}
 
@@ -54,6 +113,11 @@ func SetStrict(mode bool) {
 	strict = mode
 }
 
+// StrictMode reports whether strict mode is enabled.
+func StrictMode() bool {
+	return strict
+}
+
 // SetDebug enables the user to toggle debug mode on and off.
 func SetDebug(status bool) {
 	debug = status
@@ -62,6 +126,13 @@ func SetDebug(status bool) {
 type Rule struct {
 	Name string
 	Func RuleFunc
+	// CrossFile is set on rules which relate definitions of different files,
+	// and are only run once all shards have been merged.
+	CrossFile bool
+	// FollowsRenames is set on rules which compare each definition declared
+	// as renamed or moved against its locked predecessor, rather than
+	// treating it as a removal and an addition.
+	FollowsRenames bool
 }
 
 // RuleFunc defines the common signature for a function which can compare
@@ -231,6 +302,7 @@ func NoUsingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   msgName,
 					})
 				}
 			}
@@ -250,6 +322,7 @@ func NoUsingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   msgName,
 					})
 				}
 			}
@@ -272,6 +345,7 @@ func NoUsingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   enumName,
 					})
 				}
 			}
@@ -291,6 +365,7 @@ func NoUsingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   enumName,
 					})
 				}
 			}
@@ -312,6 +387,12 @@ func NoRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 		return nil, true
 	}
 
+	return noRemovingReservedFields(cur, upd)
+}
+
+// noRemovingReservedFields is NoRemovingReservedFields, regardless of strict
+// mode, as the transitive compatibility modes enforce it.
+func noRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 	var warnings []Warning
 	// check that all reserved fields on current Protolock remain in the
 	// updated Protolock
@@ -330,6 +411,7 @@ func NoRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   msgName,
 					})
 				}
 			}
@@ -346,6 +428,7 @@ func NoRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   msgName,
 					})
 				}
 			}
@@ -366,6 +449,7 @@ func NoRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   enumName,
 					})
 				}
 			}
@@ -382,6 +466,7 @@ func NoRemovingReservedFields(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   enumName,
 					})
 				}
 			}
@@ -419,6 +504,7 @@ func NoChangingFieldIDs(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 				}
@@ -445,6 +531,7 @@ func NoChangingFieldIDs(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   enumName,
 						})
 					}
 				}
@@ -482,6 +569,7 @@ func NoChangingFieldTypes(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 
@@ -493,6 +581,7 @@ func NoChangingFieldTypes(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 				}
@@ -515,6 +604,7 @@ func NoChangingFieldTypes(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 				}
@@ -558,6 +648,7 @@ func NoChangingFieldNames(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 				}
@@ -584,6 +675,7 @@ func NoChangingFieldNames(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   enumName,
 						})
 					}
 				}
@@ -624,6 +716,7 @@ func NoRemovingRPCs(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   svcName,
 					})
 				}
 			}
@@ -671,6 +764,9 @@ func NoRemovingFieldsWithoutReserve(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
+							// only the text formats refer to names
+							Category: CategorySource,
 						})
 					}
 
@@ -688,6 +784,7 @@ func NoRemovingFieldsWithoutReserve(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   msgName,
 						})
 					}
 				}
@@ -723,6 +820,9 @@ func NoRemovingFieldsWithoutReserve(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   enumName,
+							// only the text formats refer to names
+							Category: CategorySource,
 						})
 					}
 
@@ -740,6 +840,7 @@ func NoRemovingFieldsWithoutReserve(cur, upd Protolock) ([]Warning, bool) {
 						warnings = append(warnings, Warning{
 							Filepath: OSPath(path),
 							Message:  msg,
+							Entity:   enumName,
 						})
 					}
 				}
@@ -781,6 +882,7 @@ func NoChangingRPCSignature(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   svcName,
 					})
 				}
 
@@ -792,6 +894,7 @@ func NoChangingRPCSignature(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   svcName,
 					})
 				}
 
@@ -803,6 +906,7 @@ func NoChangingRPCSignature(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   svcName,
 					})
 				}
 
@@ -814,6 +918,7 @@ func NoChangingRPCSignature(cur, upd Protolock) ([]Warning, bool) {
 					warnings = append(warnings, Warning{
 						Filepath: OSPath(path),
 						Message:  msg,
+						Entity:   svcName,
 					})
 				}
 			}
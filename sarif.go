@@ -0,0 +1,179 @@
+package protolock
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolURI      = "https://github.com/nilslice/protolock"
+
+	// pluginRuleName is used to identify warnings which were returned
+	// without a rule name, i.e. by plugins.
+	pluginRuleName = "Plugin"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+	// Properties holds the overall risk of the report.
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+	// Properties holds the risk and the compatibility mode of the warning.
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// writeSARIFReport encodes the report's warnings as a single SARIF run, with a
+// result for each warning.
+func writeSARIFReport(report *Report, w io.Writer) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "protolock",
+				InformationURI: toolURI,
+			},
+		},
+		Results: []sarifResult{},
+	}
+
+	if report.Risk != nil {
+		run.Properties = map[string]interface{}{
+			"risk":      report.Risk.Score,
+			"riskLevel": report.Risk.Level,
+		}
+	}
+
+	for _, name := range reportRuleNames(report) {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               name,
+			ShortDescription: sarifMessage{Text: name},
+		})
+	}
+
+	for _, warning := range report.Warnings {
+		result := sarifResult{
+			RuleID:     warningRuleName(warning),
+			Level:      sarifLevel(warning.Risk),
+			Message:    sarifMessage{Text: warning.Message},
+			Properties: map[string]interface{}{},
+		}
+		if warning.Risk != nil {
+			result.Properties["risk"] = warning.Risk.Score
+			result.Properties["riskLevel"] = warning.Risk.Level
+		}
+		if warning.Mode != "" {
+			result.Properties["mode"] = warning.Mode
+		}
+		if len(result.Properties) == 0 {
+			result.Properties = nil
+		}
+		if warning.Filepath != "" {
+			result.Locations = []sarifLocation{
+				{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{
+							URI: filepath.ToSlash(string(OSPath(warning.Filepath))),
+						},
+					},
+				},
+			}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	})
+}
+
+// reportRuleNames returns the names of all built-in rules, followed by the
+// names of any other rules (e.g. from plugins) which produced a warning.
+func reportRuleNames(report *Report) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, rule := range Rules {
+		names = append(names, rule.Name)
+		seen[rule.Name] = true
+	}
+	for _, warning := range report.Warnings {
+		name := warningRuleName(warning)
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+
+	return names
+}
+
+func warningRuleName(warning Warning) string {
+	if warning.RuleName == "" {
+		return pluginRuleName
+	}
+	return warning.RuleName
+}
+
+// sarifLevel maps the risk of a warning to a SARIF level: the medium risks
+// are warnings and the low risks are notes.
+func sarifLevel(risk *Risk) string {
+	if risk == nil {
+		return "error"
+	}
+	switch risk.Level {
+	case RiskMedium:
+		return "warning"
+	case RiskLow:
+		return "note"
+	}
+	return "error"
+}
@@ -0,0 +1,437 @@
+package protolock
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxGoReferences is the number of references listed in a warning, after
+// which only their count is given.
+const maxGoReferences = 5
+
+// goRemoval is a field, enum value or RPC which is missing from the updated
+// Protolock, along with the Go identifiers generated for it by protoc-gen-go.
+type goRemoval struct {
+	filepath Protopath
+	// subject names the definition as the warnings of the rules do, e.g.
+	// `"Invoice" field: "total"`, entity the message, enum or service it
+	// belongs to, and member its own name within the entity. The warnings
+	// about its removal are those of its rule about the entity which name the
+	// member.
+	subject string
+	entity  string
+	member  string
+	rule    string
+	// importPath is the Go import path of the generated package, if known
+	// from the go_package option.
+	importPath string
+
+	// a field is referenced by its struct field and getter (selectors), and
+	// as a key of a composite literal of its message type; an enum value by
+	// its constant; and an RPC by the client and server methods.
+	selectors   map[string]bool
+	literalType string
+	literalKey  string
+	constant    string
+	method      string
+
+	references []string
+}
+
+// ScanGo finds the Go code within dir which still references the generated
+// Go identifiers of the fields, enum values and RPCs removed between the
+// current and updated Protolock of the report. The warnings about each
+// removal are annotated with the locations of its references. A referenced
+// removal without a warning (e.g. a field which is removed and reserved) is
+// reported by a new warning in strict mode, or if fail is true.
+func ScanGo(report *Report, dir string, fail bool) error {
+	removals := getGoRemovals(report.Current, report.Updated)
+	if len(removals) == 0 {
+		return nil
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// the generated code declares the identifiers, rather than using them
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, ".pb.go") {
+			return nil
+		}
+
+		return scanGoFile(path, removals)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, removal := range removals {
+		if len(removal.references) == 0 {
+			continue
+		}
+		refs := removal.references
+		sort.Strings(refs)
+		if len(refs) > maxGoReferences {
+			refs = append(refs[:maxGoReferences:maxGoReferences],
+				fmt.Sprintf("and %d more", len(removal.references)-maxGoReferences),
+			)
+		}
+		annotation := "referenced by Go code at " + strings.Join(refs, ", ")
+
+		annotated := false
+		for i, w := range report.Warnings {
+			if w.Filepath != OSPath(removal.filepath) || w.RuleName != removal.rule ||
+				w.Entity != removal.entity {
+				continue
+			}
+			// a rule may report several members of the entity
+			if !strings.Contains(w.Message, strconv.Quote(removal.member)) {
+				continue
+			}
+			report.Warnings[i].Message += "; " + annotation
+			annotated = true
+		}
+		if annotated || !fail {
+			continue
+		}
+
+		report.Warnings = append(report.Warnings, Warning{
+			Filepath: OSPath(removal.filepath),
+			Message:  fmt.Sprintf(`%s has been removed, but is %s`, removal.subject, annotation),
+			Entity:   removal.entity,
+			RuleName: "NoRemovingReferencedDefinitions",
+		})
+	}
+
+	return nil
+}
+
+// scanGoFile records the references to each removal within a Go file.
+func scanGoFile(path string, removals []*goRemoval) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	imports := make(map[string]bool)
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+			imports[p] = true
+		}
+	}
+	// code within the generated package uses its identifiers unqualified
+	generatedPackage, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.pb.go"))
+
+	// without the import path of a removal, its references are only found
+	// by their types (composite literals and server methods), as its
+	// selectors and constants would match unrelated code by name alone
+	var candidates []*goRemoval
+	qualified := make(map[*goRemoval]bool)
+	for _, removal := range removals {
+		switch {
+		case imports[removal.importPath] || len(generatedPackage) > 0:
+			qualified[removal] = true
+			candidates = append(candidates, removal)
+		case removal.importPath == "":
+			candidates = append(candidates, removal)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	found := func(removal *goRemoval, pos token.Pos) {
+		p := fset.Position(pos)
+		removal.references = append(removal.references,
+			fmt.Sprintf("%s:%d", p.Filename, p.Line),
+		)
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			for _, removal := range candidates {
+				if !qualified[removal] {
+					continue
+				}
+				if removal.selectors[n.Sel.Name] || removal.method == n.Sel.Name {
+					found(removal, n.Sel.Pos())
+				}
+			}
+
+		case *ast.Ident:
+			// both qualified and unqualified constants
+			for _, removal := range candidates {
+				if qualified[removal] && removal.constant != "" && removal.constant == n.Name {
+					found(removal, n.Pos())
+				}
+			}
+
+		case *ast.FuncDecl:
+			// a server implementing the RPC
+			if n.Recv == nil {
+				break
+			}
+			for _, removal := range candidates {
+				if removal.method != "" && removal.method == n.Name.Name {
+					found(removal, n.Name.Pos())
+				}
+			}
+
+		case *ast.CompositeLit:
+			typ := literalTypeName(n.Type)
+			for _, elt := range n.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				key, ok := kv.Key.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				for _, removal := range candidates {
+					if removal.literalKey == key.Name && removal.literalType == typ {
+						found(removal, key.Pos())
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	return nil
+}
+
+// literalTypeName returns the name of the type of a composite literal, e.g.
+// "Invoice" for both Invoice{} and pb.Invoice{}.
+func literalTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
+
+// getGoRemovals collects the fields (including maps), enum values and RPCs
+// of the current Protolock which are missing from the updated Protolock,
+// within the messages, enums and services which remain. Definitions are
+// matched by their fully-qualified names, so that moved definitions are
+// compared as well.
+func getGoRemovals(cur, upd Protolock) []*goRemoval {
+	var removals []*goRemoval
+	curIndex := getTypeIndex(cur)
+	updIndex := getTypeIndex(upd)
+	importPaths := getGoImportPaths(upd)
+
+	var names []string
+	for name := range curIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		locked := curIndex[name]
+		updated, ok := updIndex[name]
+		if !ok || updated.Kind != locked.Kind {
+			continue
+		}
+		relName := strings.TrimPrefix(name, locked.Package+nestedPrefix)
+		if locked.Package == "" {
+			relName = name
+		}
+
+		if locked.Kind == kindMessage {
+			fields := make(map[string]bool)
+			for _, f := range updated.Message.Fields {
+				fields[f.Name] = true
+			}
+			for _, mp := range updated.Message.Maps {
+				fields[mp.Field.Name] = true
+			}
+
+			var removed []string
+			for _, f := range locked.Message.Fields {
+				removed = append(removed, f.Name)
+			}
+			for _, mp := range locked.Message.Maps {
+				removed = append(removed, mp.Field.Name)
+			}
+			for _, field := range removed {
+				if fields[field] {
+					continue
+				}
+				goName := goCamelCase(field)
+				subject := fieldSubject(relName, field)
+				removals = append(removals, &goRemoval{
+					filepath:    updated.Filepath,
+					subject:     subject,
+					entity:      relName,
+					member:      field,
+					rule:        "NoRemovingFieldsWithoutReserve",
+					importPath:  importPaths[updated.Filepath],
+					selectors:   map[string]bool{goName: true, "Get" + goName: true},
+					literalType: goCamelCase(relName),
+					literalKey:  goName,
+				})
+			}
+			continue
+		}
+
+		values := make(map[string]bool)
+		for _, v := range updated.Enum.EnumFields {
+			values[v.Name] = true
+		}
+		// the values of a nested enum are prefixed by the Go name of its
+		// parent message, rather than of the enum
+		prefix := goCamelCase(relName)
+		if i := strings.LastIndex(relName, nestedPrefix); i >= 0 {
+			prefix = goCamelCase(enumParent(cur, locked, relName[:i]))
+		}
+		for _, v := range locked.Enum.EnumFields {
+			if values[v.Name] {
+				continue
+			}
+			subject := fieldSubject(relName, v.Name)
+			removals = append(removals, &goRemoval{
+				filepath:   updated.Filepath,
+				subject:    subject,
+				entity:     relName,
+				member:     v.Name,
+				rule:       "NoRemovingFieldsWithoutReserve",
+				importPath: importPaths[updated.Filepath],
+				constant:   prefix + "_" + v.Name,
+			})
+		}
+	}
+
+	updServices := make(map[string]Service)
+	for _, def := range upd.Definitions {
+		for _, svc := range def.Def.Services {
+			updServices[qualify(def.Def.Package.Name, svc.Name)] = svc
+		}
+	}
+	for _, def := range cur.Definitions {
+		for _, svc := range def.Def.Services {
+			updated, ok := updServices[qualify(def.Def.Package.Name, svc.Name)]
+			if !ok {
+				continue
+			}
+			rpcs := make(map[string]bool)
+			for _, rpc := range updated.RPCs {
+				rpcs[rpc.Name] = true
+			}
+			for _, rpc := range svc.RPCs {
+				if rpcs[rpc.Name] {
+					continue
+				}
+				removals = append(removals, &goRemoval{
+					filepath:   def.Filepath,
+					subject:    fmt.Sprintf(`"%s" RPC: "%s"`, svc.Name, rpc.Name),
+					entity:     svc.Name,
+					member:     rpc.Name,
+					rule:       "NoRemovingRPCs",
+					importPath: importPaths[def.Filepath],
+					method:     goCamelCase(rpc.Name),
+				})
+			}
+		}
+	}
+
+	return removals
+}
+
+// enumParent finds the name of the parent message of a nested enum, relative
+// to its package. A nested enum is only recorded by the name of its immediate
+// parent, so the parent is looked up within the file of the enum.
+func enumParent(lock Protolock, enum lockType, parent string) string {
+	for _, def := range lock.Definitions {
+		if def.Filepath != enum.Filepath {
+			continue
+		}
+		var find func(prefix string, msgs []Message) string
+		find = func(prefix string, msgs []Message) string {
+			for _, msg := range msgs {
+				if msg.Name == parent {
+					return prefix + msg.Name
+				}
+				if name := find(prefix+msg.Name+nestedPrefix, msg.Messages); name != "" {
+					return name
+				}
+			}
+			return ""
+		}
+		if name := find("", def.Def.Messages); name != "" {
+			return name
+		}
+	}
+
+	return parent
+}
+
+// getGoImportPaths maps the path of each file to the Go import path of its
+// generated package, as declared by its go_package option.
+func getGoImportPaths(lock Protolock) map[Protopath]string {
+	paths := make(map[Protopath]string)
+	for _, def := range lock.Definitions {
+		for _, o := range def.Def.Options {
+			if o.Name == "go_package" {
+				paths[def.Filepath] = strings.SplitN(o.Value, ";", 2)[0]
+			}
+		}
+	}
+
+	return paths
+}
+
+// goCamelCase converts a .proto name to the Go name generated for it by
+// protoc-gen-go, e.g. "total_amount" to "TotalAmount" and "Invoice.Line" to
+// "Invoice_Line".
+func goCamelCase(s string) string {
+	isLower := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isLower(s[i+1]):
+			// skip the "." of ".{{lowercase}}"
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// a leading "_" would not be exported
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isLower(s[i+1]):
+			// skip the "_" of "_{{lowercase}}"
+		case isDigit(c):
+			b = append(b, c)
+		default:
+			if isLower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isLower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+
+	return string(b)
+}
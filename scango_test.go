@@ -0,0 +1,194 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const scanGoLockedProto = `syntax = "proto3";
+package billing.v1;
+
+option go_package = "example.com/gen/billingv1;billingv1";
+
+message Invoice {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    STATUS_PAID = 1;
+  }
+  string id = 1;
+  int64 total_amount = 2;
+  Status status = 3;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (Invoice);
+  rpc Refund(Invoice) returns (Invoice);
+}
+`
+
+const scanGoUpdatedProto = `syntax = "proto3";
+package billing.v1;
+
+option go_package = "example.com/gen/billingv1;billingv1";
+
+message Invoice {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+  }
+  reserved 2;
+  reserved "total_amount";
+  string id = 1;
+  Status status = 3;
+}
+
+service Billing {
+  rpc Get(Invoice) returns (Invoice);
+}
+`
+
+const scanGoClient = `package client
+
+import (
+	"context"
+
+	billingv1 "example.com/gen/billingv1"
+)
+
+func refund(ctx context.Context, c billingv1.BillingClient, inv *billingv1.Invoice) int64 {
+	if inv.GetStatus() == billingv1.Invoice_STATUS_PAID {
+		c.Refund(ctx, &billingv1.Invoice{Id: inv.Id, TotalAmount: 0})
+	}
+	return inv.TotalAmount
+}
+`
+
+// scanGoUnrelated uses the same names, without importing the generated
+// package.
+const scanGoUnrelated = `package other
+
+type Invoice struct{ TotalAmount int64 }
+
+func (i Invoice) Refund() int64 { return i.TotalAmount }
+`
+
+func TestScanGo(t *testing.T) {
+	SetStrict(false)
+	defer SetStrict(true)
+
+	dir, err := ioutil.TempDir("", "scango")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	client := filepath.Join(dir, "client", "client.go")
+	writeTestFile(t, client, scanGoClient)
+	writeTestFile(t, filepath.Join(dir, "other", "other.go"), scanGoUnrelated)
+
+	cur := parseTestLock(t, scanGoLockedProto)
+	upd := parseTestLock(t, scanGoUpdatedProto)
+	report, err := Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+	// the removed enum value is reported, but not the reserved field or the
+	// RPC (as strict mode is disabled)
+	require.Len(t, report.Warnings, 2)
+
+	require.NoError(t, ScanGo(report, dir, false))
+	require.Len(t, report.Warnings, 2)
+	for _, w := range report.Warnings {
+		assert.True(t, strings.HasPrefix(w.Message, `"Invoice.Status" `), w.Message)
+	}
+	assert.Contains(t, report.Warnings[0].Message+report.Warnings[1].Message,
+		"; referenced by Go code at "+client+":10",
+	)
+
+	report, err = Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+	require.NoError(t, ScanGo(report, dir, true))
+	require.Len(t, report.Warnings, 4)
+	messages := make(map[string]bool)
+	for _, w := range report.Warnings[2:] {
+		assert.Equal(t, "NoRemovingReferencedDefinitions", w.RuleName)
+		messages[w.Message] = true
+	}
+	assert.True(t, messages[`"Invoice" field: "total_amount" has been removed, but is referenced by Go code at `+
+		client+":11, "+client+":13"])
+	assert.True(t, messages[`"Billing" RPC: "Refund" has been removed, but is referenced by Go code at `+
+		client+":11"])
+	for _, w := range report.Warnings[2:] {
+		assert.NotEmpty(t, w.Entity)
+	}
+
+	// in strict mode, the warning about the removed RPC is annotated, while
+	// the reserved field is only reported with fail
+	SetStrict(true)
+	report, err = Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+	count := len(report.Warnings)
+	require.NoError(t, ScanGo(report, dir, false))
+	require.Len(t, report.Warnings, count)
+	annotated := 0
+	for _, w := range report.Warnings {
+		if w.RuleName == "NoRemovingRPCs" {
+			assert.Equal(t, `"Billing" is missing RPC: "Refund", which should be available; referenced by Go code at `+
+				client+":11", w.Message)
+		}
+		if strings.Contains(w.Message, "; referenced by Go code at ") {
+			annotated++
+		}
+	}
+	assert.Equal(t, 2, annotated)
+}
+
+func TestScanGoWithoutImportPath(t *testing.T) {
+	SetStrict(false)
+	defer SetStrict(true)
+
+	dir, err := ioutil.TempDir("", "scango")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	other := filepath.Join(dir, "other", "other.go")
+	writeTestFile(t, other, scanGoUnrelated+`
+func build() Invoice { return Invoice{TotalAmount: 1} }
+`)
+
+	withoutOption := func(source string) string {
+		return strings.Replace(source, `option go_package = "example.com/gen/billingv1;billingv1";`, "", 1)
+	}
+	cur := parseTestLock(t, withoutOption(scanGoLockedProto))
+	upd := parseTestLock(t, withoutOption(scanGoUpdatedProto))
+	report, err := Compare(cur, upd)
+	require.Equal(t, ErrWarningsFound, err)
+
+	// the selectors (i.TotalAmount) are not matched by name alone, but the
+	// composite literal and server method are
+	require.NoError(t, ScanGo(report, dir, true))
+	messages := make(map[string]bool)
+	for _, w := range report.Warnings {
+		messages[w.Message] = true
+	}
+	assert.True(t, messages[`"Invoice" field: "total_amount" has been removed, but is referenced by Go code at `+
+		other+":7"])
+	assert.True(t, messages[`"Billing" RPC: "Refund" has been removed, but is referenced by Go code at `+
+		other+":5"])
+}
+
+func TestGoCamelCase(t *testing.T) {
+	assert.Equal(t, "TotalAmount", goCamelCase("total_amount"))
+	assert.Equal(t, "Invoice_Line", goCamelCase("Invoice.Line"))
+	assert.Equal(t, "XId", goCamelCase("_id"))
+	assert.Equal(t, "Field_2", goCamelCase("field_2"))
+	assert.Equal(t, "GetInvoice", goCamelCase("GetInvoice"))
+}
+
+func parseTestLock(t *testing.T, source string) Protolock {
+	entry, err := Parse("invoice.proto", strings.NewReader(source))
+	require.NoError(t, err)
+	return Protolock{Definitions: []Definition{{
+		Filepath: ProtoPath("billing/v1/invoice.proto"),
+		Def:      entry,
+	}}}
+}
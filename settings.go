@@ -0,0 +1,216 @@
+package protolock
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SettingsFileName is the default name of the file configuring the rules.
+const SettingsFileName = "protolock.json"
+
+// Settings configures the behavior of rules which need more than a toggle.
+// It is read from a JSON file, by default SettingsFileName in the lockdir.
+type Settings struct {
+	// Ignore lists the filepaths to ignore, relative to the proto root, when
+	// none are given by --ignore.
+	Ignore       []string           `json:"ignore,omitempty"`
+	Packages     PackageSettings    `json:"packages,omitempty"`
+	Numbers      NumberSettings     `json:"numbers,omitempty"`
+	Dependencies DependencySettings `json:"dependencies,omitempty"`
+	Modes        []ModeAssignment   `json:"modes,omitempty"`
+	Risk         RiskSettings       `json:"risk,omitempty"`
+}
+
+// PackageSettings configures the NoInconsistentPackages rule.
+type PackageSettings struct {
+	// Options lists the file options which must have the same value in all
+	// files of a package, defaulting to DefaultConsistentOptions.
+	Options []string `json:"options,omitempty"`
+	// Exemptions lists the files or packages which are not checked.
+	Exemptions []PackageExemption `json:"exemptions,omitempty"`
+}
+
+// configured reports whether the settings configure the NoInconsistentPackages
+// rule, which is not enforced otherwise.
+func (s PackageSettings) configured() bool {
+	return len(s.Options) > 0 || len(s.Exemptions) > 0
+}
+
+// PackageExemption exempts the files matching both its Package and File
+// patterns (empty patterns match everything) from the listed Checks, which are
+// file option names or CheckDirectory. An empty list exempts from all checks.
+type PackageExemption struct {
+	Package string   `json:"package,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Checks  []string `json:"checks,omitempty"`
+}
+
+// NumberSettings configures the NoFieldNumbersOutsideRanges rule and NextID.
+type NumberSettings struct {
+	// Ranges assigns the numbers of fields and enum values to teams.
+	Ranges []NumberRange `json:"ranges,omitempty"`
+	// Team is the team authoring the changes, usually given by --team rather
+	// than in the file.
+	Team string `json:"team,omitempty"`
+}
+
+// NumberRange assigns the numbers From to To (inclusive) of the messages or
+// enums matching the Type pattern, e.g. "acme.v1.Envelope", to a Team.
+type NumberRange struct {
+	Team string `json:"team,omitempty"`
+	Type string `json:"type,omitempty"`
+	From int    `json:"from,omitempty"`
+	To   int    `json:"to,omitempty"`
+}
+
+// DependencySettings configures the NoForbiddenPackageDependencies rule. A
+// package must not depend on the packages of its Forbidden edges and, if it
+// has any Allowed edges, may only depend on the packages of those.
+type DependencySettings struct {
+	Forbidden []DependencyEdge `json:"forbidden,omitempty"`
+	Allowed   []DependencyEdge `json:"allowed,omitempty"`
+}
+
+// DependencyEdge relates the packages matching the From pattern to the
+// packages matching the To pattern, e.g. "public.*" to "internal.*".
+type DependencyEdge struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// ModeAssignment assigns a compatibility Mode, e.g. ModeFullTransitive, to the
+// definitions of the packages matching the Package pattern and named by the
+// Type pattern, e.g. "acme.events.*". Either pattern may be empty, but not
+// both. If Rules are listed, only those rules are enforced on the definitions.
+// The first assignment matching a definition applies.
+type ModeAssignment struct {
+	Package string   `json:"package,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Mode    string   `json:"mode,omitempty"`
+	Rules   []string `json:"rules,omitempty"`
+}
+
+// RiskSettings configures the risk scores of ScoreReport.
+type RiskSettings struct {
+	// Internal lists the package patterns of the services which are not
+	// public, e.g. "internal.*".
+	Internal []string `json:"internal,omitempty"`
+	// Stability overrides the stability levels of packages, which are
+	// otherwise derived from their versions.
+	Stability []PackageStability `json:"stability,omitempty"`
+}
+
+// PackageStability assigns a stability Level (StabilityStable, StabilityBeta
+// or StabilityAlpha) to the packages matching the Package pattern.
+type PackageStability struct {
+	Package string `json:"package,omitempty"`
+	Level   string `json:"level,omitempty"`
+}
+
+// CheckDirectory is the name of the check that a file's directory matches its
+// package, used in PackageExemption.Checks.
+const CheckDirectory = "directory"
+
+// DefaultConsistentOptions are the file options checked for consistency
+// within a package when none are configured.
+var DefaultConsistentOptions = []string{"go_package", "java_package"}
+
+var settings Settings
+
+// SetSettings enables the user to configure the rules.
+func SetSettings(s Settings) {
+	settings = s
+}
+
+// CurrentSettings returns the Settings configured by SetSettings.
+func CurrentSettings() Settings {
+	return settings
+}
+
+// ReadSettings decodes Settings from JSON.
+func ReadSettings(r io.Reader) (Settings, error) {
+	var s Settings
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return Settings{}, err
+	}
+	err = validateModes(s)
+	if err != nil {
+		return Settings{}, err
+	}
+	err = validateRisk(s)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return s, nil
+}
+
+// LoadSettings reads the Settings file at path. A missing file results in
+// empty Settings.
+func LoadSettings(path string) (Settings, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Settings{}, nil
+	}
+	if err != nil {
+		return Settings{}, err
+	}
+	defer f.Close()
+
+	return ReadSettings(f)
+}
+
+// SettingsFilePath returns the path of the default Settings file.
+func (cfg *Config) SettingsFilePath() string {
+	return filepath.Join(cfg.LockDir, SettingsFileName)
+}
+
+// exempts reports whether the exemption applies to a check of a file in pkg.
+func (e PackageExemption) exempts(pkg string, file Protopath, check string) bool {
+	if e.Package != "" && !matchPattern(e.Package, pkg) {
+		return false
+	}
+	if e.File != "" && !matchPattern(e.File, slashPath(file)) {
+		return false
+	}
+	if len(e.Checks) == 0 {
+		return true
+	}
+	for _, c := range e.Checks {
+		if c == check {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern matches a name against a glob pattern, where a pattern ending
+// in ".*" or "/*" also matches all names below its prefix, e.g. "internal.*"
+// matches "internal.billing.v1".
+func matchPattern(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	for _, sep := range []string{".*", "/*"} {
+		if strings.HasSuffix(pattern, sep) {
+			prefix := strings.TrimSuffix(pattern, "*")
+			if name+sep[:1] == prefix || strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// slashPath converts a Protopath to a forward-slash separated path, as used in
+// the patterns of Settings.
+func slashPath(p Protopath) string {
+	return strings.Replace(string(p), ProtoSep, "/", -1)
+}
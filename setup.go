@@ -0,0 +1,385 @@
+package protolock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The actions Setup takes on each of the files it scaffolds.
+const (
+	SetupCreated     = "created"
+	SetupOverwritten = "overwritten"
+	SetupUpdated     = "updated"
+	SetupSkipped     = "skipped"
+)
+
+// SetupOptions configures Setup.
+type SetupOptions struct {
+	// Force overwrites the files which already exist.
+	Force bool
+	// ProtoRoot is the proto root relative to the lockdir, which is detected
+	// if empty.
+	ProtoRoot string
+}
+
+// SetupFile is a file scaffolded by Setup, with the Action taken.
+type SetupFile struct {
+	Path   string
+	Action string
+	// Note explains the action, e.g. why the file was skipped.
+	Note string
+}
+
+// SetupSummary describes the layout detected by Setup, and the files it
+// created.
+type SetupSummary struct {
+	// ProtoRoot is the proto root used, relative to the lockdir.
+	ProtoRoot string
+	// Roots counts the .proto files of each detected proto root.
+	Roots map[string]int
+	// Vendored lists the detected directories of vendored dependencies,
+	// relative to the lockdir.
+	Vendored []string
+	Files    []SetupFile
+	// GitConfig lists the commands registering the diff and merge drivers
+	// of the proto.lock file, as git does not read them from the repository.
+	GitConfig []string
+}
+
+// vendoredDirs are the names of directories holding dependencies, rather than
+// the definitions of the repository.
+var vendoredDirs = map[string]bool{
+	"vendor":       true,
+	"third_party":  true,
+	"third-party":  true,
+	"external":     true,
+	"node_modules": true,
+}
+
+// setupGitAttributes are the .gitattributes entries of the proto.lock file.
+const setupGitAttributes = `# protolock: name the proto file of each change to proto.lock in diffs, and
+# merge it file by file (see "protolock init --setup")
+proto.lock diff=protolock merge=protolock linguist-generated=true
+`
+
+// setupGitConfig registers the drivers named in setupGitAttributes: the hunk
+// headers of diffs show the "protopath" of the changed definition.
+var setupGitConfig = []string{
+	`git config diff.protolock.xfuncname '^ *"protopath": ".*"'`,
+	`git config merge.protolock.name "protolock lock file merge"`,
+	`git config merge.protolock.driver "protolock merge-driver %O %A %B"`,
+}
+
+const setupPreCommit = `#!/bin/sh
+# protolock: check the .proto files for breaking changes before each commit
+# (generated by "protolock init --setup")
+if git diff --cached --name-only | grep -q '\.proto$'; then
+	protolock status --protoroot %[1]s || exit 1
+fi
+`
+
+const setupGitHubActions = `# Example GitHub Actions workflow (generated by "protolock init --setup"),
+# to be copied into .github/workflows/protolock.yml
+name: protolock
+on: [pull_request]
+jobs:
+  protolock:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - run: go install github.com/nilslice/protolock/cmd/protolock@latest
+      - run: >
+          protolock status --protoroot %[1]s --uptodate
+          --output text=- --output sarif=protolock.sarif --output markdown=protolock.md
+`
+
+const setupGitLabCI = `# Example GitLab CI job (generated by "protolock init --setup"), to be
+# included into .gitlab-ci.yml
+protolock:
+  image: golang:latest
+  script:
+    - go install github.com/nilslice/protolock/cmd/protolock@latest
+    - protolock status --protoroot %[1]s --uptodate --output text=- --output junit=protolock.xml
+  artifacts:
+    when: always
+    reports:
+      junit: protolock.xml
+`
+
+// setupCIDir is the directory of the example CI snippets, within the lockdir.
+const setupCIDir = "protolock-ci"
+
+// Setup scaffolds the adoption of protolock in the repository at the lockdir:
+// it detects the proto roots and the vendored dependencies, then writes the
+// proto.lock file, a starter settings file ignoring the vendored directories,
+// the .gitattributes entries of the proto.lock file, a pre-commit hook (if the
+// lockdir is a git repository) and example CI snippets. Existing files are
+// skipped, unless forced, except .gitattributes, which missing entries are
+// added to.
+func Setup(cfg Config, opts SetupOptions) (*SetupSummary, error) {
+	roots, vendored, err := detectProtoLayout(cfg.LockDir)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &SetupSummary{
+		ProtoRoot: opts.ProtoRoot,
+		Roots:     roots,
+		Vendored:  vendored,
+		GitConfig: setupGitConfig,
+	}
+	if summary.ProtoRoot == "" {
+		summary.ProtoRoot = mainProtoRoot(roots)
+	}
+
+	// the vendored directories within the proto root are ignored
+	var ignores []string
+	for _, dir := range vendored {
+		rel := dir
+		if summary.ProtoRoot != "." {
+			rel = strings.TrimPrefix(dir, summary.ProtoRoot+"/")
+			if rel == dir {
+				continue
+			}
+		}
+		ignores = append(ignores, rel)
+	}
+
+	write := func(name string, content []byte, perm os.FileMode) error {
+		file, err := writeSetupFile(filepath.Join(cfg.LockDir, filepath.FromSlash(name)), content, perm, opts.Force)
+		if err != nil {
+			return err
+		}
+		file.Path = name
+		summary.Files = append(summary.Files, file)
+		return nil
+	}
+
+	lockCfg := cfg
+	lockCfg.ProtoRoot = filepath.Join(cfg.LockDir, filepath.FromSlash(summary.ProtoRoot))
+	lockCfg.Ignore = strings.Join(ignores, ",")
+	lock, err := getUpdatedLock(lockCfg)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = write(LockFileName, b, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = starterSettings(ignores)
+	if err != nil {
+		return nil, err
+	}
+	err = write(SettingsFileName, b, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := addGitAttributes(filepath.Join(cfg.LockDir, ".gitattributes"))
+	if err != nil {
+		return nil, err
+	}
+	summary.Files = append(summary.Files, file)
+
+	hook := []byte(fmt.Sprintf(setupPreCommit, summary.ProtoRoot))
+	if info, err := os.Stat(filepath.Join(cfg.LockDir, ".git")); err == nil && info.IsDir() {
+		err = write(".git/hooks/pre-commit", hook, 0755)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		summary.Files = append(summary.Files, SetupFile{
+			Path:   ".git/hooks/pre-commit",
+			Action: SetupSkipped,
+			Note:   "not a git repository",
+		})
+	}
+
+	for _, snippet := range [][2]string{
+		{"github-actions.yml", setupGitHubActions},
+		{"gitlab-ci.yml", setupGitLabCI},
+	} {
+		err = write(path.Join(setupCIDir, snippet[0]), []byte(fmt.Sprintf(snippet[1], summary.ProtoRoot)), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// writeSetupFile writes a file, unless it exists and is not forced.
+func writeSetupFile(name string, content []byte, perm os.FileMode, force bool) (SetupFile, error) {
+	file := SetupFile{Action: SetupCreated}
+	if _, err := os.Stat(name); err == nil {
+		if !force {
+			file.Action = SetupSkipped
+			file.Note = "already exists, use --force to overwrite"
+			return file, nil
+		}
+		file.Action = SetupOverwritten
+	}
+
+	err := os.MkdirAll(filepath.Dir(name), os.ModePerm)
+	if err != nil {
+		return file, err
+	}
+	err = ioutil.WriteFile(name, content, perm)
+	if err != nil {
+		return file, err
+	}
+	// the permissions of an existing file are not changed by WriteFile
+	return file, os.Chmod(name, perm)
+}
+
+// addGitAttributes adds the setupGitAttributes entries to a .gitattributes
+// file, unless it already has entries for the proto.lock file.
+func addGitAttributes(name string) (SetupFile, error) {
+	file := SetupFile{Path: ".gitattributes", Action: SetupCreated}
+	b, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return file, err
+	}
+	if err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && fields[0] == LockFileName {
+				file.Action = SetupSkipped
+				file.Note = "already has entries for " + LockFileName
+				return file, nil
+			}
+		}
+		file.Action = SetupUpdated
+		if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+	}
+
+	return file, ioutil.WriteFile(name, append(b, setupGitAttributes...), 0644)
+}
+
+// starterSettings returns the settings file written by Setup: the consistent
+// file options of the packages, and the ignored directories.
+func starterSettings(ignores []string) ([]byte, error) {
+	b, err := json.MarshalIndent(struct {
+		Ignore   []string        `json:"ignore,omitempty"`
+		Packages PackageSettings `json:"packages"`
+	}{
+		Ignore:   ignores,
+		Packages: PackageSettings{Options: DefaultConsistentOptions},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// detectProtoLayout walks the directory dir, counting the .proto files of each
+// proto root: the directory from which the path of a file follows its package
+// (e.g. "proto" for "proto/acme/v1/a.proto" of package "acme.v1"), or else
+// the directory of the file. The files within vendored directories are not
+// counted, but the directories are returned. Hidden directories are skipped.
+func detectProtoLayout(dir string) (map[string]int, []string, error) {
+	roots := make(map[string]int)
+	var vendored []string
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if rel != "." && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if vendoredDirs[info.Name()] {
+				if hasProtoFiles(p) {
+					vendored = append(vendored, rel)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), protoSuffix) {
+			return nil
+		}
+
+		fileDir := path.Dir(rel)
+		root := fileDir
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		entry, err := Parse(rel, f)
+		printIfErr(f.Close())
+		if err == nil && entry.Package.Name != "" {
+			pkgDir := strings.Replace(entry.Package.Name, ".", "/", -1)
+			switch {
+			case fileDir == pkgDir:
+				root = "."
+			case strings.HasSuffix(fileDir, "/"+pkgDir):
+				root = strings.TrimSuffix(fileDir, "/"+pkgDir)
+			}
+		}
+		roots[root]++
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return roots, vendored, nil
+}
+
+// hasProtoFiles reports whether the directory dir contains .proto files.
+func hasProtoFiles(dir string) bool {
+	found := false
+	_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		found = !info.IsDir() && strings.HasSuffix(info.Name(), protoSuffix)
+		return nil
+	})
+
+	return found
+}
+
+// mainProtoRoot returns the proto root with the most files (the first in
+// lexical order on a tie), or "." if there are none.
+func mainProtoRoot(roots map[string]int) string {
+	main, count := ".", 0
+	var names []string
+	for root := range roots {
+		names = append(names, root)
+	}
+	sort.Strings(names)
+	for _, root := range names {
+		if roots[root] > count {
+			main, count = root, roots[root]
+		}
+	}
+
+	return main
+}
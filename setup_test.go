@@ -0,0 +1,111 @@
+package protolock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const setupVendoredProto = `syntax = "proto3";
+package google.api;
+
+message HttpRule {
+  string get = 1;
+}
+`
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "proto", "billing", "v1", "invoice.proto"), untrackLegacyProto)
+	writeTestFile(t, filepath.Join(dir, "proto", "billing", "v2", "invoice.proto"), untrackKeptProto)
+	writeTestFile(t, filepath.Join(dir, "proto", "third_party", "google", "api", "http.proto"), setupVendoredProto)
+	writeTestFile(t, filepath.Join(dir, "examples", "example.proto"), untrackKeptProto)
+	writeTestFile(t, filepath.Join(dir, ".gitattributes"), "*.pb.go linguist-generated=true")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), os.ModePerm))
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	summary, err := Setup(*cfg, SetupOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "proto", summary.ProtoRoot)
+	assert.Equal(t, map[string]int{"proto": 2, "examples": 1}, summary.Roots)
+	assert.Equal(t, []string{"proto/third_party"}, summary.Vendored)
+	assert.Equal(t, []SetupFile{
+		{Path: LockFileName, Action: SetupCreated},
+		{Path: SettingsFileName, Action: SetupCreated},
+		{Path: ".gitattributes", Action: SetupUpdated},
+		{Path: ".git/hooks/pre-commit", Action: SetupCreated},
+		{Path: "protolock-ci/github-actions.yml", Action: SetupCreated},
+		{Path: "protolock-ci/gitlab-ci.yml", Action: SetupCreated},
+	}, summary.Files)
+
+	lock := readTestLock(t, *cfg)
+	require.Len(t, lock.Definitions, 2)
+	assert.Equal(t, "billing.v1", lock.Definitions[0].Def.Package.Name)
+	assert.Equal(t, "billing.v2", lock.Definitions[1].Def.Package.Name)
+
+	s, err := LoadSettings(cfg.SettingsFilePath())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"third_party"}, s.Ignore)
+	assert.Equal(t, DefaultConsistentOptions, s.Packages.Options)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, ".gitattributes"))
+	require.NoError(t, err)
+	assert.Equal(t, "*.pb.go linguist-generated=true\n"+setupGitAttributes, string(b))
+
+	hook := filepath.Join(dir, ".git", "hooks", "pre-commit")
+	info, err := os.Stat(hook)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	b, err = ioutil.ReadFile(hook)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "protolock status --protoroot proto")
+
+	// existing files are kept, unless forced
+	writeTestFile(t, cfg.SettingsFilePath(), "{}")
+	summary, err = Setup(*cfg, SetupOptions{ProtoRoot: "examples"})
+	require.NoError(t, err)
+	for _, file := range summary.Files {
+		assert.Equal(t, SetupSkipped, file.Action, file.Path)
+	}
+	b, err = ioutil.ReadFile(cfg.SettingsFilePath())
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+
+	summary, err = Setup(*cfg, SetupOptions{ProtoRoot: "examples", Force: true})
+	require.NoError(t, err)
+	assert.Equal(t, SetupOverwritten, summary.Files[0].Action)
+	assert.Equal(t, SetupSkipped, summary.Files[2].Action)
+	lock = readTestLock(t, *cfg)
+	require.Len(t, lock.Definitions, 1)
+	assert.Equal(t, Protopath("example.proto"), lock.Definitions[0].Filepath)
+	s, err = LoadSettings(cfg.SettingsFilePath())
+	require.NoError(t, err)
+	assert.Empty(t, s.Ignore)
+}
+
+func TestSetupWithoutGit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "invoice.proto"), untrackKeptProto)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	summary, err := Setup(*cfg, SetupOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ".", summary.ProtoRoot)
+	assert.Equal(t, SetupFile{
+		Path:   ".git/hooks/pre-commit",
+		Action: SetupSkipped,
+		Note:   "not a git repository",
+	}, summary.Files[3])
+	_, err = os.Stat(filepath.Join(dir, ".git"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,297 @@
+package protolock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidShard indicates that a shard is not formatted as "i/n", where
+	// 1 <= i <= n.
+	ErrInvalidShard = errors.New(`invalid shard, use "i/n" where 1 <= i <= n`)
+
+	// ErrIncompleteShards indicates that the partial locks or reports being
+	// merged do not cover each shard exactly once.
+	ErrIncompleteShards = errors.New("merge requires exactly one output of each shard")
+)
+
+// Shard identifies one of Count partitions of the proto files in a tree, where
+// Index starts at 1. A file always belongs to the same shard, regardless of
+// the other files in the tree.
+type Shard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// ParseShard parses a shard formatted as "i/n", e.g. "2/4".
+func ParseShard(s string) (Shard, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Shard{}, ErrInvalidShard
+	}
+	i, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Shard{}, ErrInvalidShard
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Shard{}, ErrInvalidShard
+	}
+	if n < 1 || i < 1 || i > n {
+		return Shard{}, ErrInvalidShard
+	}
+
+	return Shard{Index: i, Count: n}, nil
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Contains reports whether the file at path (in Protopath format) belongs to
+// the shard.
+func (s Shard) Contains(path Protopath) bool {
+	h := fnv.New32a()
+	h.Write([]byte(slashPath(path)))
+
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// Sharded reports whether the Config limits processing to a single shard.
+func (cfg *Config) Sharded() bool {
+	return cfg.Shard.Count > 0
+}
+
+// ShardLockFilePath returns the path of the partial lock file written by a
+// commit of the configured shard, e.g. "proto.lock.2-of-4".
+func (cfg *Config) ShardLockFilePath() string {
+	return filepath.Join(cfg.LockDir, fmt.Sprintf(
+		"%s.%d-of-%d", LockFileName, cfg.Shard.Index, cfg.Shard.Count,
+	))
+}
+
+// shardLock returns the partial Protolock of the definitions within a shard.
+func shardLock(lock Protolock, shard Shard) Protolock {
+	partial := Protolock{Shard: &shard}
+	for _, def := range lock.Definitions {
+		if shard.Contains(def.Filepath) {
+			partial.Definitions = append(partial.Definitions, def)
+		}
+	}
+
+	return partial
+}
+
+// fileRules returns the rules which can be run on a shard, as they only relate
+// definitions of the same file.
+func fileRules() []Rule {
+	var rules []Rule
+	for _, rule := range Rules {
+		if !rule.CrossFile {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// crossFileRules returns the rules which are run once all shards are merged.
+func crossFileRules() []Rule {
+	var rules []Rule
+	for _, rule := range Rules {
+		if rule.CrossFile {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// MergeLocks combines the partial Protolocks committed by each shard into the
+// Protolock which a single commit of all files would have produced.
+func MergeLocks(locks []Protolock) (Protolock, error) {
+	err := checkShards(locks)
+	if err != nil {
+		return Protolock{}, err
+	}
+
+	var merged Protolock
+	for _, lock := range locks {
+		merged.Definitions = append(merged.Definitions, lock.Definitions...)
+	}
+	orderDefinitions(merged.Definitions)
+	// the fingerprints of a shard cannot follow the types of other shards
+	setFingerprints(&merged)
+	// each shard carries the same audit trail, from the same proto.lock file
+	merged.Untracked = locks[0].Untracked
+
+	return merged, nil
+}
+
+// MergeReports combines the partial Reports of each shard's status into the
+// Report which a single status of all files would have produced. The rules
+// relating definitions of different files are run on the merged Protolocks.
+// As in Compare, ErrWarningsFound is returned if the Report has any warnings.
+func MergeReports(reports []Report) (*Report, error) {
+	var currents, updates []Protolock
+	for _, report := range reports {
+		currents = append(currents, report.Current)
+		updates = append(updates, report.Updated)
+	}
+	current, err := MergeLocks(currents)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := MergeLocks(updates)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []Warning
+	if len(pendingRenames(current, updated)) > 0 {
+		// hints declaring renames and moves can relate files of
+		// different shards, so the shards could not apply them
+		report, _ := compareRules(current, updated, fileRules())
+		warnings = report.Warnings
+	} else {
+		for _, report := range reports {
+			warnings = append(warnings, report.Warnings...)
+		}
+	}
+
+	report, _ := compareRules(current, updated, crossFileRules())
+	warnings = append(warnings, report.Warnings...)
+	orderByRule(warnings)
+
+	report = &Report{
+		Current:  current,
+		Updated:  updated,
+		Warnings: warnings,
+	}
+	if len(report.Warnings) != 0 {
+		return report, ErrWarningsFound
+	}
+
+	return report, nil
+}
+
+// MergeLockFiles reads the partial lock files committed by each shard, and
+// returns an io.Reader with the merged lock representation data for caller to
+// use as needed.
+func MergeLockFiles(paths []string) (io.Reader, error) {
+	var locks []Protolock
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		lock, err := FromReader(f)
+		printIfErr(f.Close())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		locks = append(locks, lock)
+	}
+
+	merged, err := MergeLocks(locks)
+	if err != nil {
+		return nil, err
+	}
+
+	return readerFromProtolock(&merged)
+}
+
+// MergeReportFiles reads the JSON reports written by the status of each
+// shard, and merges them as MergeReports does.
+func MergeReportFiles(paths []string) (*Report, error) {
+	var reports []Report
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var report Report
+		err = json.Unmarshal(b, &report)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		reports = append(reports, report)
+	}
+
+	return MergeReports(reports)
+}
+
+// checkShards verifies that the partial Protolocks are from each shard of the
+// same partitioning exactly once.
+func checkShards(locks []Protolock) error {
+	if len(locks) == 0 {
+		return ErrIncompleteShards
+	}
+
+	seen := make(map[int]bool)
+	for _, lock := range locks {
+		if lock.Shard == nil || lock.Shard.Count != len(locks) || seen[lock.Shard.Index] {
+			return ErrIncompleteShards
+		}
+		seen[lock.Shard.Index] = true
+
+		for _, def := range lock.Definitions {
+			if !lock.Shard.Contains(def.Filepath) {
+				return fmt.Errorf(
+					"%s does not belong to shard %s", OSPath(def.Filepath), lock.Shard,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// pendingRenames returns the renames and moves declared by hints in the
+// updated Protolock, which have not yet been committed.
+func pendingRenames(cur, upd Protolock) []declaredRename {
+	var pending []declaredRename
+	locked := getLockEntities(cur)
+	for _, r := range getDeclaredRenames(upd) {
+		if r.isPending(locked) {
+			pending = append(pending, r)
+		}
+	}
+
+	return pending
+}
+
+// orderDefinitions sorts definitions in the order the files are found when
+// walking the proto root, i.e. comparing paths one directory at a time.
+func orderDefinitions(defs []Definition) {
+	sort.SliceStable(defs, func(i, j int) bool {
+		a := strings.Split(slashPath(defs[i].Filepath), "/")
+		b := strings.Split(slashPath(defs[j].Filepath), "/")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+// orderByRule sorts warnings in the order of the Rules which reported them.
+func orderByRule(warnings []Warning) {
+	order := make(map[string]int)
+	for i, rule := range Rules {
+		order[rule.Name] = i
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return order[warnings[i].RuleName] < order[warnings[j].RuleName]
+	})
+}
@@ -0,0 +1,166 @@
+package protolock
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const shardBeforeProto = `syntax = "proto3";
+package %s;
+
+message Item {
+  reserved 3;
+  string name = 1;
+  int64 count = 2;
+}
+`
+
+const shardAfterProto = `syntax = "proto3";
+package %s;
+
+message Item {
+  string name = 1;
+  string count = 2;
+  bool used = 3;
+}
+`
+
+func shardTestLocks(t *testing.T) (Protolock, Protolock) {
+	var cur, upd Protolock
+	for _, path := range []string{
+		"a.proto", "a/b.proto", "a/c.proto", "b.proto", "b/d/e.proto", "c.proto",
+	} {
+		pkg := "p" + fmt.Sprint(len(cur.Definitions))
+		cur.Definitions = append(cur.Definitions, parseTestProtoAt(
+			t, ProtoPath(Protopath(path)), fmt.Sprintf(shardBeforeProto, pkg),
+		))
+		upd.Definitions = append(upd.Definitions, parseTestProtoAt(
+			t, ProtoPath(Protopath(path)), fmt.Sprintf(shardAfterProto, pkg),
+		))
+	}
+	// files are walked one directory at a time
+	orderDefinitions(cur.Definitions)
+	orderDefinitions(upd.Definitions)
+
+	return cur, upd
+}
+
+func shardReports(cur, upd Protolock, n int) []Report {
+	var reports []Report
+	for i := 1; i <= n; i++ {
+		shard := Shard{Index: i, Count: n}
+		report, _ := compareRules(
+			shardLock(cur, shard), shardLock(upd, shard), fileRules(),
+		)
+		reports = append(reports, *report)
+	}
+
+	return reports
+}
+
+func TestParseShard(t *testing.T) {
+	shard, err := ParseShard("2/4")
+	assert.NoError(t, err)
+	assert.Equal(t, Shard{Index: 2, Count: 4}, shard)
+	assert.Equal(t, "2/4", shard.String())
+
+	for _, invalid := range []string{"", "2", "0/4", "5/4", "1/0", "a/b", "1/2/3"} {
+		_, err := ParseShard(invalid)
+		assert.Equal(t, ErrInvalidShard, err, invalid)
+	}
+}
+
+func TestShardPartition(t *testing.T) {
+	_, upd := shardTestLocks(t)
+	for _, def := range upd.Definitions {
+		var owners int
+		for i := 1; i <= 3; i++ {
+			if (Shard{Index: i, Count: 3}).Contains(def.Filepath) {
+				owners++
+			}
+		}
+		assert.Equal(t, 1, owners, string(def.Filepath))
+	}
+}
+
+func TestMergeLocks(t *testing.T) {
+	_, upd := shardTestLocks(t)
+	var paths []Protopath
+	for _, def := range upd.Definitions {
+		paths = append(paths, OSPath(def.Filepath))
+	}
+	assert.Equal(t, []Protopath{
+		OSPath(ProtoPath("a/b.proto")), OSPath(ProtoPath("a/c.proto")), "a.proto",
+		OSPath(ProtoPath("b/d/e.proto")), "b.proto", "c.proto",
+	}, paths)
+
+	var partials []Protolock
+	for i := 3; i >= 1; i-- {
+		partials = append(partials, shardLock(upd, Shard{Index: i, Count: 3}))
+	}
+	merged, err := MergeLocks(partials)
+	require.NoError(t, err)
+	assert.Equal(t, upd, merged)
+
+	_, err = MergeLocks(partials[:2])
+	assert.Equal(t, ErrIncompleteShards, err)
+	_, err = MergeLocks([]Protolock{upd})
+	assert.Equal(t, ErrIncompleteShards, err)
+	_, err = MergeLocks(nil)
+	assert.Equal(t, ErrIncompleteShards, err)
+}
+
+func TestMergeReports(t *testing.T) {
+	SetStrict(true)
+	SetSettings(Settings{Packages: PackageSettings{Options: DefaultConsistentOptions}})
+	defer SetSettings(Settings{})
+	cur, upd := shardTestLocks(t)
+
+	full, err := Compare(cur, upd)
+	assert.Equal(t, ErrWarningsFound, err)
+
+	merged, err := MergeReports(shardReports(cur, upd, 3))
+	assert.Equal(t, ErrWarningsFound, err)
+	assert.Equal(t, full.Current, merged.Current)
+	assert.Equal(t, full.Updated, merged.Updated)
+	assert.ElementsMatch(t, full.Warnings, merged.Warnings)
+
+	// the cross-file rules are only run when merging
+	var crossFile int
+	for _, w := range merged.Warnings {
+		if w.RuleName == "NoInconsistentPackages" {
+			crossFile++
+		}
+	}
+	assert.Equal(t, 6, crossFile)
+	for _, report := range shardReports(cur, upd, 3) {
+		for _, w := range report.Warnings {
+			assert.NotEqual(t, "NoInconsistentPackages", w.RuleName)
+		}
+	}
+}
+
+func TestMergeReportsWithMoves(t *testing.T) {
+	SetStrict(true)
+	SetSettings(Settings{Packages: PackageSettings{
+		Exemptions: []PackageExemption{{Package: "test"}},
+	}})
+	defer SetSettings(Settings{})
+
+	cur := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", beforeRenamesProto),
+	}}
+	upd := Protolock{Definitions: []Definition{
+		parseTestProtoAt(t, "test.proto", afterRenamesProto),
+		parseTestProtoAt(t, "moving.proto", movedEnumProto),
+	}}
+	upd.Definitions[1].Def.Enums[0].MovedFrom = "test.proto"
+	orderDefinitions(upd.Definitions)
+
+	full, _ := Compare(cur, upd)
+	merged, _ := MergeReports(shardReports(cur, upd, 2))
+	assert.ElementsMatch(t, full.Warnings, merged.Warnings)
+}
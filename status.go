@@ -17,6 +17,12 @@ func Status(cfg Config) (*Report, error) {
 		return nil, err
 	}
 
+	return statusOf(cfg, updated, Rules)
+}
+
+// statusOf reports on the issues which the rules encounter when comparing the
+// updated definitions with the current proto.lock file.
+func statusOf(cfg Config, updated *Protolock, rules []Rule) (*Report, error) {
 	lockFile, err := openLockFile(cfg)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -31,8 +37,16 @@ func Status(cfg Config) (*Report, error) {
 	if err != nil {
 		return nil, err
 	}
+	current = withoutUntracked(current)
+
+	// a shard is compared against its part of the proto.lock file, and the
+	// rules relating different files are left to MergeReports
+	if cfg.Sharded() {
+		current = shardLock(current, cfg.Shard)
+		rules = fileRules()
+	}
 
-	report, err := Compare(current, *updated)
+	report, err := compareRules(current, *updated, rules)
 	if err != nil {
 		return report, err
 	}
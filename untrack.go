@@ -0,0 +1,196 @@
+package protolock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrMissingReason indicates that definitions were to be untracked without
+// recording the reason.
+var ErrMissingReason = errors.New("untrack requires a reason")
+
+// Untracked records a file whose definitions were deliberately removed from
+// the proto.lock file. If the file reappears, it is compared as a new file,
+// rather than against any definitions locked for it before it reappeared.
+type Untracked struct {
+	Filepath Protopath `json:"protopath,omitempty"`
+	Package  string    `json:"package,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	// Date is the day the file was untracked, formatted as "2006-01-02".
+	Date string `json:"date,omitempty"`
+	// Retracked is the day the file reappeared in the tree, from when its
+	// definitions are locked again.
+	Retracked string `json:"retracked,omitempty"`
+}
+
+// Untrack removes the definitions of the files matching each target from the
+// proto.lock file, and records them as Untracked along with the reason. A
+// target is either a file or directory path relative to the proto root, or a
+// package name, optionally ending in ".*" to include its sub-packages. The
+// files must have been deleted or ignored, as a commit would otherwise lock
+// them again. It returns an io.Reader with the lock representation data for
+// caller to use as needed, and the paths of the untracked files.
+func Untrack(cfg Config, reason string, targets ...string) (io.Reader, []Protopath, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, nil, ErrMissingReason
+	}
+
+	sources, err := getSourcePaths(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		if os.IsNotExist(err) {
+			msg := `no "proto.lock" file found, first run "init"`
+			return nil, nil, errors.New(msg)
+		}
+		return nil, nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	date := time.Now().UTC().Format("2006-01-02")
+	var untracked []Protopath
+	for _, target := range targets {
+		var kept []Definition
+		for _, def := range lock.Definitions {
+			if !untrackMatches(def, target) {
+				kept = append(kept, def)
+				continue
+			}
+			if sources[def.Filepath] {
+				return nil, nil, fmt.Errorf(
+					"%s is still in the tree, delete it or add it to --ignore before untracking it",
+					OSPath(def.Filepath),
+				)
+			}
+			untracked = append(untracked, OSPath(def.Filepath))
+			lock.Untracked = append(lock.Untracked, Untracked{
+				Filepath: def.Filepath,
+				Package:  def.Def.Package.Name,
+				Reason:   reason,
+				Date:     date,
+			})
+		}
+		if len(kept) == len(lock.Definitions) {
+			return nil, nil, fmt.Errorf(
+				"no locked file or package matches %q", target,
+			)
+		}
+		lock.Definitions = kept
+	}
+
+	r, err := readerFromProtolock(&lock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, untracked, nil
+}
+
+// untrackMatches reports whether a Definition is selected by an untrack
+// target, as its package or its path.
+func untrackMatches(def Definition, target string) bool {
+	if def.Def.Package.Name != "" && matchPattern(target, def.Def.Package.Name) {
+		return true
+	}
+
+	path := strings.TrimSuffix(filepath.ToSlash(filepath.Clean(target)), "/")
+	file := slashPath(def.Filepath)
+
+	return file == path || strings.HasPrefix(file, path+"/")
+}
+
+// getSourcePaths returns the paths of the .proto files of the tree which are
+// not ignored, as they are locked.
+func getSourcePaths(cfg Config) (map[Protopath]bool, error) {
+	root, err := filepath.Abs(cfg.ProtoRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	protoFiles, err := getProtoFiles(root, cfg.Ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[Protopath]bool)
+	for _, path := range protoFiles {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, err
+		}
+		paths[ProtoPath(Protopath(rel))] = true
+	}
+
+	return paths, nil
+}
+
+// retrackUntracked records the date on which each Untracked file which is
+// not yet retracked reappears among the sources.
+func retrackUntracked(untracked []Untracked, sources map[Protopath]bool) {
+	date := time.Now().UTC().Format("2006-01-02")
+	for i, u := range untracked {
+		if u.Retracked == "" && sources[u.Filepath] {
+			untracked[i].Retracked = date
+		}
+	}
+}
+
+// withoutUntracked returns the Protolock without the definitions of the files
+// which are untracked and have not reappeared since (e.g. as restored by
+// merging a branch which still locks them), so that a reappearing file is
+// compared as a new file.
+func withoutUntracked(lock Protolock) Protolock {
+	untracked := make(map[Protopath]bool)
+	for _, u := range lock.Untracked {
+		if u.Retracked == "" {
+			untracked[u.Filepath] = true
+		}
+	}
+	if len(untracked) == 0 {
+		return lock
+	}
+
+	var defs []Definition
+	for _, def := range lock.Definitions {
+		if !untracked[def.Filepath] {
+			defs = append(defs, def)
+		}
+	}
+	lock.Definitions = defs
+
+	return lock
+}
+
+// getUntracked reads the files recorded as Untracked by the proto.lock file,
+// if it exists, so that they are carried over by the updated Protolock.
+func getUntracked(cfg Config) ([]Untracked, error) {
+	if !cfg.LockFileExists() {
+		return nil, nil
+	}
+
+	lockFile, err := openLockFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	lock, err := FromReader(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock.Untracked, nil
+}
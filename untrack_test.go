@@ -0,0 +1,157 @@
+package protolock
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const untrackKeptProto = `syntax = "proto3";
+package billing.v2;
+
+message Invoice {
+  string id = 1;
+}
+`
+
+const untrackLegacyProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  reserved 2;
+  string id = 1;
+}
+`
+
+const untrackReappearedProto = `syntax = "proto3";
+package billing.v1;
+
+message Invoice {
+  int64 id = 1;
+  string total = 2;
+}
+`
+
+func writeTestFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func saveTestLock(t *testing.T, cfg Config, r io.Reader) {
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(cfg.LockFilePath(), b, 0644))
+}
+
+func readTestLock(t *testing.T, cfg Config) Protolock {
+	f, err := os.Open(cfg.LockFilePath())
+	require.NoError(t, err)
+	defer f.Close()
+	lock, err := FromReader(f)
+	require.NoError(t, err)
+	return lock
+}
+
+func TestUntrack(t *testing.T) {
+	SetStrict(true)
+	SetSettings(Settings{Packages: PackageSettings{
+		Exemptions: []PackageExemption{{Package: "billing.*"}},
+	}})
+	defer SetSettings(Settings{})
+
+	dir, err := ioutil.TempDir("", "untrack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	legacy := filepath.Join(dir, "billing", "v1", "invoice.proto")
+	writeTestFile(t, legacy, untrackLegacyProto)
+	writeTestFile(t, filepath.Join(dir, "billing", "v2", "invoice.proto"), untrackKeptProto)
+
+	cfg, err := NewConfig(dir, dir, "", false)
+	require.NoError(t, err)
+	_, _, err = Untrack(*cfg, "replaced by billing.v2", "billing.v1")
+	assert.EqualError(t, err, `no "proto.lock" file found, first run "init"`)
+	r, err := Init(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+	initial := readTestLock(t, *cfg)
+
+	_, _, err = Untrack(*cfg, "", "billing.v1")
+	assert.Equal(t, ErrMissingReason, err)
+	_, _, err = Untrack(*cfg, "unused", "billing.v3")
+	assert.Error(t, err)
+
+	// a file still in the tree would be locked again by the next commit
+	_, _, err = Untrack(*cfg, "replaced by billing.v2", "billing.v1")
+	assert.EqualError(t, err, OSPath(ProtoPath("billing/v1/invoice.proto")).String()+
+		" is still in the tree, delete it or add it to --ignore before untracking it")
+	ignored := *cfg
+	ignored.Ignore = "billing/v1"
+	_, _, err = Untrack(ignored, "replaced by billing.v2", "billing.v1")
+	assert.NoError(t, err)
+
+	// removing the file reports the removal of its definitions
+	require.NoError(t, os.Remove(legacy))
+	_, err = Status(*cfg)
+	assert.Equal(t, ErrWarningsFound, err)
+
+	r, paths, err := Untrack(*cfg, "replaced by billing.v2", "billing.v1")
+	require.NoError(t, err)
+	assert.Equal(t, []Protopath{OSPath(ProtoPath("billing/v1/invoice.proto"))}, paths)
+	saveTestLock(t, *cfg, r)
+
+	lock := readTestLock(t, *cfg)
+	require.Len(t, lock.Definitions, 1)
+	require.Len(t, lock.Untracked, 1)
+	assert.Equal(t, ProtoPath("billing/v1/invoice.proto"), lock.Untracked[0].Filepath)
+	assert.Equal(t, "billing.v1", lock.Untracked[0].Package)
+	assert.Equal(t, "replaced by billing.v2", lock.Untracked[0].Reason)
+	assert.NotEmpty(t, lock.Untracked[0].Date)
+
+	report, err := Status(*cfg)
+	assert.NoError(t, err)
+	assert.Len(t, report.Warnings, 0)
+
+	// a reappearing file is new, rather than compared to its old definitions,
+	// even if they were restored (e.g. by a merge)
+	lock.Definitions = initial.Definitions
+	r, err = readerFromProtolock(&lock)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+	writeTestFile(t, legacy, untrackReappearedProto)
+	report, err = Status(*cfg)
+	assert.NoError(t, err)
+	assert.Len(t, report.Warnings, 0)
+
+	// the audit trail is kept by commits, which record the reappearance and
+	// lock the file again
+	r, err = Commit(*cfg)
+	require.NoError(t, err)
+	saveTestLock(t, *cfg, r)
+	lock = readTestLock(t, *cfg)
+	assert.Len(t, lock.Definitions, 2)
+	require.Len(t, lock.Untracked, 1)
+	assert.NotEmpty(t, lock.Untracked[0].Retracked)
+
+	writeTestFile(t, legacy, untrackLegacyProto)
+	_, err = Status(*cfg)
+	assert.Equal(t, ErrWarningsFound, err)
+}
+
+func TestUntrackMatches(t *testing.T) {
+	def := Definition{
+		Filepath: ProtoPath("billing/v1/invoice.proto"),
+		Def:      Entry{Package: Package{Name: "billing.v1"}},
+	}
+	assert.True(t, untrackMatches(def, "billing.v1"))
+	assert.True(t, untrackMatches(def, "billing.*"))
+	assert.True(t, untrackMatches(def, "billing/v1/invoice.proto"))
+	assert.True(t, untrackMatches(def, "billing/v1/"))
+	assert.True(t, untrackMatches(def, "billing"))
+	assert.False(t, untrackMatches(def, "billing.v2"))
+	assert.False(t, untrackMatches(def, "billing/v1/invoice"))
+}
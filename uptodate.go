@@ -11,6 +11,24 @@ func (p *Protolock) Equal(q *Protolock) bool {
 	return isPermutation(p.Definitions, q.Definitions, equalDefinitions)
 }
 
+// WireEqual checks whether the messages of one lockfile have the same wire
+// format as those of another, i.e. the same messages with the same
+// fingerprints. Unlike Equal, it disregards the changes which do not affect
+// the encoding, such as renames, options and comments.
+func (p *Protolock) WireEqual(q *Protolock) bool {
+	a := Fingerprints(*p)
+	b := Fingerprints(*q)
+	if len(a) != len(b) {
+		return false
+	}
+	for name, fingerprint := range a {
+		if b[name] != fingerprint {
+			return false
+		}
+	}
+	return true
+}
+
 // Check whether two slices are equal, ignoring ordering.
 // Uses the provided comparator function to determine equality.
 func isPermutation(as, bs interface{}, cmp func(x, y interface{}) bool) bool {
@@ -84,6 +102,19 @@ func equalEntries(a, b Entry) bool {
 	if a.Package != b.Package {
 		return false
 	}
+	// the syntax is unknown in proto.lock files written before it was
+	// recorded, which are not out-of-date because of it
+	if a.Syntax != "" && b.Syntax != "" && a.Syntax != b.Syntax {
+		return false
+	}
+	// nor because of the other details recorded since then
+	if a.Syntax == "" || b.Syntax == "" {
+		literals := unrecordedLiterals(a, b)
+		a, b = legacyEntry(a, literals), legacyEntry(b, literals)
+	}
+	if a.Edition != b.Edition {
+		return false
+	}
 	if !isPermutation(a.Enums, b.Enums, equalEnums) {
 		return false
 	}
@@ -99,6 +130,127 @@ func equalEntries(a, b Entry) bool {
 	return isPermutation(a.Options, b.Options, equalOptions)
 }
 
+// legacyEntry returns a copy of an Entry without the details which proto.lock
+// files written before the syntax was recorded do not record, where literals
+// are the fields of the option literals recorded without their values (see
+// unrecordedLiterals).
+func legacyEntry(e Entry, literals map[string]bool) Entry {
+	e = mapOptions(e, func(options []Option) []Option {
+		var legacy []Option
+		for _, o := range options {
+			var fields []Option
+			for _, field := range o.Aggregated {
+				if !literals[o.Name+nestedPrefix+field.Name] {
+					fields = append(fields, field)
+				}
+			}
+			o.Aggregated = fields
+			legacy = append(legacy, o)
+		}
+		return legacy
+	})
+	for i := range e.Enums {
+		e.Enums[i].Options = nil
+	}
+	clearUnrecorded(e.Messages)
+
+	return e
+}
+
+// unrecordedLiterals returns the fields of the option literals of the entries
+// which are recorded without their values, as "(option).field", i.e. the
+// message literals and lists which proto.lock files written before the syntax
+// was recorded only record the names of. The other values of these fields are
+// not compared either, as lists are recorded element by element since.
+func unrecordedLiterals(entries ...Entry) map[string]bool {
+	literals := make(map[string]bool)
+	for _, e := range entries {
+		mapOptions(e, func(options []Option) []Option {
+			for _, o := range options {
+				for _, field := range o.Aggregated {
+					if field.Value == "" && len(field.Aggregated) == 0 {
+						literals[o.Name+nestedPrefix+field.Name] = true
+					}
+				}
+			}
+			return options
+		})
+	}
+
+	return literals
+}
+
+func clearUnrecorded(msgs []Message) {
+	for i := range msgs {
+		msgs[i].IsExtend = false
+		msgs[i].Extensions = nil
+		for j := range msgs[i].Fields {
+			msgs[i].Fields[j].OneOf = ""
+			msgs[i].Fields[j].IsRequired = false
+			msgs[i].Fields[j].IsOptional = false
+		}
+		clearUnrecorded(msgs[i].Messages)
+	}
+}
+
+// mapOptions returns a copy of an Entry, in which each list of options has
+// been replaced by fn.
+func mapOptions(e Entry, fn func([]Option) []Option) Entry {
+	e.Options = fn(e.Options)
+
+	enums := make([]Enum, len(e.Enums))
+	for i, enum := range e.Enums {
+		enum.Options = fn(enum.Options)
+		values := make([]EnumField, len(enum.EnumFields))
+		for j, value := range enum.EnumFields {
+			value.Options = fn(value.Options)
+			values[j] = value
+		}
+		enum.EnumFields = values
+		enums[i] = enum
+	}
+	e.Enums = enums
+
+	e.Messages = mapMessageOptions(e.Messages, fn)
+
+	services := make([]Service, len(e.Services))
+	for i, svc := range e.Services {
+		rpcs := make([]RPC, len(svc.RPCs))
+		for j, rpc := range svc.RPCs {
+			rpc.Options = fn(rpc.Options)
+			rpcs[j] = rpc
+		}
+		svc.RPCs = rpcs
+		services[i] = svc
+	}
+	e.Services = services
+
+	return e
+}
+
+func mapMessageOptions(msgs []Message, fn func([]Option) []Option) []Message {
+	mapped := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		msg.Options = fn(msg.Options)
+		fields := make([]Field, len(msg.Fields))
+		for j, f := range msg.Fields {
+			f.Options = fn(f.Options)
+			fields[j] = f
+		}
+		msg.Fields = fields
+		maps := make([]Map, len(msg.Maps))
+		for j, m := range msg.Maps {
+			m.Field.Options = fn(m.Field.Options)
+			maps[j] = m
+		}
+		msg.Maps = maps
+		msg.Messages = mapMessageOptions(msg.Messages, fn)
+		mapped[i] = msg
+	}
+
+	return mapped
+}
+
 func equalImports(i, j interface{}) bool {
 	// Struct has only primitive fields and no slice fields, fall
 	// back to default equality
@@ -129,9 +281,17 @@ func equalMessages(i, j interface{}) bool {
 	a := i.(Message)
 	b := j.(Message)
 
-	if a.Name != b.Name || a.Filepath != b.Filepath {
+	if a.Name != b.Name || a.Filepath != b.Filepath || a.Visibility != b.Visibility {
+		return false
+	}
+	if a.RenamedFrom != b.RenamedFrom || a.MovedFrom != b.MovedFrom {
 		return false
 	}
+	if a.IsExtend != b.IsExtend || !reflect.DeepEqual(a.Extensions, b.Extensions) {
+		return false
+	}
+	// the fingerprints are derived from the definitions, and a lock written
+	// before they were recorded is still up-to-date
 	if !isPermutation(a.Fields, b.Fields, equalFields) {
 		return false
 	}
@@ -157,6 +317,9 @@ func equalEnumFields(i, j interface{}) bool {
 	if a.Name != b.Name || a.Integer != b.Integer {
 		return false
 	}
+	if a.RenamedFrom != b.RenamedFrom {
+		return false
+	}
 	return isPermutation(a.Options, b.Options, equalOptions)
 }
 
@@ -164,7 +327,10 @@ func equalEnums(i, j interface{}) bool {
 	a := i.(Enum)
 	b := j.(Enum)
 
-	if a.Name != b.Name || a.AllowAlias != b.AllowAlias {
+	if a.Name != b.Name || a.AllowAlias != b.AllowAlias || a.Visibility != b.Visibility {
+		return false
+	}
+	if a.MovedFrom != b.MovedFrom {
 		return false
 	}
 	if !isPermutation(a.ReservedIDs, b.ReservedIDs, equalPrimitives) {
@@ -173,6 +339,9 @@ func equalEnums(i, j interface{}) bool {
 	if !isPermutation(a.ReservedNames, b.ReservedNames, equalPrimitives) {
 		return false
 	}
+	if !isPermutation(a.Options, b.Options, equalOptions) {
+		return false
+	}
 	return isPermutation(a.EnumFields, b.EnumFields, equalEnumFields)
 }
 
@@ -193,6 +362,12 @@ func equalFields(i, j interface{}) bool {
 	if a.Type != b.Type || a.IsRepeated != b.IsRepeated {
 		return false
 	}
+	if a.RenamedFrom != b.RenamedFrom || a.OneOf != b.OneOf {
+		return false
+	}
+	if a.IsRequired != b.IsRequired || a.IsOptional != b.IsOptional {
+		return false
+	}
 	return isPermutation(a.Options, b.Options, equalOptions)
 }
 
@@ -203,6 +378,9 @@ func equalServices(i, j interface{}) bool {
 	if a.Name != b.Name || a.Filepath != b.Filepath {
 		return false
 	}
+	if a.RenamedFrom != b.RenamedFrom {
+		return false
+	}
 	return isPermutation(a.RPCs, b.RPCs, equalRPCs)
 }
 
@@ -216,6 +394,9 @@ func equalRPCs(i, j interface{}) bool {
 	if a.InStreamed != b.InStreamed || a.OutStreamed != b.OutStreamed {
 		return false
 	}
+	if a.RenamedFrom != b.RenamedFrom {
+		return false
+	}
 
 	return isPermutation(a.Options, b.Options, equalOptions)
 }
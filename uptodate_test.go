@@ -1,9 +1,13 @@
 package protolock
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsPermutation(t *testing.T) {
@@ -17,3 +21,240 @@ func TestIsPermutation(t *testing.T) {
 		isPermutation(1, 2, equalPrimitives)
 	})
 }
+
+// legacyLocks are the sources of the proto.lock files in testdata/legacy,
+// which were written before the syntax of the files was recorded, and the
+// changes to each source which make its lock out-of-date nonetheless.
+var legacyLocks = []struct {
+	name    string
+	proto   string
+	changed string
+}{
+	{
+		name: "oneof",
+		proto: `syntax = "proto3";
+package legacy;
+
+message Payment {
+  string id = 1;
+  oneof method {
+    string card = 2;
+    string iban = 3;
+  }
+}
+`,
+		changed: `syntax = "proto3";
+package legacy;
+
+message Payment {
+  string id = 1;
+  oneof method {
+    string card = 2;
+    int64 iban = 3;
+  }
+}
+`,
+	},
+	{
+		name: "enum_options",
+		proto: `syntax = "proto3";
+package legacy;
+
+enum Status {
+  option allow_alias = true;
+  option deprecated = true;
+  STATUS_UNSPECIFIED = 0;
+  STATUS_PAID = 1;
+  STATUS_SETTLED = 1;
+}
+`,
+		changed: `syntax = "proto3";
+package legacy;
+
+enum Status {
+  option allow_alias = true;
+  option deprecated = true;
+  STATUS_UNSPECIFIED = 0;
+  STATUS_PAID = 1;
+  STATUS_SETTLED = 2;
+}
+`,
+	},
+	{
+		name: "option_literals",
+		proto: `syntax = "proto3";
+package legacy;
+
+import "google/api/annotations.proto";
+
+message GetRequest {
+  option (legacy.rule) = {
+    tags: ["a", "b"]
+    tags: "c"
+    child { tags: "d" }
+    name: "get"
+  };
+  string name = 1;
+}
+
+message Book {
+  string name = 1;
+}
+
+service Library {
+  rpc GetBook(GetRequest) returns (Book) {
+    option (google.api.http) = {
+      get: "/v1/{name=shelves/*/books/*}"
+      additional_bindings {
+        get: "/v1/{name=books/*}"
+      }
+      additional_bindings {
+        post: "/v1/{name=books/*}:get"
+        body: "*"
+      }
+    };
+  }
+}
+`,
+		changed: `syntax = "proto3";
+package legacy;
+
+import "google/api/annotations.proto";
+
+message GetRequest {
+  option (legacy.rule) = {
+    tags: ["a", "b"]
+    tags: "c"
+    child { tags: "d" }
+    name: "get"
+  };
+  string name = 1;
+}
+
+message Book {
+  string name = 1;
+}
+
+service Library {
+  rpc GetBook(GetRequest) returns (Book) {
+    option (google.api.http) = {
+      get: "/v1/{name=shelves/*/volumes/*}"
+      additional_bindings {
+        get: "/v1/{name=books/*}"
+      }
+      additional_bindings {
+        post: "/v1/{name=books/*}:get"
+        body: "*"
+      }
+    };
+  }
+}
+`,
+	},
+	{
+		name: "labels",
+		proto: `syntax = "proto2";
+package legacy;
+
+message Invoice {
+  required string id = 1;
+  optional int64 total = 2;
+
+  extensions 100 to 199, 1000 to max;
+}
+
+extend Invoice {
+  optional string memo = 100;
+}
+`,
+		changed: `syntax = "proto2";
+package legacy;
+
+message Invoice {
+  required string id = 1;
+  optional int64 total = 2;
+
+  extensions 100 to 199, 1000 to max;
+}
+
+extend Invoice {
+  optional int64 memo = 100;
+}
+`,
+	},
+	{
+		name: "proto3_optional",
+		proto: `syntax = "proto3";
+package legacy;
+
+message Line {
+  optional string sku = 1;
+  string name = 2;
+}
+`,
+		changed: `syntax = "proto3";
+package legacy;
+
+message Line {
+  optional string sku = 1;
+  string name = 3;
+}
+`,
+	},
+}
+
+func readLegacyLock(t *testing.T, name string) Protolock {
+	f, err := os.Open(filepath.Join("testdata", "legacy", name+".lock"))
+	require.NoError(t, err)
+	defer f.Close()
+	lock, err := FromReader(f)
+	require.NoError(t, err)
+	return lock
+}
+
+func TestLegacyLocksUpToDate(t *testing.T) {
+	for _, l := range legacyLocks {
+		path := ProtoPath(Protopath(l.name + ".proto"))
+		cur := readLegacyLock(t, l.name)
+		upd := Protolock{Definitions: []Definition{parseTestProtoAt(t, path, l.proto)}}
+		assert.True(t, cur.Equal(&upd), l.name)
+		// the details recorded since are compared once the lock is committed
+		assert.True(t, upd.Equal(&upd), l.name)
+
+		changed := Protolock{Definitions: []Definition{parseTestProtoAt(t, path, l.changed)}}
+		assert.False(t, cur.Equal(&changed), l.name)
+	}
+}
+
+func TestOneOfsUpToDate(t *testing.T) {
+	cur := parseTestProto(t, legacyLocks[0].proto)
+	upd := parseTestProto(t, strings.Replace(legacyLocks[0].proto, "  oneof method {\n    string card = 2;\n    string iban = 3;\n  }\n",
+		"  string card = 2;\n  string iban = 3;\n", 1))
+	assert.False(t, cur.Equal(&upd))
+}
+
+func TestEnumOptionsUpToDate(t *testing.T) {
+	cur := parseTestProto(t, legacyLocks[1].proto)
+	upd := parseTestProto(t, strings.Replace(legacyLocks[1].proto, "  option deprecated = true;\n", "", 1))
+	assert.False(t, cur.Equal(&upd))
+}
+
+func TestOptionLiteralsUpToDate(t *testing.T) {
+	cur := parseTestProto(t, legacyLocks[2].proto)
+	upd := parseTestProto(t, strings.Replace(legacyLocks[2].proto, `get: "/v1/{name=books/*}"`, `get: "/v2/{name=books/*}"`, 1))
+	assert.False(t, cur.Equal(&upd))
+	upd = parseTestProto(t, strings.Replace(legacyLocks[2].proto, `tags: ["a", "b"]`, `tags: ["a"]`, 1))
+	assert.False(t, cur.Equal(&upd))
+}
+
+func TestLabelsUpToDate(t *testing.T) {
+	cur := parseTestProto(t, legacyLocks[3].proto)
+	upd := parseTestProto(t, strings.Replace(legacyLocks[3].proto, "required string id", "optional string id", 1))
+	assert.False(t, cur.Equal(&upd))
+	upd = parseTestProto(t, strings.Replace(legacyLocks[3].proto, "1000 to max", "1000 to 1999", 1))
+	assert.False(t, cur.Equal(&upd))
+
+	cur = parseTestProto(t, legacyLocks[4].proto)
+	upd = parseTestProto(t, strings.Replace(legacyLocks[4].proto, "optional string sku", "string sku", 1))
+	assert.False(t, cur.Equal(&upd))
+}